@@ -0,0 +1,323 @@
+package statusphere
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	// adminDeadLetterLimit is how many of the most recent dead letters the dashboard shows
+	adminDeadLetterLimit = 20
+	// consumerStatusStaleAfter is how long after a consumer last saved its status that it's
+	// shown as not reporting, as it's probably stopped without saying so
+	consumerStatusStaleAfter = consumerStatusInterval * 3
+)
+
+// DatabaseStats are the size of the database and how many rows are in each table.
+type DatabaseStats struct {
+	SizeBytes int64
+	// FreeBytes is how much of the size is free pages that vacuuming would reclaim.
+	FreeBytes int64
+	Tables    []TableRowCount
+}
+
+// TableRowCount is how many rows a table has.
+type TableRowCount struct {
+	Table string
+	Rows  int64
+}
+
+// AdminStore has what's shown on the admin dashboard.
+type AdminStore interface {
+	GetConsumerStatuses(ctx context.Context) ([]ConsumerStatus, error)
+	GetLeases(ctx context.Context) ([]Lease, error)
+	GetDatabaseStats(ctx context.Context) (DatabaseStats, error)
+	GetDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	GetSessions(ctx context.Context) ([]OAuthSession, error)
+}
+
+// AdminDashboardData is what the admin dashboard is rendered with.
+type AdminDashboardData struct {
+	Admin        string
+	Consumers    []AdminConsumer
+	Leases       []AdminLease
+	DatabaseSize string
+	DatabaseFree string
+	Tables       []TableRowCount
+	DeadLetters  []AdminDeadLetter
+	Sessions     []AdminSession
+	// Error is why the last session couldn't be revoked, if it couldn't.
+	Error string
+}
+
+// AdminConsumer is a consumer's last reported status described for the dashboard.
+type AdminConsumer struct {
+	Name     string
+	Endpoint string
+	Cursor   string
+	State    string
+	// Healthy is set if the consumer is consuming and reporting its status.
+	Healthy bool
+	Updated string
+}
+
+// AdminLease is a lease described for the dashboard.
+type AdminLease struct {
+	Name    string
+	Holder  string
+	Expires string
+	Expired bool
+}
+
+// AdminDeadLetter is a dead letter described for the dashboard.
+type AdminDeadLetter struct {
+	ID       int64
+	Did      string
+	Error    string
+	Attempts int
+	State    string
+	Created  string
+}
+
+// AdminSession is a logged in user's OAuth session described for the dashboard.
+type AdminSession struct {
+	Did       string
+	SessionID string
+	Host      string
+	Scopes    string
+	Created   string
+}
+
+// parseAdminDIDs parses the comma separated list of DIDs in ADMIN_DIDS.
+func parseAdminDIDs(list string) ([]string, error) {
+	var dids []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		did, err := syntax.ParseDID(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DID in ADMIN_DIDS: %w", err)
+		}
+		dids = append(dids, did.String())
+	}
+	return dids, nil
+}
+
+// adminMiddleware only lets through users who are logged in, checked by authMiddleware, as
+// one of the accounts in ADMIN_DIDS. If there are no admin DIDs then admin pages don't exist.
+func (s *Server) adminMiddleware(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	loggedIn := s.authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		did, _ := s.currentSessionDID(r)
+		if did == nil || !slices.Contains(s.adminDIDs, did.String()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		// browsers send cookies with requests from other sites too, so forms can only be
+		// posted from the app's own pages
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !isSameOrigin(r) {
+			http.Error(w, "cross origin request", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), adminDIDKey{}, *did)))
+	})
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.adminDIDs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		loggedIn(w, r)
+	}
+}
+
+// adminDIDKey is the request context key of the DID of the admin making the request.
+type adminDIDKey struct{}
+
+// adminDID returns the DID of the admin making the request, which adminMiddleware sets.
+func adminDID(r *http.Request) syntax.DID {
+	did, _ := r.Context().Value(adminDIDKey{}).(syntax.DID)
+	return did
+}
+
+// isSameOrigin reports whether the request came from the app's own pages, or not from a
+// browser at all.
+func isSameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// HandleAdminDashboard renders the admin dashboard, which shows how the consumers and the
+// database are doing along with recent dead letters and who's logged in.
+func (s *Server) HandleAdminDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := s.now()
+	data := AdminDashboardData{
+		Admin: adminDID(r).String(),
+		Error: r.URL.Query().Get("error"),
+	}
+
+	consumers, err := s.store.GetConsumerStatuses(ctx)
+	if err != nil {
+		slog.Error("get consumer statuses", "error", err)
+		http.Error(w, "failed to get consumer statuses", http.StatusInternalServerError)
+		return
+	}
+	for _, c := range consumers {
+		data.Consumers = append(data.Consumers, describeConsumerStatus(c, now))
+	}
+
+	leases, err := s.store.GetLeases(ctx)
+	if err != nil {
+		slog.Error("get leases", "error", err)
+		http.Error(w, "failed to get leases", http.StatusInternalServerError)
+		return
+	}
+	for _, l := range leases {
+		data.Leases = append(data.Leases, AdminLease{
+			Name:    l.Name,
+			Holder:  l.Holder,
+			Expires: formatModerationTime(l.ExpiresAt),
+			Expired: l.ExpiresAt < now.UnixMilli(),
+		})
+	}
+
+	stats, err := s.store.GetDatabaseStats(ctx)
+	if err != nil {
+		slog.Error("get database stats", "error", err)
+		http.Error(w, "failed to get database stats", http.StatusInternalServerError)
+		return
+	}
+	data.DatabaseSize = formatBytes(stats.SizeBytes)
+	data.DatabaseFree = formatBytes(stats.FreeBytes)
+	data.Tables = stats.Tables
+
+	deadLetters, err := s.store.GetDeadLetters(ctx, adminDeadLetterLimit)
+	if err != nil {
+		slog.Error("get dead letters", "error", err)
+		http.Error(w, "failed to get dead letters", http.StatusInternalServerError)
+		return
+	}
+	for _, d := range deadLetters {
+		state := "gave up"
+		if d.Retryable {
+			state = "retrying " + formatModerationTime(d.NextAttemptAt)
+		}
+		data.DeadLetters = append(data.DeadLetters, AdminDeadLetter{
+			ID:       d.ID,
+			Did:      d.Did,
+			Error:    d.Error,
+			Attempts: d.Attempts,
+			State:    state,
+			Created:  formatModerationTime(d.CreatedAt),
+		})
+	}
+
+	sessions, err := s.store.GetSessions(ctx)
+	if err != nil {
+		slog.Error("get sessions", "error", err)
+		http.Error(w, "failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+	for _, sess := range sessions {
+		created := "unknown"
+		if sess.CreatedAt > 0 {
+			created = formatModerationTime(sess.CreatedAt)
+		}
+		data.Sessions = append(data.Sessions, AdminSession{
+			Did:       sess.Did,
+			SessionID: sess.SessionID,
+			Host:      sess.HostURL,
+			Scopes:    strings.Join(sess.Scopes, " "),
+			Created:   created,
+		})
+	}
+
+	tmpl := s.getTemplate("admin.html")
+	tmpl.Execute(w, data)
+}
+
+// HandleRevokeSession logs a user out of one of their sessions, such as when their account
+// has been compromised, revoking its tokens with their authorization server.
+func (s *Server) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	did, err := syntax.ParseDID(r.FormValue("did"))
+	if err != nil {
+		redirectToAdminDashboard(w, r, "invalid DID: "+err.Error())
+		return
+	}
+	sessionID := r.FormValue("session_id")
+
+	err = RevokeSession(r.Context(), s.oauthClient, did, sessionID)
+	if err != nil {
+		slog.Error("revoke session", "error", err, "did", did, "session id", sessionID)
+		redirectToAdminDashboard(w, r, "failed to revoke session: "+err.Error())
+		return
+	}
+	slog.Info("session revoked", "did", did, "session id", sessionID, "by", adminDID(r))
+	redirectToAdminDashboard(w, r, "")
+}
+
+// describeConsumerStatus describes a consumer's status, showing Jetstream's cursor as the
+// time it's at and how far behind that is.
+func describeConsumerStatus(c ConsumerStatus, now time.Time) AdminConsumer {
+	described := AdminConsumer{
+		Name:     c.Name,
+		Endpoint: c.Endpoint,
+		Cursor:   fmt.Sprint(c.Cursor),
+		Updated:  formatModerationTime(c.UpdatedAt),
+	}
+	if c.Name == "jetstream" && c.Cursor > 0 {
+		cursorTime := time.UnixMicro(c.Cursor)
+		described.Cursor = fmt.Sprintf("%s (%s behind)", cursorTime.UTC().Format("2006-01-02 15:04:05 MST"), now.Sub(cursorTime).Round(time.Second))
+	}
+
+	switch {
+	case !c.Connected:
+		described.State = "stopped"
+	case now.Sub(time.UnixMilli(c.UpdatedAt)) > consumerStatusStaleAfter:
+		described.State = "not reporting"
+	default:
+		described.State = "consuming"
+		described.Healthy = true
+	}
+	return described
+}
+
+func redirectToAdminDashboard(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/admin"
+	if errMsg != "" {
+		target += "?" + url.Values{"error": []string{errMsg}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,173 @@
+package statusphere_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// TestAdminDashboard checks that the dashboard shows a consumer's status, the database's
+// tables and the logged in user's session, and that moderating from it records the admin's
+// DID.
+func TestAdminDashboard(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+
+	err := app.DB.SaveConsumerStatus(ctx, statusphere.ConsumerStatus{
+		Name:      "jetstream",
+		Endpoint:  "wss://jetstream.test/subscribe",
+		Cursor:    time.Now().Add(-time.Minute).UnixMicro(),
+		Connected: true,
+		UpdatedAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		t.Fatalf("save consumer status: %s", err)
+	}
+
+	resp, body := app.Get(t, "/admin")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the admin to see the dashboard, got %d", resp.StatusCode)
+	}
+	for _, want := range []string{"wss://jetstream.test/subscribe", "consuming", "moderationactions", testapp.DID.String()} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the dashboard to show %q", want)
+		}
+	}
+
+	uri := "at://" + testapp.DID.String() + "/xyz.statusphere.status/" + syntax.NewTIDNow(0).String()
+	resp, _ = app.PostForm(t, "/admin/moderation/hide", url.Values{"uri": {uri}, "reason": {"test"}})
+	testapp.ExpectPath(t, resp, "/admin/moderation")
+	actions, err := app.DB.GetModerationActions(ctx, 1)
+	if err != nil {
+		t.Fatalf("get moderation actions: %s", err)
+	}
+	if len(actions) != 1 || actions[0].Subject != uri || actions[0].Actor != testapp.DID.String() {
+		t.Fatalf("expected the hide to be made by the admin's DID, got %+v", actions)
+	}
+}
+
+func TestAdminDashboardLoggedOut(t *testing.T) {
+	app := testapp.New(t)
+
+	resp, _ := app.Get(t, "/admin")
+	testapp.ExpectPath(t, resp, "/login")
+}
+
+// TestAdminRevokeSession logs in from a second browser, which the admin then logs out from
+// the dashboard without logging themselves out.
+func TestAdminRevokeSession(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	admin := app.Browser
+	other := app.NewBrowser(t)
+	app.LogIn(t, other)
+
+	sessions, err := app.DB.GetAccountSessions(context.Background(), testapp.DID)
+	if err != nil {
+		t.Fatalf("get sessions: %s", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected a session for each browser, got %d", len(sessions))
+	}
+	tokens := app.PDS.ActiveTokens(testapp.DID)
+
+	// the other browser logged in last, so its session is first
+	resp, body := app.PostForm(t, "/admin/sessions/revoke", url.Values{"did": {testapp.DID.String()}, "session_id": {sessions[0].SessionID}})
+	testapp.ExpectPath(t, resp, "/admin")
+	if strings.Contains(body, "failed to revoke") {
+		t.Fatal("dashboard says the session wasn't revoked")
+	}
+	if app.PDS.ActiveTokens(testapp.DID) >= tokens {
+		t.Fatal("expected the session's tokens to be revoked with the PDS")
+	}
+
+	app.Browser = other
+	resp, _ = app.PostForm(t, "/status", url.Values{"status": {statusphere.DefaultPalette[0].Status}})
+	testapp.ExpectPath(t, resp, "/login")
+
+	app.Browser = admin
+	resp, _ = app.Get(t, "/admin")
+	testapp.ExpectPath(t, resp, "/admin")
+}
+
+// TestAdminSessionRevoked logs out everywhere from a second browser and checks that the
+// first, which is still logged in as an admin as far as its cookie says, is logged out rather
+// than let in to the admin pages.
+func TestAdminSessionRevoked(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	admin := app.Browser
+	app.Browser = app.NewBrowser(t)
+	app.LogIn(t, app.Browser)
+	app.PostForm(t, "/logout/everywhere", nil)
+
+	app.Browser = admin
+	for _, p := range []string{"/admin", "/admin/moderation", "/admin/palette", "/admin/export"} {
+		resp, _ := app.Get(t, p)
+		testapp.ExpectPath(t, resp, "/login")
+	}
+
+	uri := "at://" + testapp.DID.String() + "/xyz.statusphere.status/" + syntax.NewTIDNow(0).String()
+	resp, _ := app.PostForm(t, "/admin/moderation/hide", url.Values{"uri": {uri}, "reason": {"test"}})
+	testapp.ExpectPath(t, resp, "/login")
+	actions, err := app.DB.GetModerationActions(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("get moderation actions: %s", err)
+	}
+	if len(actions) != 0 {
+		t.Fatalf("expected no moderation actions, got %+v", actions)
+	}
+}
+
+// TestAdminNotAllowed logs in as an account that isn't in ADMIN_DIDS and checks the admin
+// pages are forbidden.
+func TestAdminNotAllowed(t *testing.T) {
+	t.Setenv("ADMIN_DIDS", "did:plc:someoneelse")
+	app := testapp.NewLoggedIn(t)
+
+	for _, p := range []string{"/admin", "/admin/moderation", "/admin/export"} {
+		resp, _ := app.Get(t, p)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected %s to be forbidden, got %d", p, resp.StatusCode)
+		}
+	}
+}
+
+// TestAdminToken checks the admin token lets scripts download exports, but not use the rest
+// of the admin pages.
+func TestAdminToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin-token")
+	app := testapp.New(t)
+
+	get := func(p, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.URL+p, nil)
+		if err != nil {
+			t.Fatalf("create request: %s", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Browser.Do(req)
+		if err != nil {
+			t.Fatalf("get %s: %s", p, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get("/admin/export", "test-admin-token"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the admin token to download an export, got %d", resp.StatusCode)
+	}
+	if resp := get("/admin/export", "wrong-token"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the wrong token to be unauthorized, got %d", resp.StatusCode)
+	}
+	resp := get("/admin/moderation", "test-admin-token")
+	testapp.ExpectPath(t, resp, "/login")
+}
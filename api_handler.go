@@ -0,0 +1,87 @@
+package statusphere
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAPIStatusLimit = 50
+	maxAPIStatusLimit     = 100
+)
+
+type apiStatus struct {
+	URI string `json:"uri"`
+	// Ref is a strong reference to the exact version of the record that's stored, so that
+	// clients can reference it. It's missing if the record's CID isn't known.
+	Ref       *StrongRef `json:"ref,omitempty"`
+	Did       string     `json:"did"`
+	Status    string     `json:"status"`
+	CreatedAt string     `json:"createdAt"`
+	IndexedAt string     `json:"indexedAt"`
+	Rev       string     `json:"rev,omitempty"`
+	// Diverged is true if a different copy of the record was stored before this one.
+	Diverged bool `json:"diverged"`
+	// Flag says why the status's createdAt isn't plausible, in which case it's ordered by
+	// when it was indexed instead.
+	Flag string `json:"flag,omitempty"`
+	// Labels are the values of the labels that labelers have applied to the status or its
+	// account. Statuses with labels that hide them aren't returned at all.
+	Labels []string `json:"labels,omitempty"`
+}
+
+type apiStatusesResp struct {
+	Statuses []apiStatus `json:"statuses"`
+}
+
+// HandleAPIStatuses returns the most recent statuses as JSON, including strong references
+// to the records. The number returned can be set with the limit query parameter.
+func (s *Server) HandleAPIStatuses(w http.ResponseWriter, r *http.Request) {
+	limit := defaultAPIStatusLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > maxAPIStatusLimit {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	statuses, err := s.store.GetStatuses(r.Context(), limit)
+	if err != nil {
+		slog.Error("get statuses", "error", err)
+		http.Error(w, "failed to get statuses", http.StatusInternalServerError)
+		return
+	}
+
+	resp := apiStatusesResp{Statuses: make([]apiStatus, 0, len(statuses))}
+	for _, status := range statuses {
+		item := apiStatus{
+			URI:       status.URI,
+			Did:       status.Did,
+			Status:    status.Status,
+			CreatedAt: time.UnixMilli(status.CreatedAt).UTC().Format(time.RFC3339Nano),
+			IndexedAt: time.UnixMilli(status.IndexedAt).UTC().Format(time.RFC3339Nano),
+			Rev:       status.Rev,
+			Diverged:  status.DivergedCID != "",
+			Flag:      status.Flag,
+			Labels:    status.Labels,
+		}
+		if status.CID != "" {
+			item.Ref = &StrongRef{URI: status.URI, CID: status.CID}
+		}
+		resp.Statuses = append(resp.Statuses, item)
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error("failed to marshal statuses", "error", err)
+		http.Error(w, "marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,65 @@
+package statusphere_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+func TestAPIStatusesHaveStrongRefs(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	app.PostForm(t, "/status", url.Values{"status": {statusphere.DefaultPalette[0].Status}})
+
+	statuses := app.APIStatuses(t)
+	if len(statuses) != 1 || statuses[0].Ref == nil {
+		t.Fatalf("expected one status with a strong ref, got %+v", statuses)
+	}
+
+	ref := statuses[0].Ref
+	uri, err := syntax.ParseATURI(ref.URI)
+	if err != nil {
+		t.Fatalf("ref has invalid URI: %s", err)
+	}
+	_, recordCID, err := app.PDS.GetRecord(context.Background(), testapp.DID, uri.Collection().String(), uri.RecordKey().String())
+	if err != nil {
+		t.Fatalf("get record from PDS: %s", err)
+	}
+	if ref.CID != recordCID.String() {
+		t.Fatalf("expected ref CID %s, got %s", recordCID, ref.CID)
+	}
+}
+
+// TestAPIStatusesFutureCreatedAt stores a status that was indexed an hour ago but says it
+// was created in 2099, and checks that it's flagged and ordered by when it was indexed.
+func TestAPIStatusesFutureCreatedAt(t *testing.T) {
+	app := testapp.New(t)
+
+	app.CreateStatus(t, testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status))
+	indexedAt := time.Now().Add(-time.Hour)
+	status := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[7].Status)
+	status.CreatedAt = time.Date(2099, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	status.IndexedAt = indexedAt.UnixMilli()
+	app.CreateStatus(t, status)
+
+	statuses := app.APIStatuses(t)
+	if len(statuses) != 2 || statuses[1].URI != status.URI {
+		t.Fatalf("expected the status to be ordered by when it was indexed, got %+v", statuses)
+	}
+	if statuses[1].Flag != statusphere.FlagFutureCreatedAt {
+		t.Fatalf("expected the status to be flagged, got %q", statuses[1].Flag)
+	}
+
+	flagged, err := app.DB.GetFlaggedStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get flagged statuses: %s", err)
+	}
+	if len(flagged) != 1 || flagged[0].URI != status.URI {
+		t.Fatalf("expected the status to be listed for review, got %+v", flagged)
+	}
+}
@@ -2,6 +2,8 @@ package statusphere
 
 import (
 	_ "embed"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 
@@ -15,7 +17,16 @@ type LoginData struct {
 
 func (s *Server) authMiddleware(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		did, _ := s.currentSessionDID(r)
+		did, err := s.loggedInDID(r)
+		if errors.Is(err, ErrorNotFound) {
+			s.clearSessionCookie(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("get oauth session", "error", err)
+			http.Error(w, "failed to get session", http.StatusInternalServerError)
+			return
+		}
 		if did == nil {
 			http.Redirect(w, r, "/login", http.StatusFound)
 			return
@@ -25,6 +36,21 @@ func (s *Server) authMiddleware(next func(http.ResponseWriter, *http.Request)) f
 	}
 }
 
+// loggedInDID returns the DID the browser is logged in as, or nil if it isn't logged in. As
+// the session may have been revoked from another browser or by an admin, which logs this
+// browser out too, it returns ErrorNotFound if the browser's OAuth session no longer exists.
+func (s *Server) loggedInDID(r *http.Request) (*syntax.DID, error) {
+	did, sessionID := s.currentSessionDID(r)
+	if did == nil {
+		return nil, nil
+	}
+	_, err := s.oauthClient.Store.GetSession(r.Context(), *did, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return did, nil
+}
+
 func (s *Server) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	tmpl := s.getTemplate("login.html")
 	data := LoginData{}
@@ -44,6 +70,16 @@ func (s *Server) HandlePostLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	handle := r.FormValue("handle")
+	data.Handle = handle
+
+	allowed, retryAfter := checkRateLimits(r.Context(), rateLimitCheck{limiter: s.limiters.loginByIP, key: s.clientIP(r)})
+	if !allowed {
+		slog.Warn("login rate limited", "ip", s.clientIP(r), "retry after", retryAfter)
+		data.Error = "Too many attempts to log in, " + setRetryAfter(w, retryAfter) + "."
+		w.WriteHeader(http.StatusTooManyRequests)
+		tmpl.Execute(w, data)
+		return
+	}
 
 	redirectURL, err := s.oauthClient.StartAuthFlow(r.Context(), handle)
 	if err != nil {
@@ -82,25 +118,77 @@ func (s *Server) handleOauthCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// HandleLogOut logs the user out of this browser, revoking the session's tokens.
 func (s *Server) HandleLogOut(w http.ResponseWriter, r *http.Request) {
 	did, sessionID := s.currentSessionDID(r)
 	if did != nil {
-		err := s.oauthClient.Store.DeleteSession(r.Context(), *did, sessionID)
+		err := RevokeSession(r.Context(), s.oauthClient, *did, sessionID)
 		if err != nil {
-			slog.Error("deleting oauth session", "error", err)
+			slog.Error("revoking oauth session", "error", err)
 		}
 	}
 
-	sess, _ := s.sessionStore.Get(r, "oauth-demo")
-	sess.Values = make(map[any]any)
-	err := sess.Save(r, w)
+	s.clearSessionCookie(w, r)
+}
+
+// HandleLogOutEverywhere logs the user out of every browser they're logged in from, such as
+// when they've lost a device, revoking the tokens of all of their sessions. If a session
+// can't be revoked the rest still are, and the user is told so they can log in and try again.
+func (s *Server) HandleLogOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	did, _ := s.currentSessionDID(r)
+	sessions, err := s.store.GetAccountSessions(r.Context(), *did)
 	if err != nil {
+		slog.Error("get account sessions", "error", err)
+		http.Error(w, "failed to log out everywhere", http.StatusInternalServerError)
+		return
+	}
+	revoked, failed := 0, 0
+	for _, session := range sessions {
+		err := RevokeSession(r.Context(), s.oauthClient, *did, session.SessionID)
+		if err != nil {
+			// carry on so that one failure doesn't leave the user's other sessions logged in
+			slog.Error("revoking oauth session", "error", err, "session id", session.SessionID)
+			failed++
+			continue
+		}
+		revoked++
+	}
+	slog.Info("logged out everywhere", "did", did, "revoked", revoked, "failed", failed)
+
+	if failed == 0 {
+		s.clearSessionCookie(w, r)
+		return
+	}
+
+	if err := s.forgetSession(w, r); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl := s.getTemplate("login.html")
+	data := LoginData{
+		Error: fmt.Sprintf("Couldn't log out of %d of your %d sessions, log in and try again.", failed, len(sessions)),
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	tmpl.Execute(w, data)
+}
+
+// clearSessionCookie forgets who the browser is logged in as and sends it home, which sends
+// it on to log in again.
+func (s *Server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	if err := s.forgetSession(w, r); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// forgetSession forgets who the browser is logged in as.
+func (s *Server) forgetSession(w http.ResponseWriter, r *http.Request) error {
+	sess, _ := s.sessionStore.Get(r, "oauth-demo")
+	sess.Values = make(map[any]any)
+	return sess.Save(r, w)
+}
+
 func (s *Server) currentSessionDID(r *http.Request) (*syntax.DID, string) {
 	sess, _ := s.sessionStore.Get(r, "oauth-demo")
 	accountDID, ok := sess.Values["account_did"].(string)
@@ -0,0 +1,150 @@
+package statusphere_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+func TestHomeRedirectsToLoginWhenLoggedOut(t *testing.T) {
+	app := testapp.New(t)
+
+	resp, _ := app.Get(t, "/")
+	testapp.ExpectPath(t, resp, "/login")
+}
+
+func TestLogIn(t *testing.T) {
+	app := testapp.New(t)
+
+	app.LogIn(t, app.Browser)
+
+	sessions, err := app.DB.GetAccountSessions(context.Background(), testapp.DID)
+	if err != nil {
+		t.Fatalf("get sessions: %s", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected a session to be saved, got %d", len(sessions))
+	}
+}
+
+func TestLogOut(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+
+	resp, _ := app.PostForm(t, "/logout", nil)
+	testapp.ExpectPath(t, resp, "/login")
+	if tokens := app.PDS.ActiveTokens(testapp.DID); tokens != 0 {
+		t.Fatalf("expected the session's tokens to be revoked with the PDS, %d weren't", tokens)
+	}
+
+	resp, _ = app.Get(t, "/")
+	testapp.ExpectPath(t, resp, "/login")
+}
+
+// TestLogOutEverywhere logs in from a second browser and logs out everywhere from it, which
+// logs the first browser out too.
+func TestLogOutEverywhere(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	first := app.Browser
+	app.Browser = app.NewBrowser(t)
+	app.LogIn(t, app.Browser)
+
+	resp, _ := app.PostForm(t, "/logout/everywhere", nil)
+	testapp.ExpectPath(t, resp, "/login")
+
+	sessions, err := app.DB.GetAccountSessions(context.Background(), testapp.DID)
+	if err != nil {
+		t.Fatalf("get sessions: %s", err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("expected every session to be deleted, got %d", len(sessions))
+	}
+	if tokens := app.PDS.ActiveTokens(testapp.DID); tokens != 0 {
+		t.Fatalf("expected every token to be revoked with the PDS, %d weren't", tokens)
+	}
+
+	app.Browser = first
+	resp, _ = app.Get(t, "/")
+	testapp.ExpectPath(t, resp, "/login")
+}
+
+// TestLogOutEverywhereFails logs out everywhere when one session's tokens can't be revoked,
+// which still revokes the other session and tells the user.
+func TestLogOutEverywhereFails(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+	sessions, err := app.DB.GetAccountSessions(ctx, testapp.DID)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("get sessions: %v", err)
+	}
+	unreachable, err := app.DB.GetSession(ctx, testapp.DID, sessions[0].SessionID)
+	if err != nil {
+		t.Fatalf("get session: %s", err)
+	}
+	// the first browser's session can't reach its authorization server to be revoked
+	unreachable.SessionID = sessions[0].SessionID
+	unreachable.AuthServerURL = "http://127.0.0.1:1"
+	if err := app.DB.DeleteSession(ctx, testapp.DID, unreachable.SessionID); err != nil {
+		t.Fatalf("delete session: %s", err)
+	}
+	if err := app.DB.SaveSession(ctx, *unreachable); err != nil {
+		t.Fatalf("save session: %s", err)
+	}
+	app.Browser = app.NewBrowser(t)
+	app.LogIn(t, app.Browser)
+
+	resp, body := app.PostForm(t, "/logout/everywhere", nil)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+	if !strings.Contains(body, "Couldn&#39;t log out of 1 of your 2 sessions") {
+		t.Fatal("login page doesn't say a session couldn't be logged out")
+	}
+	// only the unreachable session's tokens are left
+	if tokens := app.PDS.ActiveTokens(testapp.DID); tokens != 2 {
+		t.Fatalf("expected the other session's tokens to be revoked, %d tokens are active", tokens)
+	}
+
+	resp, _ = app.Get(t, "/")
+	testapp.ExpectPath(t, resp, "/login")
+}
+
+func TestPostStatusLoggedOut(t *testing.T) {
+	app := testapp.New(t)
+
+	resp, _ := app.PostForm(t, "/status", url.Values{"status": {statusphere.DefaultPalette[0].Status}})
+	testapp.ExpectPath(t, resp, "/login")
+
+	statuses, err := app.DB.GetStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 0 {
+		t.Fatalf("expected no status to be stored, got %d", len(statuses))
+	}
+}
+
+// TestLogInRateLimited tries to log in as an account that doesn't exist until the login page
+// says to try again later.
+func TestLogInRateLimited(t *testing.T) {
+	app := testapp.New(t)
+
+	for range 20 {
+		resp, body := app.PostForm(t, "/login", url.Values{"handle": {"nobody.test"}})
+		if resp.StatusCode != http.StatusTooManyRequests {
+			continue
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Fatal("rate limited response has no Retry-After header")
+		}
+		if !strings.Contains(body, "Too many attempts to log in") {
+			t.Fatal("login page doesn't say the login was rate limited")
+		}
+		return
+	}
+	t.Fatal("expected logins to be rate limited")
+}
@@ -0,0 +1,113 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/client"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const backfillPageSize = 100
+
+type listRecordsResp struct {
+	Cursor  *string `json:"cursor"`
+	Records []struct {
+		URI   string          `json:"uri"`
+		CID   string          `json:"cid"`
+		Value json.RawMessage `json:"value"`
+	} `json:"records"`
+}
+
+// Backfiller fetches status records directly from the PDSes of accounts, to pick up statuses
+// that were created before the consumer was running or that it missed.
+type Backfiller struct {
+	httpClient *http.Client
+	directory  identity.Directory
+	store      HandlerStore
+	logger     *slog.Logger
+}
+
+// NewBackfiller creates a backfiller that resolves accounts using the directory and stores
+// the statuses it finds in the store.
+func NewBackfiller(httpClient *http.Client, directory identity.Directory, store HandlerStore, logger *slog.Logger) *Backfiller {
+	return &Backfiller{
+		httpClient: httpClient,
+		directory:  directory,
+		store:      store,
+		logger:     logger,
+	}
+}
+
+// Backfill stores every status record in the account's repo and returns how many there
+// were. Records that aren't valid statuses are skipped, as are blocked accounts.
+func (b *Backfiller) Backfill(ctx context.Context, did syntax.DID) (int, error) {
+	blocked, err := b.store.IsDIDBlocked(ctx, did.String())
+	if err != nil {
+		return 0, fmt.Errorf("check blocked DID: %w", err)
+	}
+	if blocked {
+		b.logger.Info("skipping blocked account", "did", did)
+		return 0, nil
+	}
+
+	ident, err := b.directory.LookupDID(ctx, did)
+	if err != nil {
+		return 0, fmt.Errorf("resolve DID: %w", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return 0, fmt.Errorf("DID document has no PDS")
+	}
+
+	c := client.NewAPIClient(pds)
+	c.Client = b.httpClient
+
+	count := 0
+	params := map[string]any{
+		"repo":       did.String(),
+		"collection": statusCollection,
+		"limit":      backfillPageSize,
+	}
+	for {
+		var resp listRecordsResp
+		err := c.Get(ctx, "com.atproto.repo.listRecords", params, &resp)
+		if err != nil {
+			return count, fmt.Errorf("list records: %w", err)
+		}
+
+		statuses := make([]Status, 0, len(resp.Records))
+		for _, record := range resp.Records {
+			var statusRecord StatusRecord
+			if err := json.Unmarshal(record.Value, &statusRecord); err != nil {
+				b.logger.Warn("skipping invalid status record", "uri", record.URI, "error", err)
+				continue
+			}
+			statuses = append(statuses, Status{
+				URI:       record.URI,
+				Did:       did.String(),
+				Status:    statusRecord.Status,
+				CreatedAt: statusRecord.CreatedAt.UnixMilli(),
+				IndexedAt: time.Now().UnixMilli(),
+				CID:       record.CID,
+			})
+		}
+
+		if len(statuses) > 0 {
+			if err := b.store.CreateStatuses(ctx, statuses); err != nil {
+				return count, fmt.Errorf("store statuses: %w", err)
+			}
+			count += len(statuses)
+		}
+
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Records) == 0 {
+			return count, nil
+		}
+		params["cursor"] = *resp.Cursor
+	}
+}
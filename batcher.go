@@ -0,0 +1,153 @@
+package statusphere
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+const batchFlushTimeout = time.Second * 10
+
+type pendingStatus struct {
+	status Status
+	// deleted is set when the status with the URI is being deleted rather than stored
+	deleted bool
+	// the event the status came from, so it can be dead lettered if it can't be stored
+	event *models.Event
+}
+
+// statusBatcher buffers statuses created by the consumer and writes them to the
+// underlying store in batches so that each flush is a single transaction instead
+// of one per event. Statuses are written in the order they are added, and so are
+// deletes, which split the batch they're in.
+type statusBatcher struct {
+	store     HandlerStore
+	onFailure func(ctx context.Context, event *models.Event, err error)
+	// onWritten is called for each status once its batch has been written, whether or not
+	// the status could be stored
+	onWritten func(event *models.Event)
+
+	size     int
+	interval time.Duration
+	logger   *slog.Logger
+
+	statuses chan pendingStatus
+	done     chan struct{}
+}
+
+func newStatusBatcher(store HandlerStore, size int, interval time.Duration, logger *slog.Logger, onFailure func(ctx context.Context, event *models.Event, err error), onWritten func(event *models.Event)) *statusBatcher {
+	return &statusBatcher{
+		store:     store,
+		onFailure: onFailure,
+		onWritten: onWritten,
+		size:      size,
+		interval:  interval,
+		logger:    logger,
+		statuses:  make(chan pendingStatus, size),
+		done:      make(chan struct{}),
+	}
+}
+
+// Add adds the status to the current batch. It doesn't wait for the batch to be written,
+// so statuses that can't be written are passed to the batcher's failure func.
+func (b *statusBatcher) Add(status Status, event *models.Event) {
+	b.statuses <- pendingStatus{status: status, event: event}
+}
+
+// AddDelete adds deleting the status with the URI to the current batch, so that it's
+// written after any statuses added before it.
+func (b *statusBatcher) AddDelete(uri string, event *models.Event) {
+	b.statuses <- pendingStatus{status: Status{URI: uri}, deleted: true, event: event}
+}
+
+// Run flushes batches until Close is called. It should be run in its own goroutine.
+func (b *statusBatcher) Run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]pendingStatus, 0, b.size)
+	for {
+		select {
+		case pending, ok := <-b.statuses:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) >= b.size {
+				b.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// Close writes any buffered statuses and waits for Run to return. No statuses
+// can be added once Close has been called.
+func (b *statusBatcher) Close() {
+	close(b.statuses)
+	<-b.done
+}
+
+func (b *statusBatcher) flush(batch []pendingStatus) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// the batch is written with a fresh context so that statuses buffered before the
+	// consumer was stopped are still stored
+	ctx, cancel := context.WithTimeout(context.Background(), batchFlushTimeout)
+	defer cancel()
+
+	start := 0
+	for i, pending := range batch {
+		if !pending.deleted {
+			continue
+		}
+		b.storeStatuses(ctx, batch[start:i])
+		if err := b.store.DeleteStatus(ctx, pending.status.URI); err != nil {
+			b.onFailure(ctx, pending.event, fmt.Errorf("delete status: %w", err))
+		}
+		start = i + 1
+	}
+	b.storeStatuses(ctx, batch[start:])
+
+	for _, pending := range batch {
+		b.onWritten(pending.event)
+	}
+}
+
+// storeStatuses writes the statuses in a single transaction, falling back to writing them
+// one at a time if that fails.
+func (b *statusBatcher) storeStatuses(ctx context.Context, batch []pendingStatus) {
+	if len(batch) == 0 {
+		return
+	}
+
+	statuses := make([]Status, 0, len(batch))
+	for _, pending := range batch {
+		statuses = append(statuses, pending.status)
+	}
+
+	err := b.store.CreateStatuses(ctx, statuses)
+	if err == nil {
+		return
+	}
+	b.logger.Warn("failed to store batch of statuses, storing individually", "error", err, "count", len(batch))
+
+	// the whole transaction was rolled back, so work out which statuses can't be stored
+	for _, pending := range batch {
+		err := b.store.CreateStatus(ctx, pending.status)
+		if err != nil {
+			b.onFailure(ctx, pending.event, fmt.Errorf("store status: %w", err))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/ipfs/go-cid"
+)
+
+// CARImport is the result of importing a repo from a CAR file.
+type CARImport struct {
+	Did syntax.DID
+	// Rev is the revision of the repo's commit.
+	Rev string
+	// Imported is how many status records were stored.
+	Imported int
+	// Skipped is how many status records weren't valid statuses.
+	Skipped int
+}
+
+// CARImportStore stores the statuses imported from a CAR file.
+type CARImportStore interface {
+	CreateStatuses(ctx context.Context, statuses []Status) error
+}
+
+// ImportCAR stores every status record in a repo exported as a CAR file, such as by
+// com.atproto.sync.getRepo. The repo's tree is walked rather than trusting any index, and
+// each record is checked against the CID the tree has for it. The commit's signature isn't
+// verified, so the CAR file should come from a source that's trusted, such as the account's
+// PDS or the account holder.
+func ImportCAR(ctx context.Context, store CARImportStore, r io.Reader, logger *slog.Logger) (CARImport, error) {
+	return importCAR(ctx, store, r, "", logger)
+}
+
+// importCAR imports a CAR file, checking that the repo is for the expected DID before
+// storing anything if one is given.
+func importCAR(ctx context.Context, store CARImportStore, r io.Reader, expectedDid syntax.DID, logger *slog.Logger) (CARImport, error) {
+	commit, carRepo, err := repo.LoadRepoFromCAR(ctx, r)
+	if err != nil {
+		return CARImport{}, fmt.Errorf("load repo: %w", err)
+	}
+	result := CARImport{
+		Did: syntax.DID(commit.DID),
+		Rev: commit.Rev,
+	}
+	if expectedDid != "" && result.Did != expectedDid {
+		return result, fmt.Errorf("repo is for %s rather than %s", result.Did, expectedDid)
+	}
+
+	indexedAt := time.Now().UnixMilli()
+	prefix := statusCollection + "/"
+	batch := make([]Status, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := store.CreateStatuses(ctx, batch); err != nil {
+			return fmt.Errorf("store statuses: %w", err)
+		}
+		result.Imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err = carRepo.MST.Walk(func(key []byte, recordCID cid.Cid) error {
+		rkey, ok := strings.CutPrefix(string(key), prefix)
+		if !ok {
+			return nil
+		}
+		uri := fmt.Sprintf("at://%s/%s", result.Did, key)
+		if _, err := syntax.ParseRecordKey(rkey); err != nil {
+			logger.Warn("skipping status record with invalid rkey", "uri", uri, "error", err)
+			result.Skipped++
+			return nil
+		}
+
+		status, err := statusFromCARRecord(ctx, carRepo, recordCID)
+		if err != nil {
+			logger.Warn("skipping invalid status record", "uri", uri, "error", err)
+			result.Skipped++
+			return nil
+		}
+
+		status.URI = uri
+		status.Did = result.Did.String()
+		status.IndexedAt = indexedAt
+		// the record's own revision isn't in the repo, but it can't be newer than the commit
+		status.Rev = result.Rev
+		batch = append(batch, status)
+		if len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("walk repo: %w", err)
+	}
+
+	return result, flush()
+}
+
+func statusFromCARRecord(ctx context.Context, carRepo *repo.Repo, recordCID cid.Cid) (Status, error) {
+	blk, err := carRepo.RecordStore.Get(ctx, recordCID)
+	if err != nil {
+		return Status{}, fmt.Errorf("get record block: %w", err)
+	}
+	// the tree only proves the CID so make sure the block really has that CID
+	computedCID, err := recordCID.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return Status{}, fmt.Errorf("compute record CID: %w", err)
+	}
+	if !computedCID.Equals(recordCID) {
+		return Status{}, fmt.Errorf("record block doesn't match its CID")
+	}
+
+	record, err := data.UnmarshalCBOR(blk.RawData())
+	if err != nil {
+		return Status{}, fmt.Errorf("decode record: %w", err)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return Status{}, fmt.Errorf("encode record as JSON: %w", err)
+	}
+	var statusRecord StatusRecord
+	if err := json.Unmarshal(b, &statusRecord); err != nil {
+		return Status{}, fmt.Errorf("unmarshal record: %w", err)
+	}
+
+	return Status{
+		Status:    statusRecord.Status,
+		CreatedAt: statusRecord.CreatedAt.UnixMilli(),
+		CID:       recordCID.String(),
+	}, nil
+}
+
+// BackfillCAR downloads the account's whole repo from their PDS as a CAR file and stores
+// every status in it. Unlike Backfill this is a single request however many statuses there
+// are, but the repo includes all of the account's other records too.
+func (b *Backfiller) BackfillCAR(ctx context.Context, did syntax.DID) (CARImport, error) {
+	ident, err := b.directory.LookupDID(ctx, did)
+	if err != nil {
+		return CARImport{}, fmt.Errorf("resolve DID: %w", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return CARImport{}, fmt.Errorf("DID document has no PDS")
+	}
+
+	params := url.Values{
+		"did": []string{did.String()},
+	}
+	reqUrl := pds + "/xrpc/com.atproto.sync.getRepo?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return CARImport{}, fmt.Errorf("create http request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return CARImport{}, fmt.Errorf("make http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return CARImport{}, fmt.Errorf("get repo: unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	return importCAR(ctx, b.store, resp.Body, did, b.logger)
+}
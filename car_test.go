@@ -0,0 +1,64 @@
+package statusphere_test
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// TestBackfillCAR imports the test account's repo from the PDS, which has a status posted
+// from the app and one posted from another app that the app hasn't seen.
+func TestBackfillCAR(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+
+	app.PostForm(t, "/status", url.Values{"status": {statusphere.DefaultPalette[0].Status}})
+	_, err := app.PDS.CreateRecord(ctx, testapp.DID, "xyz.statusphere.status", syntax.NewTIDNow(0).String(), map[string]any{
+		"$type":     "xyz.statusphere.status",
+		"status":    statusphere.DefaultPalette[1].Status,
+		"createdAt": syntax.DatetimeNow().String(),
+	})
+	if err != nil {
+		t.Fatalf("create record on PDS: %s", err)
+	}
+
+	backfiller := statusphere.NewBackfiller(app.PDS.Client(), app.PDS.Directory(), app.DB, slog.Default())
+	result, err := backfiller.BackfillCAR(ctx, testapp.DID)
+	if err != nil {
+		t.Fatalf("backfill CAR: %s", err)
+	}
+	if result.Did != testapp.DID || result.Imported != 2 || result.Skipped != 0 {
+		t.Fatalf("expected 2 statuses to be imported for %s, got %+v", testapp.DID, result)
+	}
+
+	var statuses []statusphere.Status
+	err = app.DB.EachStatus(ctx, statusphere.ExportFilter{}, func(status statusphere.Status) error {
+		statuses = append(statuses, status)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(statuses))
+	}
+	for _, status := range statuses {
+		uri, err := syntax.ParseATURI(status.URI)
+		if err != nil {
+			t.Fatalf("stored status has invalid URI: %s", err)
+		}
+		_, recordCID, err := app.PDS.GetRecord(ctx, testapp.DID, uri.Collection().String(), uri.RecordKey().String())
+		if err != nil {
+			t.Fatalf("get %s from PDS: %s", uri, err)
+		}
+		if status.CID != recordCID.String() {
+			t.Errorf("expected %s to have CID %s, got %q", uri, recordCID, status.CID)
+		}
+	}
+}
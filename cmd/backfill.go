@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/willdot/statusphere-go"
+)
+
+// runBackfill fetches the statuses of each DID given from their PDS. If none are given then
+// every DID that has posted a status is backfilled, which fills in any the consumer missed.
+func runBackfill(args []string) int {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	car := flags.Bool("car", false, "download each account's whole repo as a CAR file rather than listing their statuses")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var dids []syntax.DID
+	for _, arg := range flags.Args() {
+		did, err := syntax.ParseDID(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid DID %q: %s\n", arg, err)
+			return 2
+		}
+		dids = append(dids, did)
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if len(dids) == 0 {
+		stored, err := db.GetStatusDids(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get DIDs: %s\n", err)
+			return 1
+		}
+		for _, did := range stored {
+			// DIDs are only stored after being parsed from an event so this shouldn't fail
+			if parsed, err := syntax.ParseDID(did); err == nil {
+				dids = append(dids, parsed)
+			}
+		}
+	}
+
+	httpClient := &http.Client{
+		Timeout: httpClientTimeoutDuration,
+	}
+	backfiller := statusphere.NewBackfiller(httpClient, identity.DefaultDirectory(), db, slog.Default())
+
+	failed := 0
+	for _, did := range dids {
+		count, err := backfill(ctx, backfiller, did, *car)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", did, err)
+			failed++
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		fmt.Printf("%s: %d statuses\n", did, count)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "failed to backfill %d of %d accounts\n", failed, len(dids))
+		return 1
+	}
+	return 0
+}
+
+func backfill(ctx context.Context, backfiller *statusphere.Backfiller, did syntax.DID, car bool) (int, error) {
+	if !car {
+		return backfiller.Backfill(ctx, did)
+	}
+	result, err := backfiller.BackfillCAR(ctx, did)
+	if result.Skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%s: skipped %d invalid statuses\n", did, result.Skipped)
+	}
+	return result.Imported, err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// runImportCAR stores the statuses in a repo exported as a CAR file, such as one downloaded
+// from com.atproto.sync.getRepo.
+func runImportCAR(args []string) int {
+	flags := flag.NewFlagSet("import-car", flag.ContinueOnError)
+	file := flags.String("file", "", "CAR file to read from, or - for stdin")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open file: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	result, err := statusphere.ImportCAR(context.Background(), db, bufio.NewReader(in), slog.Default())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import CAR: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("imported %d statuses from the repo of %s at rev %s", result.Imported, result.Did, result.Rev)
+	if result.Skipped > 0 {
+		fmt.Printf(", skipping %d invalid statuses", result.Skipped)
+	}
+	fmt.Println()
+	return 0
+}
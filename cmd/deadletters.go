@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+const deadLettersUsage = `usage: statuspherego deadletters <command>
+
+commands:
+  list [-limit n]   list the most recent dead letters
+  inspect <id>      show a dead letter including its raw event
+  replay <id>       handle a dead letter's event again, deleting it if it succeeds
+  purge             delete all dead letters`
+
+// runDeadLetters runs a dead letter command against the database and returns the exit code.
+func runDeadLetters(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, deadLettersUsage)
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		err = listDeadLetters(ctx, db, args[1:])
+	case "inspect":
+		err = withDeadLetterID(ctx, db, args[1:], inspectDeadLetter)
+	case "replay":
+		err = withDeadLetterID(ctx, db, args[1:], func(ctx context.Context, store statusphere.DeadLetterStore, deadLetter statusphere.DeadLetter) error {
+			if err := statusphere.ReplayDeadLetter(ctx, store, deadLetter); err != nil {
+				return fmt.Errorf("replay failed: %w", err)
+			}
+			fmt.Printf("replayed dead letter %d\n", deadLetter.ID)
+			return nil
+		})
+	case "purge":
+		var deleted int64
+		deleted, err = db.PurgeDeadLetters(ctx)
+		if err == nil {
+			fmt.Printf("purged %d dead letters\n", deleted)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, deadLettersUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listDeadLetters(ctx context.Context, store statusphere.DeadLetterStore, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	limit := flags.Int("limit", 50, "maximum number of dead letters to list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	deadLetters, err := store.GetDeadLetters(ctx, *limit)
+	if err != nil {
+		return fmt.Errorf("get dead letters: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDID\tRKEY\tATTEMPTS\tRETRYABLE\tLAST ATTEMPT\tERROR")
+	for _, deadLetter := range deadLetters {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%t\t%s\t%s\n", deadLetter.ID, deadLetter.Did, deadLetter.RKey, deadLetter.Attempts, deadLetter.Retryable, formatMilli(deadLetter.LastAttemptAt), deadLetter.Error)
+	}
+	return w.Flush()
+}
+
+func inspectDeadLetter(_ context.Context, _ statusphere.DeadLetterStore, deadLetter statusphere.DeadLetter) error {
+	fmt.Printf("ID:           %d\n", deadLetter.ID)
+	fmt.Printf("DID:          %s\n", deadLetter.Did)
+	fmt.Printf("RKey:         %s\n", deadLetter.RKey)
+	fmt.Printf("Attempts:     %d\n", deadLetter.Attempts)
+	fmt.Printf("Retryable:    %t\n", deadLetter.Retryable)
+	fmt.Printf("Created:      %s\n", formatMilli(deadLetter.CreatedAt))
+	fmt.Printf("Last attempt: %s\n", formatMilli(deadLetter.LastAttemptAt))
+	if deadLetter.Retryable {
+		fmt.Printf("Next attempt: %s\n", formatMilli(deadLetter.NextAttemptAt))
+	}
+	fmt.Printf("Error:        %s\n", deadLetter.Error)
+	fmt.Printf("Event:        %s\n", deadLetter.Event)
+	return nil
+}
+
+func withDeadLetterID(ctx context.Context, store statusphere.DeadLetterStore, args []string, fn func(context.Context, statusphere.DeadLetterStore, statusphere.DeadLetter) error) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a dead letter ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid dead letter ID: %w", err)
+	}
+
+	deadLetter, err := store.GetDeadLetter(ctx, id)
+	if err != nil {
+		if errors.Is(err, statusphere.ErrorNotFound) {
+			return fmt.Errorf("dead letter %d not found", id)
+		}
+		return fmt.Errorf("get dead letter: %w", err)
+	}
+
+	return fn(ctx, store, deadLetter)
+}
+
+func formatMilli(ms int64) string {
+	return time.UnixMilli(ms).Format(time.DateTime)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// runExport writes the rows of a table to a file, or stdout, as JSONL or CSV.
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := flags.String("file", "", "file to write to, instead of stdout")
+	table := flags.String("table", statusphere.ExportTableStatus, "table to export, either status or profile")
+	format := flags.String("format", "", "jsonl or csv, defaulting to the file's extension or jsonl")
+	dids := flags.String("did", "", "comma separated DIDs to export rows for")
+	since := flags.String("since", "", "only export statuses created at or after this RFC 3339 time")
+	until := flags.String("until", "", "only export statuses created before this RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	filter, err := statusphere.ParseExportFilter(strings.Split(*dids, ","), *since, *until)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	*format = formatFromFile(*format, *file)
+	if err := statusphere.ValidateExport(*table, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create file: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	count, err := statusphere.Export(context.Background(), db, w, *table, *format, filter)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export %s: %s\n", *table, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d rows from %s\n", count, *table)
+	return 0
+}
+
+// runImport stores the rows of a table from a file written by export, in the same way as
+// when they're consumed, so importing the same file twice is safe.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "file to read from, or - for stdin")
+	table := flags.String("table", statusphere.ExportTableStatus, "table to import, either status or profile")
+	format := flags.String("format", "", "jsonl or csv, defaulting to the file's extension or jsonl")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		return 2
+	}
+	*format = formatFromFile(*format, *file)
+	if err := statusphere.ValidateExport(*table, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open file: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	count, err := statusphere.Import(context.Background(), db, bufio.NewReader(in), *table, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import %s: %s\n", *table, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "imported %d rows into %s\n", count, *table)
+	return 0
+}
+
+// formatFromFile returns the format if one was given, otherwise csv for .csv files and
+// jsonl for anything else.
+func formatFromFile(format, file string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return statusphere.ExportFormatCSV
+	}
+	return statusphere.ExportFormatJSONL
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// runFlagged lists the statuses that were flagged as having a suspicious createdAt, so they
+// can be reviewed.
+func runFlagged(args []string) int {
+	flags := flag.NewFlagSet("flagged", flag.ContinueOnError)
+	limit := flags.Int("limit", 50, "maximum number of statuses to list")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	statuses, err := db.GetFlaggedStatuses(context.Background(), *limit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get flagged statuses: %s\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "URI\tSTATUS\tFLAG\tCREATED\tINDEXED")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.URI, status.Status, status.Flag, formatMilli(status.CreatedAt), formatMilli(status.IndexedAt))
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runGC deletes data that is no longer needed: logins that were started but never finished,
+// old dead letters, cached profiles of accounts without any statuses, rate limits that
+// haven't been hit recently, labels that have expired and the cached follows of users who
+// no longer look at the following feed.
+func runGC(args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	authRequestAge := flags.Duration("auth-request-age", time.Hour, "delete unfinished logins older than this")
+	deadLetterAge := flags.Duration("dead-letter-age", time.Hour*24*30, "delete dead letters older than this")
+	rateLimitAge := flags.Duration("rate-limit-age", time.Hour, "delete rate limits that haven't been hit for this long, which must be longer than they take to reset")
+	followsAge := flags.Duration("follows-age", time.Hour*24*30, "delete the cached follows of users who haven't looked at the following feed for this long")
+	vacuum := flags.Bool("vacuum", false, "rebuild the database file afterwards to reclaim space")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	deleted, err := db.DeleteAuthRequestsBefore(ctx, now.Add(-*authRequestAge).UnixMilli())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete auth requests: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d auth requests\n", deleted)
+
+	deleted, err = db.DeleteDeadLettersBefore(ctx, now.Add(-*deadLetterAge).UnixMilli())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete dead letters: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d dead letters\n", deleted)
+
+	deleted, err = db.DeleteUnusedProfiles(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete profiles: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d profiles\n", deleted)
+
+	deleted, err = db.DeleteRateLimitsBefore(ctx, now.Add(-*rateLimitAge).UnixMilli())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete rate limits: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d rate limits\n", deleted)
+
+	deleted, err = db.DeleteExpiredLabels(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete labels: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted %d expired labels\n", deleted)
+
+	deleted, err = db.DeleteFollowGraphsBefore(ctx, now.Add(-*followsAge).UnixMilli())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "delete follows: %s\n", err)
+		return 1
+	}
+	fmt.Printf("deleted the follows of %d users\n", deleted)
+
+	if *vacuum {
+		if err := db.Vacuum(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "vacuum: %s\n", err)
+			return 1
+		}
+		fmt.Println("vacuumed database")
+	}
+	return 0
+}
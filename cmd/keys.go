@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+const keysUsage = `usage: statuspherego keys <command>
+
+commands:
+  generate   print a new P-256 key for OAUTH_CLIENT_SECRET_KEY and an ID for OAUTH_CLIENT_KEY_ID
+  labeler    print a new K-256 key for LABELER_SIGNING_KEY and its public key for the labeler's DID document`
+
+// runKeys manages the keys used by the OAuth client and the labeler and returns the exit
+// code.
+func runKeys(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+	switch args[0] {
+	case "generate":
+		return generateClientKey()
+	case "labeler":
+		return generateLabelerKey()
+	default:
+		fmt.Fprintln(os.Stderr, keysUsage)
+		return 2
+	}
+}
+
+func generateClientKey() int {
+
+	key, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate key: %s\n", err)
+		return 1
+	}
+
+	// the key ID only needs to be different from previous keys so that clients don't use a
+	// cached copy of an old one after it's rotated
+	fmt.Printf("OAUTH_CLIENT_SECRET_KEY=%s\n", key.Multibase())
+	fmt.Printf("OAUTH_CLIENT_KEY_ID=%s\n", strconv.FormatInt(time.Now().Unix(), 10))
+	return 0
+}
+
+func generateLabelerKey() int {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate key: %s\n", err)
+		return 1
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get public key: %s\n", err)
+		return 1
+	}
+
+	// the public key is printed as a comment so that the output can still be appended to
+	// an env file
+	fmt.Printf("LABELER_SIGNING_KEY=%s\n", key.Multibase())
+	fmt.Printf("# atproto_label verification method for the labeler's DID document: %s\n", pub.Multibase())
+	return 0
+}
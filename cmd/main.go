@@ -6,26 +6,65 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
-	"net/http"
 	"os"
-	"os/signal"
 	"path"
-	"syscall"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/avast/retry-go/v4"
-	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/joho/godotenv"
 	"github.com/willdot/statusphere-go"
 	"github.com/willdot/statusphere-go/database"
 )
 
+// defaultServerAddrs are the public Jetstream instances that are consumed from if none
+// are configured. If one fails, the next is tried.
+var defaultServerAddrs = []string{
+	"wss://jetstream.atproto.tools/subscribe",
+	"wss://jetstream1.us-east.bsky.network/subscribe",
+	"wss://jetstream2.us-east.bsky.network/subscribe",
+	"wss://jetstream1.us-west.bsky.network/subscribe",
+	"wss://jetstream2.us-west.bsky.network/subscribe",
+}
+
+// defaultRelayAddrs are the relays whose firehose is consumed from in firehose mode if none
+// are configured.
+var defaultRelayAddrs = []string{
+	"wss://bsky.network",
+}
+
 const (
-	defaultServerAddr                = "wss://jetstream.atproto.tools/subscribe"
+	ingestModeJetstream = "jetstream"
+	ingestModeFirehose  = "firehose"
+
 	httpClientTimeoutDuration        = time.Second * 5
 	transportIdleConnTimeoutDuration = time.Second * 90
 )
 
+const usage = `usage: statuspherego [command]
+
+Without a command, the web server and the consumer are run together.
+
+commands:
+  serve                   run the web server
+  consume                 run the consumer and the dead letter retrier
+  migrate                 create the database tables and apply any migrations
+  backfill [did ...]      fetch statuses from the PDS of each DID, or every DID that has a status
+  export [-file path]     write a table as JSON Lines or CSV
+  import -file path       store the rows of a table from an export
+  import-car -file path   store the statuses in a repo CAR file
+  gc                      delete stale data
+  keys <command>          generate keys for the OAuth client and the labeler
+  session <command>       list and revoke the OAuth sessions of logged in users
+  deadletters <command>   manage events that couldn't be handled
+  flagged                 list statuses flagged as having a suspicious createdAt
+  palette <command>       manage the statuses users can pick from
+  moderation <command>    block accounts and hide statuses
+  replay -file path       handle events recorded from Jetstream`
+
 func main() {
 	err := godotenv.Load(".env")
 	if err != nil {
@@ -34,81 +73,98 @@ func main() {
 		}
 	}
 
-	host := os.Getenv("HOST")
-	if host == "" {
-		slog.Error("missing HOST env variable")
-		return
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	os.Exit(run(command, args))
+}
+
+// run runs the command and returns the exit code.
+func run(command string, args []string) int {
+	switch command {
+	case "":
+		return runApp(true, true)
+	case "serve":
+		return runApp(true, false)
+	case "consume":
+		return runApp(false, true)
+	case "migrate":
+		return runMigrate(args)
+	case "backfill":
+		return runBackfill(args)
+	case "export":
+		return runExport(args)
+	case "import":
+		return runImport(args)
+	case "import-car":
+		return runImportCAR(args)
+	case "gc":
+		return runGC(args)
+	case "keys":
+		return runKeys(args)
+	case "session":
+		return runSession(args)
+	case "deadletters":
+		return runDeadLetters(args)
+	case "flagged":
+		return runFlagged(args)
+	case "palette":
+		return runPalette(args)
+	case "moderation":
+		return runModeration(args)
+	case "replay":
+		return runReplay(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Println(usage)
+		return 0
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
 	}
+}
 
+func openDatabase() (*database.DB, error) {
 	dbMountPath := os.Getenv("DATABASE_MOUNT_PATH")
 	if dbMountPath == "" {
-		slog.Error("DATABASE_MOUNT_PATH env not set")
-		return
+		return nil, fmt.Errorf("DATABASE_MOUNT_PATH env not set")
 	}
 
 	dbFilename := path.Join(dbMountPath, "database.db")
-	db, err := database.New(dbFilename)
-	if err != nil {
-		slog.Error("create new database", "error", err)
-		return
-	}
-	defer db.Close()
-
-	httpClient := &http.Client{
-		Timeout: httpClientTimeoutDuration,
-		Transport: &http.Transport{
-			IdleConnTimeout: transportIdleConnTimeoutDuration,
-		},
-	}
-
-	var config oauth.ClientConfig
-	bind := ":8080"
-	scopes := []string{"atproto", "transition:generic"}
-	if host == "" {
-		config = oauth.NewLocalhostConfig(
-			fmt.Sprintf("http://127.0.0.1%s/oauth-callback", bind),
-			scopes,
-		)
-		slog.Info("configuring localhost OAuth client", "CallbackURL", config.CallbackURL)
-	} else {
-		config = oauth.NewPublicConfig(
-			fmt.Sprintf("%s/oauth-client-metadata.json", host),
-			fmt.Sprintf("%s/oauth-callback", host),
-			scopes,
-		)
-	}
-	oauthClient := oauth.NewClientApp(&config, db)
-
-	server, err := statusphere.NewServer(host, 8080, db, oauthClient, httpClient)
-	if err != nil {
-		slog.Error("create new server", "error", err)
-		return
-	}
-
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	go func() {
-		<-signals
-		cancel()
-		_ = server.Stop(context.Background())
-	}()
-
-	go consumeLoop(ctx, db)
+	return database.New(dbFilename)
+}
 
-	server.Run()
+type eventConsumer interface {
+	Consume(ctx context.Context) error
+	RetryDelay() time.Duration
+	Status() statusphere.ConsumerStatus
 }
 
 func consumeLoop(ctx context.Context, db *database.DB) {
-	jsServerAddr := os.Getenv("JS_SERVER_ADDR")
-	if jsServerAddr == "" {
-		jsServerAddr = defaultServerAddr
+	consumer, err := newConsumerFromEnv(db)
+	if err != nil {
+		slog.Error("create consumer", "error", err)
+		return
 	}
+	retryConsume(ctx, db, consumer)
+}
 
-	consumer := statusphere.NewConsumer(jsServerAddr, slog.Default(), db)
+// retryConsume consumes until the context is cancelled, reconnecting whenever the
+// connection fails. The consumer's status is saved to the database as it goes, so that it
+// can be seen on the admin dashboard.
+func retryConsume(ctx context.Context, db *database.DB, consumer eventConsumer) {
+	reportCtx, stopReporting := context.WithCancel(ctx)
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		statusphere.ReportConsumerStatus(reportCtx, db, consumer.Status, slog.Default())
+	}()
+	defer func() {
+		stopReporting()
+		<-reported
+	}()
 
 	err := retry.Do(func() error {
 		err := consumer.Consume(ctx)
@@ -120,7 +176,176 @@ func consumeLoop(ctx context.Context, db *database.DB) {
 			return err
 		}
 		return nil
-	}, retry.UntilSucceeded()) // retry indefinitly until context canceled
-	slog.Error(err.Error())
+	},
+		retry.UntilSucceeded(), // retry indefinitly until context canceled
+		retry.Context(ctx),
+		retry.LastErrorOnly(true),
+		retry.DelayType(func(_ uint, _ error, _ *retry.Config) time.Duration {
+			return consumer.RetryDelay()
+		}),
+	)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("consume loop", "error", err)
+	}
 	slog.Warn("exiting consume loop")
 }
+
+// newConsumerFromEnv creates the consumer for the ingestion mode set by INGEST_MODE. Either
+// "jetstream" (the default) which consumes from Jetstream, or "firehose" which consumes the
+// raw firehose from the relays in RELAY_ADDRS and verifies each record itself.
+func newConsumerFromEnv(db *database.DB) (eventConsumer, error) {
+	mode := os.Getenv("INGEST_MODE")
+	switch mode {
+	case "", ingestModeJetstream:
+		opts, err := consumerOptionsFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("invalid consumer config: %w", err)
+		}
+		recorder, err := recorderOptionFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if recorder != nil {
+			opts = append(opts, recorder)
+		}
+		saved, err := savedConsumerStatus(db, "jetstream")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, statusphere.WithStartCursor(saved.Cursor))
+		return statusphere.NewConsumer(jetstreamAddrsFromEnv(), slog.Default(), db, opts...)
+	case ingestModeFirehose:
+		relayAddrs := listFromEnv("RELAY_ADDRS")
+		if len(relayAddrs) == 0 {
+			relayAddrs = defaultRelayAddrs
+		}
+		saved, err := savedConsumerStatus(db, "firehose")
+		if err != nil {
+			return nil, err
+		}
+		return statusphere.NewFirehoseConsumer(relayAddrs, slog.Default(), db, identity.DefaultDirectory(), statusphere.WithFirehoseStartCursor(saved.Endpoint, saved.Cursor))
+	default:
+		return nil, fmt.Errorf("unknown INGEST_MODE %q", mode)
+	}
+}
+
+// savedConsumerStatus returns the status saved by the named consumer, which may have been
+// running in another process before this one took over, including the endpoint it was
+// consuming from and the cursor it reached. It's empty if the consumer hasn't saved one.
+func savedConsumerStatus(db *database.DB, name string) (statusphere.ConsumerStatus, error) {
+	statuses, err := db.GetConsumerStatuses(context.Background())
+	if err != nil {
+		return statusphere.ConsumerStatus{}, fmt.Errorf("get saved consumer statuses: %w", err)
+	}
+	for _, status := range statuses {
+		if status.Name == name {
+			return status, nil
+		}
+	}
+	return statusphere.ConsumerStatus{}, nil
+}
+
+// labelConsumersFromEnv creates a consumer for each labeler whose DID is in the comma
+// separated list in LABELERS.
+func labelConsumersFromEnv(db *database.DB) ([]eventConsumer, error) {
+	var consumers []eventConsumer
+	for _, labeler := range listFromEnv("LABELERS") {
+		did, err := syntax.ParseDID(labeler)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labeler DID in LABELERS: %w", err)
+		}
+		consumers = append(consumers, statusphere.NewLabelConsumer(did, slog.Default(), db, identity.DefaultDirectory()))
+	}
+	return consumers, nil
+}
+
+// jetstreamAddrsFromEnv returns the Jetstream URLs to consume from. JS_SERVER_ADDRS can
+// be set to a comma separated list of URLs, otherwise JS_SERVER_ADDR can be set to a
+// single URL.
+func jetstreamAddrsFromEnv() []string {
+	if addrs := listFromEnv("JS_SERVER_ADDRS"); len(addrs) > 0 {
+		return addrs
+	}
+
+	if addr := os.Getenv("JS_SERVER_ADDR"); addr != "" {
+		return []string{addr}
+	}
+
+	return defaultServerAddrs
+}
+
+// consumerOptionsFromEnv configures how the consumer schedules events and writes them
+// to the database using the JS_SCHEDULER, JS_PARALLEL_WORKERS, JS_BATCH_SIZE and JS_COMPRESS
+// env variables.
+//
+// Recording is configured separately by recorderOptionFromEnv so that replaying a file
+// doesn't also record it.
+func consumerOptionsFromEnv() ([]statusphere.ConsumerOption, error) {
+	var opts []statusphere.ConsumerOption
+
+	if scheduler := os.Getenv("JS_SCHEDULER"); scheduler != "" {
+		workers, err := intFromEnv("JS_PARALLEL_WORKERS")
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, statusphere.WithScheduler(scheduler, workers))
+	}
+
+	batchSize, err := intFromEnv("JS_BATCH_SIZE")
+	if err != nil {
+		return nil, err
+	}
+	if batchSize > 0 {
+		opts = append(opts, statusphere.WithBatchedWrites(batchSize, 0))
+	}
+
+	if compress := os.Getenv("JS_COMPRESS"); compress != "" {
+		enabled, err := strconv.ParseBool(compress)
+		if err != nil {
+			return nil, fmt.Errorf("parsing JS_COMPRESS env: %w", err)
+		}
+		opts = append(opts, statusphere.WithCompression(enabled))
+	}
+
+	return opts, nil
+}
+
+// recorderOptionFromEnv records every event received from Jetstream to the file set by
+// JS_RECORD_FILE, appending to it if it already exists.
+func recorderOptionFromEnv() (statusphere.ConsumerOption, error) {
+	file := os.Getenv("JS_RECORD_FILE")
+	if file == "" {
+		return nil, nil
+	}
+
+	// the file is left open for as long as the app is running
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open JS_RECORD_FILE: %w", err)
+	}
+	return statusphere.WithRecorder(f), nil
+}
+
+// listFromEnv splits a comma separated env variable, ignoring empty entries.
+func listFromEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func intFromEnv(key string) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s env: %w", key, err)
+	}
+	return i, nil
+}
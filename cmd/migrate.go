@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/willdot/statusphere-go/database"
+)
+
+// runMigrate creates the database if it doesn't exist and brings its tables up to date. The
+// app does this itself when it starts, but running it first means a deploy of several
+// processes doesn't have them all racing to migrate.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	applied, err := db.GetAppliedMigrations(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get applied migrations: %s\n", err)
+		return 1
+	}
+	for _, m := range applied {
+		fmt.Printf("%d  %s  (applied %s)\n", m.Version, m.Name, formatMilli(m.AppliedAt))
+	}
+	fmt.Printf("database is at version %d\n", database.LatestMigrationVersion())
+	return 0
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+const moderationUsage = `usage: statuspherego moderation <command>
+
+commands:
+  block -reason text <did>    stop storing and showing an account's statuses
+  unblock <did>               store and show an account's statuses again
+  hide -reason text <uri>     stop showing a status
+  unhide <uri>                show a hidden status again
+  list                        list blocked accounts and hidden statuses
+  log [-limit n]              list the most recent moderation actions`
+
+// cliModerationActor is who moderation actions taken with the CLI are recorded as being
+// made by.
+const cliModerationActor = "cli"
+
+// runModeration blocks accounts and hides statuses and returns the exit code.
+func runModeration(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, moderationUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("moderation "+args[0], flag.ContinueOnError)
+	var reason *string
+	var limit *int
+	switch args[0] {
+	case "block", "hide":
+		reason = flags.String("reason", "", "why the account is blocked or the status is hidden")
+	case "log":
+		limit = flags.Int("limit", 50, "maximum number of actions to list")
+	case "unblock", "unhide", "list":
+	default:
+		fmt.Fprintln(os.Stderr, moderationUsage)
+		return 2
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	var subject string
+	switch args[0] {
+	case "block", "unblock", "hide", "unhide":
+		if flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, moderationUsage)
+			return 2
+		}
+		var err error
+		subject, err = parseModerationSubject(args[0], flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if reason != nil && *reason == "" {
+		fmt.Fprintln(os.Stderr, "-reason is required")
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "block":
+		err = db.BlockDID(ctx, subject, *reason, cliModerationActor)
+		if err == nil {
+			fmt.Printf("blocked %s\n", subject)
+		}
+	case "unblock":
+		err = db.UnblockDID(ctx, subject, cliModerationActor)
+		if errors.Is(err, statusphere.ErrorNotFound) {
+			err = fmt.Errorf("%s isn't blocked", subject)
+		} else if err == nil {
+			fmt.Printf("unblocked %s, backfill it to store statuses created while it was blocked\n", subject)
+		}
+	case "hide":
+		err = db.HideStatus(ctx, subject, *reason, cliModerationActor)
+		if err == nil {
+			fmt.Printf("hid %s\n", subject)
+		}
+	case "unhide":
+		err = db.UnhideStatus(ctx, subject, cliModerationActor)
+		if errors.Is(err, statusphere.ErrorNotFound) {
+			err = fmt.Errorf("%s isn't hidden", subject)
+		} else if err == nil {
+			fmt.Printf("unhid %s\n", subject)
+		}
+	case "list":
+		err = listModeration(ctx, db)
+	case "log":
+		err = listModerationActions(ctx, db, *limit)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseModerationSubject checks the DID or status URI the command acts on.
+func parseModerationSubject(command, arg string) (string, error) {
+	if command == "block" || command == "unblock" {
+		did, err := syntax.ParseDID(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid DID: %w", err)
+		}
+		return did.String(), nil
+	}
+	uri, err := statusphere.ParseStatusURI(arg)
+	if err != nil {
+		return "", fmt.Errorf("invalid status URI: %w", err)
+	}
+	return uri, nil
+}
+
+func listModeration(ctx context.Context, db *database.DB) error {
+	blocked, err := db.GetBlockedDIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("get blocked DIDs: %w", err)
+	}
+	hidden, err := db.GetHiddenStatuses(ctx)
+	if err != nil {
+		return fmt.Errorf("get hidden statuses: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tSUBJECT\tREASON\tCREATED")
+	for _, b := range blocked {
+		fmt.Fprintf(w, "blocked\t%s\t%s\t%s\n", b.Did, b.Reason, formatMilli(b.CreatedAt))
+	}
+	for _, h := range hidden {
+		fmt.Fprintf(w, "hidden\t%s\t%s\t%s\n", h.URI, h.Reason, formatMilli(h.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func listModerationActions(ctx context.Context, db *database.DB, limit int) error {
+	actions, err := db.GetModerationActions(ctx, limit)
+	if err != nil {
+		return fmt.Errorf("get moderation actions: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tSUBJECT\tREASON\tACTOR\tCREATED")
+	for _, a := range actions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Action, a.Subject, a.Reason, a.Actor, formatMilli(a.CreatedAt))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+const paletteUsage = `usage: statuspherego palette <command>
+
+commands:
+  list                list the statuses in the palette
+  import -file path   replace the palette with the one in a JSON file`
+
+// runPalette manages the palette of statuses users can pick from and returns the exit code.
+func runPalette(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, paletteUsage)
+		return 2
+	}
+
+	var palette []statusphere.PaletteStatus
+	switch args[0] {
+	case "list":
+	case "import":
+		flags := flag.NewFlagSet("palette import", flag.ContinueOnError)
+		file := flags.String("file", "", "JSON file with an array of statuses, each with a status, label and category")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *file == "" {
+			fmt.Fprintln(os.Stderr, "-file is required")
+			return 2
+		}
+		var err error
+		palette, err = loadPaletteFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, paletteUsage)
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if args[0] == "import" {
+		err = db.ReplacePalette(ctx, palette)
+		if err == nil {
+			fmt.Printf("imported %d statuses into the palette\n", len(palette))
+		}
+	} else {
+		err = listPalette(ctx, db)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listPalette(ctx context.Context, db *database.DB) error {
+	palette, err := db.GetPalette(ctx)
+	if err != nil {
+		return fmt.Errorf("get palette: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tLABEL\tCATEGORY")
+	for _, status := range palette {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", status.Status, status.Label, status.Category)
+	}
+	return w.Flush()
+}
+
+func loadPaletteFile(file string) ([]statusphere.PaletteStatus, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open palette file: %w", err)
+	}
+	defer f.Close()
+
+	palette, err := statusphere.LoadPalette(f)
+	if err != nil {
+		return nil, fmt.Errorf("load palette file %s: %w", file, err)
+	}
+	return palette, nil
+}
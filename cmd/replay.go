@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// runReplay handles the events in a recorded file, as if they had been received from
+// Jetstream, and returns the exit code.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "newline delimited JSON file of events recorded with JS_RECORD_FILE")
+	speed := flags.Float64("speed", 0, "how fast to replay relative to when the events were recorded, 0 for as fast as possible")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || *speed < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open events file: %s\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	opts, err := consumerOptionsFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid consumer config: %s\n", err)
+		return 1
+	}
+	consumer, err := statusphere.NewConsumer(nil, slog.Default(), db, opts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create consumer: %s\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	count, err := consumer.Replay(ctx, f, *speed)
+	fmt.Printf("replayed %d events\n", count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %s\n", err)
+		return 1
+	}
+	return 0
+}
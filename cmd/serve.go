@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+// shutdownTimeout is how long to wait for the consumer to stop once the app has been asked
+// to exit, which includes writing any batched statuses.
+const shutdownTimeout = time.Second * 10
+
+// consumerLeaseTTL is how long a consumer holds the lease that stops more than one consumer
+// from running at once, and so how long it takes for a standby to take over if the consumer
+// dies without releasing it.
+const consumerLeaseTTL = time.Second * 30
+
+// runApp runs the web server, the consumer or both until the process is asked to exit.
+func runApp(serve, consume bool) int {
+	host := os.Getenv("HOST")
+	if serve && host == "" {
+		slog.Error("missing HOST env variable")
+		return 1
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		slog.Error("create new database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	var server *statusphere.Server
+	if serve {
+		server, err = newServer(host, db)
+		if err != nil {
+			slog.Error("create new server", "error", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	var wg sync.WaitGroup
+	if consume {
+		leader := statusphere.NewLeader(db, "consumer", leaseHolder(), consumerLeaseTTL, slog.Default())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leader.Run(ctx, func(ctx context.Context) {
+				runConsumer(ctx, db)
+			})
+		}()
+	}
+
+	if server != nil {
+		go func() {
+			<-ctx.Done()
+			_ = server.Stop(context.Background())
+		}()
+		server.Run()
+		// the server may have stopped because it failed rather than being asked to, in which
+		// case the consumer needs to be stopped too
+		stop()
+	}
+	<-ctx.Done()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		slog.Warn("timed out waiting for the consumer to stop")
+	}
+	return 0
+}
+
+// runConsumer consumes events and labels, retries dead letters and deletes expired labels
+// until the context is cancelled.
+func runConsumer(ctx context.Context, db *database.DB) {
+	labelConsumers, err := labelConsumersFromEnv(db)
+	if err != nil {
+		slog.Error("create label consumers", "error", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		consumeLoop(ctx, db)
+	}()
+	go func() {
+		defer wg.Done()
+		statusphere.NewDeadLetterRetrier(db, slog.Default()).Run(ctx)
+	}()
+	for _, consumer := range labelConsumers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retryConsume(ctx, db, consumer)
+		}()
+	}
+	if len(labelConsumers) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusphere.NewLabelExpirer(db, slog.Default()).Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// leaseHolder identifies this process to the other processes sharing the database.
+func leaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
+}
+
+func newServer(host string, db *database.DB) (*statusphere.Server, error) {
+	httpClient := &http.Client{
+		Timeout: httpClientTimeoutDuration,
+		Transport: &http.Transport{
+			IdleConnTimeout: transportIdleConnTimeoutDuration,
+		},
+	}
+
+	oauthClient, err := newOAuthClient(host, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := seedPalette(db); err != nil {
+		return nil, err
+	}
+
+	var opts []statusphere.ServerOption
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+	case "database":
+		opts = append(opts, statusphere.WithSharedRateLimits(db))
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+
+	labeler, err := labelerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if labeler != nil {
+		opts = append(opts, labeler)
+	}
+
+	return statusphere.NewServer(host, 8080, db, oauthClient, httpClient, opts...)
+}
+
+// newOAuthClient creates the app's OAuth client, which is a localhost client when there's
+// no host.
+func newOAuthClient(host string, db *database.DB) (*oauth.ClientApp, error) {
+	var config oauth.ClientConfig
+	bind := ":8080"
+	scopes := []string{"atproto", "transition:generic"}
+	if host == "" {
+		config = oauth.NewLocalhostConfig(
+			fmt.Sprintf("http://127.0.0.1%s/oauth-callback", bind),
+			scopes,
+		)
+		slog.Info("configuring localhost OAuth client", "CallbackURL", config.CallbackURL)
+	} else {
+		config = oauth.NewPublicConfig(
+			fmt.Sprintf("%s/oauth-client-metadata.json", host),
+			fmt.Sprintf("%s/oauth-callback", host),
+			scopes,
+		)
+		if err := setClientSecretFromEnv(&config); err != nil {
+			return nil, err
+		}
+	}
+	return oauth.NewClientApp(&config, db), nil
+}
+
+// labelerFromEnv makes the server a labeler if LABELER_DID is set, signing labels with the
+// key in LABELER_SIGNING_KEY, which can be generated with the keys labeler command.
+func labelerFromEnv() (statusphere.ServerOption, error) {
+	labelerDID := os.Getenv("LABELER_DID")
+	if labelerDID == "" {
+		return nil, nil
+	}
+
+	did, err := syntax.ParseDID(labelerDID)
+	if err != nil {
+		return nil, fmt.Errorf("parsing LABELER_DID env: %w", err)
+	}
+	signingKey := os.Getenv("LABELER_SIGNING_KEY")
+	if signingKey == "" {
+		return nil, fmt.Errorf("LABELER_SIGNING_KEY env must be set when LABELER_DID is")
+	}
+	key, err := crypto.ParsePrivateMultibase(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing LABELER_SIGNING_KEY env: %w", err)
+	}
+	return statusphere.WithLabeler(did, key), nil
+}
+
+// seedPalette stores the palette in PALETTE_FILE, or the default palette, if the database
+// doesn't have one yet. Once it has, the palette is managed from the admin page or with the
+// palette command.
+func seedPalette(db *database.DB) error {
+	palette := statusphere.DefaultPalette
+	if file := os.Getenv("PALETTE_FILE"); file != "" {
+		var err error
+		palette, err = loadPaletteFile(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	return statusphere.SeedPalette(ctx, db, palette)
+}
+
+// setClientSecretFromEnv makes the OAuth client a confidential client if a key has been
+// set in OAUTH_CLIENT_SECRET_KEY, which can be generated with the keys generate command.
+// Confidential clients get longer lived sessions than public clients.
+func setClientSecretFromEnv(config *oauth.ClientConfig) error {
+	secret := os.Getenv("OAUTH_CLIENT_SECRET_KEY")
+	if secret == "" {
+		return nil
+	}
+
+	key, err := crypto.ParsePrivateMultibase(secret)
+	if err != nil {
+		return fmt.Errorf("parsing OAUTH_CLIENT_SECRET_KEY env: %w", err)
+	}
+	keyID := os.Getenv("OAUTH_CLIENT_KEY_ID")
+	if keyID == "" {
+		return fmt.Errorf("OAUTH_CLIENT_KEY_ID env must be set when OAUTH_CLIENT_SECRET_KEY is")
+	}
+	if err := config.SetClientSecret(key, keyID); err != nil {
+		return fmt.Errorf("set OAuth client secret: %w", err)
+	}
+	return nil
+}
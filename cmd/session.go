@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+const sessionUsage = `usage: statuspherego session <command>
+
+commands:
+  list                        list the OAuth sessions of logged in users
+  revoke <did> [session id]   log a user out of a session, or all of their sessions,
+                              revoking its tokens with their authorization server
+
+HOST and OAUTH_CLIENT_SECRET_KEY must be set as they are for serve, so that the
+authorization server knows which client the tokens are being revoked by.`
+
+// runSession manages the OAuth sessions of logged in users and returns the exit code.
+func runSession(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, sessionUsage)
+		return 2
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		err = listSessions(ctx, db)
+	case "revoke":
+		err = revokeSession(ctx, db, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, sessionUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func listSessions(ctx context.Context, db *database.DB) error {
+	sessions, err := db.GetSessions(ctx)
+	if err != nil {
+		return fmt.Errorf("get sessions: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DID\tSESSION ID\tHOST\tSCOPES\tCREATED")
+	for _, session := range sessions {
+		created := "unknown"
+		if session.CreatedAt > 0 {
+			created = formatMilli(session.CreatedAt)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", session.Did, session.SessionID, session.HostURL, strings.Join(session.Scopes, " "), created)
+	}
+	return w.Flush()
+}
+
+// revokeSession logs a user out of a session, or all of their sessions if no session ID is
+// given, revoking the tokens with their authorization server. If a session can't be
+// revoked the rest still are, and every failure is returned.
+func revokeSession(ctx context.Context, db *database.DB, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("expected a DID and optionally a session ID")
+	}
+	did, err := syntax.ParseDID(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid DID: %w", err)
+	}
+
+	oauthClient, err := newOAuthClient(os.Getenv("HOST"), db)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := db.GetAccountSessions(ctx, did)
+	if err != nil {
+		return fmt.Errorf("get sessions: %w", err)
+	}
+	revoked := 0
+	var errs []error
+	for _, session := range sessions {
+		if len(args) == 2 && session.SessionID != args[1] {
+			continue
+		}
+		if err := statusphere.RevokeSession(ctx, oauthClient, did, session.SessionID); err != nil {
+			// carry on so that one failure doesn't leave the user's other sessions logged in
+			errs = append(errs, fmt.Errorf("revoke session %s: %w", session.SessionID, err))
+			continue
+		}
+		fmt.Printf("revoked session %s\n", session.SessionID)
+		revoked++
+	}
+	if revoked == 0 && len(errs) == 0 {
+		return fmt.Errorf("no session to revoke for %s", did)
+	}
+	fmt.Printf("revoked %d sessions, %d failed\n", revoked, len(errs))
+	return errors.Join(errs...)
+}
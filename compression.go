@@ -0,0 +1,74 @@
+package statusphere
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ConsumerStats are the totals of what has been received from Jetstream, used to compare
+// how much bandwidth compression saves.
+type ConsumerStats struct {
+	Events int64
+	// BytesReceived is how many bytes were read off the websocket, which is compressed
+	// if compression is being used.
+	BytesReceived int64
+	// UncompressedBytes is how many bytes the events would have been without compression.
+	UncompressedBytes int64
+}
+
+// CompressionRatio is how many times smaller the received bytes were than uncompressed.
+func (s ConsumerStats) CompressionRatio() float64 {
+	if s.BytesReceived == 0 {
+		return 0
+	}
+	return float64(s.UncompressedBytes) / float64(s.BytesReceived)
+}
+
+// consumerStats accumulates stats across connections.
+type consumerStats struct {
+	events            atomic.Int64
+	bytesReceived     atomic.Int64
+	uncompressedBytes atomic.Int64
+}
+
+// received records a message of n bytes read off the websocket, which was uncompressed bytes
+// once decompressed.
+func (s *consumerStats) received(n, uncompressed int) {
+	s.events.Add(1)
+	s.bytesReceived.Add(int64(n))
+	s.uncompressedBytes.Add(int64(uncompressed))
+}
+
+func (s *consumerStats) snapshot() ConsumerStats {
+	return ConsumerStats{
+		Events:            s.events.Load(),
+		BytesReceived:     s.bytesReceived.Load(),
+		UncompressedBytes: s.uncompressedBytes.Load(),
+	}
+}
+
+// newMessageDecoder creates a decoder for compressed Jetstream messages, which are compressed
+// with Jetstream's own dictionary.
+func newMessageDecoder() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderDicts(models.ZSTDDictionary))
+}
+
+// decompressMessage decompresses a message received on a compressed connection. If the
+// server sent it uncompressed, the error is one that isCompressionUnsupported reports.
+func decompressMessage(decoder *zstd.Decoder, msg []byte) ([]byte, error) {
+	b, err := decoder.DecodeAll(msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress message: %w", err)
+	}
+	return b, nil
+}
+
+// isCompressionUnsupported reports whether the connection failed because the server sent
+// uncompressed messages even though compression was asked for.
+func isCompressionUnsupported(err error) bool {
+	return errors.Is(err, zstd.ErrMagicMismatch)
+}
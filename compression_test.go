@@ -0,0 +1,64 @@
+package statusphere
+
+import (
+	"testing"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressMessage(t *testing.T) {
+	decoder, err := newMessageDecoder()
+	if err != nil {
+		t.Fatalf("create decoder: %s", err)
+	}
+	defer decoder.Close()
+
+	msg := []byte(`{"did":"did:plc:test","time_us":1741600000000000,"kind":"commit"}`)
+
+	t.Run("zstd frame", func(t *testing.T) {
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(models.ZSTDDictionary))
+		if err != nil {
+			t.Fatalf("create encoder: %s", err)
+		}
+		defer encoder.Close()
+
+		got, err := decompressMessage(decoder, encoder.EncodeAll(msg, nil))
+		if err != nil {
+			t.Fatalf("decompress message: %s", err)
+		}
+		if string(got) != string(msg) {
+			t.Fatalf("expected %s, got %s", msg, got)
+		}
+	})
+
+	t.Run("uncompressed JSON", func(t *testing.T) {
+		_, err := decompressMessage(decoder, msg)
+		if err == nil {
+			t.Fatal("expected an error decompressing an uncompressed message")
+		}
+		if !isCompressionUnsupported(err) {
+			t.Fatalf("expected the error to show compression is unsupported, got %s", err)
+		}
+	})
+}
+
+func TestConsumerStats(t *testing.T) {
+	var stats consumerStats
+	// two compressed messages and one from an uncompressed connection
+	stats.received(100, 400)
+	stats.received(50, 150)
+	stats.received(250, 250)
+
+	expected := ConsumerStats{Events: 3, BytesReceived: 400, UncompressedBytes: 800}
+	got := stats.snapshot()
+	if got != expected {
+		t.Fatalf("expected %+v, got %+v", expected, got)
+	}
+	if ratio := got.CompressionRatio(); ratio != 2 {
+		t.Fatalf("expected a compression ratio of 2, got %f", ratio)
+	}
+	if ratio := (ConsumerStats{}).CompressionRatio(); ratio != 0 {
+		t.Fatalf("expected a compression ratio of 0 when nothing has been received, got %f", ratio)
+	}
+}
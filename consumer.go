@@ -3,106 +3,367 @@ package statusphere
 import (
 	"context"
 	"encoding/json"
-
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/client/schedulers/parallel"
 	"github.com/bluesky-social/jetstream/pkg/client/schedulers/sequential"
 	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// SchedulerSequential handles one event at a time in the order they are received.
+	SchedulerSequential = "sequential"
+	// SchedulerParallel handles events on a pool of workers. Events for the same DID
+	// are still handled in the order they are received.
+	SchedulerParallel = "parallel"
+
+	defaultParallelWorkers = 10
+	defaultBatchInterval   = time.Millisecond * 500
+
+	// when handing over to a different Jetstream instance or from a different consumer, the
+	// cursor is rewound by this much to cover clock differences between instances and
+	// events the previous consumer hadn't finished handling. Statuses are stored
+	// idempotently so replaying a few events is harmless.
+	cursorHandoffRewind = time.Second * 5
+	// the cursor used when no events have been received yet
+	initialCursorLookback = time.Minute
+
+	statsLogInterval = time.Minute * 5
+
+	defaultJetstreamURL = "ws://localhost:6008/subscribe"
+	jetstreamUserAgent  = "statusphere-go"
 )
 
 type consumer struct {
-	cfg     *client.ClientConfig
-	handler handler
-	logger  *slog.Logger
+	// dialer is the consumer's own so that how it connects doesn't depend on, or change, how
+	// anything else in the process dials websockets
+	dialer       *websocket.Dialer
+	endpoints    *endpointPool
+	cursor       atomic.Int64
+	rewindCursor bool
+	// consuming is set while Consume is running
+	consuming       atomic.Bool
+	store           HandlerStore
+	logger          *slog.Logger
+	scheduler       string
+	parallelWorkers int
+	batchSize       int
+	batchInterval   time.Duration
+	compress        bool
+	recorder        *eventRecorder
+	// endpoints that have been found to not support compression
+	uncompressedEndpoints map[string]bool
+	stats                 consumerStats
+}
+
+// ConsumerOption configures optional behaviour of the consumer.
+type ConsumerOption func(c *consumer)
+
+// WithScheduler sets which jetstream scheduler is used to handle events. Either
+// SchedulerSequential (the default) or SchedulerParallel. When using the parallel
+// scheduler, workers sets how many events can be handled at once.
+func WithScheduler(scheduler string, workers int) ConsumerOption {
+	return func(c *consumer) {
+		c.scheduler = scheduler
+		if workers > 0 {
+			c.parallelWorkers = workers
+		}
+	}
 }
 
-func NewConsumer(jsAddr string, logger *slog.Logger, store HandlerStore) *consumer {
-	cfg := client.DefaultClientConfig()
-	if jsAddr != "" {
-		cfg.WebsocketURL = jsAddr
+// WithBatchedWrites makes the consumer buffer statuses and write them to the store
+// in a single transaction once size statuses are buffered or interval has passed,
+// whichever comes first.
+func WithBatchedWrites(size int, interval time.Duration) ConsumerOption {
+	return func(c *consumer) {
+		c.batchSize = size
+		if interval > 0 {
+			c.batchInterval = interval
+		}
 	}
-	cfg.WantedCollections = []string{
-		"xyz.statusphere.status",
+}
+
+// WithCompression sets whether to ask Jetstream for zstd compressed messages, which are
+// roughly a quarter of the size. Compression is used by default. If an endpoint doesn't
+// support compression, the consumer falls back to uncompressed messages for it.
+func WithCompression(enabled bool) ConsumerOption {
+	return func(c *consumer) {
+		c.compress = enabled
 	}
-	cfg.WantedDids = []string{}
+}
 
-	return &consumer{
-		cfg:    cfg,
-		logger: logger,
-		handler: handler{
-			store: store,
+// WithRecorder makes the consumer write every event it receives to w as newline delimited
+// JSON, in the format that Replay reads.
+func WithRecorder(w io.Writer) ConsumerOption {
+	return func(c *consumer) {
+		c.recorder = &eventRecorder{w: w}
+	}
+}
+
+// WithStartCursor makes the consumer start from the cursor another consumer reached, such as
+// the one saved by the process that was consuming before this one, rather than from a minute
+// ago. The cursor is rewound a little so that events the other consumer was still handling
+// aren't missed.
+func WithStartCursor(cursor int64) ConsumerOption {
+	return func(c *consumer) {
+		if cursor > 0 {
+			c.cursor.Store(cursor)
+			c.rewindCursor = true
+		}
+	}
+}
+
+// NewConsumer creates a consumer that reads from the given Jetstream websocket URLs. Only
+// one URL is connected to at a time and if the connection fails, the next healthiest URL
+// is used.
+func NewConsumer(jsAddrs []string, logger *slog.Logger, store HandlerStore, opts ...ConsumerOption) (*consumer, error) {
+	if len(jsAddrs) == 0 {
+		jsAddrs = []string{defaultJetstreamURL}
+	}
+
+	c := &consumer{
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: time.Second * 45,
 		},
+		endpoints:       newEndpointPool(jsAddrs),
+		logger:          logger,
+		store:           store,
+		scheduler:       SchedulerSequential,
+		parallelWorkers: defaultParallelWorkers,
+		batchInterval:   defaultBatchInterval,
+		compress:        true,
+
+		uncompressedEndpoints: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	switch c.scheduler {
+	case SchedulerSequential, SchedulerParallel:
+	default:
+		return nil, fmt.Errorf("unknown scheduler %q", c.scheduler)
+	}
+
+	return c, nil
+}
+
+// newScheduler creates the configured scheduler along with a function that must be called
+// once no more events will be added, which waits for any in flight events to be handled
+// and any batched statuses to be written.
+func (c *consumer) newScheduler() (client.Scheduler, func()) {
+	tracker := newCursorTracker(&c.cursor)
+	h := &handler{
+		store:  c.store,
+		cursor: tracker,
+	}
+
+	var batcher *statusBatcher
+	if c.batchSize > 1 {
+		batcher = newStatusBatcher(c.store, c.batchSize, c.batchInterval, c.logger, h.deadLetter, h.written)
+		go batcher.Run()
+		h.batcher = batcher
+	}
+
+	var scheduler client.Scheduler
+	switch c.scheduler {
+	case SchedulerParallel:
+		scheduler = parallel.NewScheduler(c.parallelWorkers, "statusphere", c.logger, h.HandleEvent)
+	default:
+		scheduler = sequential.NewScheduler("statusphere", c.logger, h.HandleEvent)
+	}
+	tracked := &trackingScheduler{Scheduler: scheduler, tracker: tracker}
+
+	return tracked, func() {
+		scheduler.Shutdown()
+		if batcher != nil {
+			batcher.Close()
+		}
 	}
 }
 
+// Consume connects to the current Jetstream endpoint and handles events until the
+// context is cancelled or the connection fails. If it fails, the next call to Consume
+// will use a different endpoint if one is available, so callers should retry after
+// waiting for RetryDelay.
 func (c *consumer) Consume(ctx context.Context) error {
-	scheduler := sequential.NewScheduler("jetstream_localdev", c.logger, c.handler.HandleEvent)
-	defer scheduler.Shutdown()
+	c.consuming.Store(true)
+	defer c.consuming.Store(false)
 
-	client, err := client.NewClient(c.cfg, c.logger, scheduler)
-	if err != nil {
-		return fmt.Errorf("failed to create client: %w", err)
+	scheduler, shutdown := c.newScheduler()
+	defer shutdown()
+
+	endpoint := c.endpoints.Current()
+	compress := c.compress && !c.uncompressedEndpoints[endpoint]
+
+	if c.recorder != nil {
+		scheduler = &recordingScheduler{Scheduler: scheduler, recorder: c.recorder}
 	}
 
-	cursor := time.Now().Add(1 * -time.Minute).UnixMicro()
+	stopLogging := c.logStatsPeriodically()
+	defer stopLogging()
+
+	cursor := c.startCursor()
+	subscribeURL, err := jetstreamSubscribeURL(endpoint, cursor)
+	if err != nil {
+		return fmt.Errorf("invalid jetstream address %q: %w", endpoint, err)
+	}
 
-	if err := client.ConnectAndRead(ctx, &cursor); err != nil {
-		return fmt.Errorf("connect and read: %w", err)
+	c.logger.Info("consuming from jetstream", "url", endpoint, "cursor", cursor, "compressed", compress)
+	connectedAt := time.Now()
+	err = c.connectAndRead(ctx, subscribeURL, compress, scheduler)
+	if err != nil && compress && isCompressionUnsupported(err) {
+		// not the endpoint's fault, so reconnect straight away without compression
+		c.uncompressedEndpoints[endpoint] = true
+		c.logger.Warn("jetstream endpoint doesn't support compression, falling back to uncompressed", "url", endpoint)
+		return fmt.Errorf("connect and read from %s: %w", endpoint, err)
+	}
+	if err != nil && ctx.Err() == nil {
+		rotated := c.endpoints.Failed(time.Since(connectedAt))
+		if rotated {
+			c.rewindCursor = true
+			c.logger.Warn("rotating jetstream endpoint", "failed", endpoint, "next", c.endpoints.Current())
+		}
+		return fmt.Errorf("connect and read from %s: %w", endpoint, err)
 	}
+	c.endpoints.Succeeded()
 
 	slog.Info("stopping consume")
 	return nil
 }
 
-type HandlerStore interface {
-	CreateStatus(status Status) error
-}
+// connectAndRead connects to Jetstream and passes each event received to the scheduler until
+// the context is done or the connection fails.
+func (c *consumer) connectAndRead(ctx context.Context, subscribeURL string, compress bool, scheduler client.Scheduler) error {
+	header := http.Header{}
+	header.Set("User-Agent", jetstreamUserAgent)
+	var decoder *zstd.Decoder
+	if compress {
+		header.Set("Socket-Encoding", "zstd")
+		var err error
+		decoder, err = newMessageDecoder()
+		if err != nil {
+			return fmt.Errorf("create zstd decoder: %w", err)
+		}
+		defer decoder.Close()
+	}
 
-type handler struct {
-	store HandlerStore
-}
+	con, _, err := c.dialer.DialContext(ctx, subscribeURL, header)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer con.Close()
+	// reading blocks until the next message arrives, so the connection is closed as soon as
+	// the context is done, otherwise a consumer that has lost its lease could keep handling
+	// events until the next one arrives
+	stopClosing := context.AfterFunc(ctx, func() { _ = con.Close() })
+	defer stopClosing()
+
+	for {
+		_, msg, err := con.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("read message: %w", err)
+		}
+		received := len(msg)
+		if decoder != nil {
+			msg, err = decompressMessage(decoder, msg)
+			if err != nil {
+				return err
+			}
+		}
+		c.stats.received(received, len(msg))
 
-func (h *handler) HandleEvent(ctx context.Context, event *models.Event) error {
-	if event.Commit == nil {
-		return nil
+		var event models.Event
+		if err := json.Unmarshal(msg, &event); err != nil {
+			return fmt.Errorf("unmarshal event: %w", err)
+		}
+		if err := scheduler.AddWork(ctx, event.Did, &event); err != nil {
+			return fmt.Errorf("add event to scheduler: %w", err)
+		}
 	}
+}
 
-	switch event.Commit.Operation {
-	case models.CommitOperationCreate:
-		return h.handleCreateEvent(ctx, event)
-	default:
-		return nil
+// jetstreamSubscribeURL returns the URL to subscribe to statuses from the Jetstream endpoint,
+// starting from the cursor.
+func jetstreamSubscribeURL(endpoint string, cursor int64) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
 	}
+	query := u.Query()
+	query.Set("wantedCollections", statusCollection)
+	query.Set("cursor", strconv.FormatInt(cursor, 10))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
 }
 
-type StatusRecord struct {
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"createdAt"`
+// RetryDelay is how long to wait before calling Consume again after it failed.
+func (c *consumer) RetryDelay() time.Duration {
+	return c.endpoints.RetryDelay()
 }
 
-func (h *handler) handleCreateEvent(_ context.Context, event *models.Event) error {
-	var statusRecord StatusRecord
-	if err := json.Unmarshal(event.Commit.Record, &statusRecord); err != nil {
-		slog.Error("unmarshal record", "error", err)
-		return nil
+// Status returns the Jetstream endpoint being consumed from and the cursor.
+func (c *consumer) Status() ConsumerStatus {
+	return ConsumerStatus{
+		Name:      "jetstream",
+		Endpoint:  c.endpoints.Current(),
+		Cursor:    c.cursor.Load(),
+		Connected: c.consuming.Load(),
 	}
+}
 
-	uri := fmt.Sprintf("at://%s/%s/%s", event.Did, event.Commit.Collection, event.Commit.RKey)
+// Stats returns the totals of what has been received from Jetstream.
+func (c *consumer) Stats() ConsumerStats {
+	return c.stats.snapshot()
+}
 
-	status := Status{
-		URI:       uri,
-		Did:       event.Did,
-		Status:    statusRecord.Status,
-		CreatedAt: statusRecord.CreatedAt.UnixMilli(),
-		IndexedAt: time.Now().UnixMilli(),
-	}
-	err := h.store.CreateStatus(status)
-	if err != nil {
-		slog.Error("failed to store status", "error", err)
+func (c *consumer) logStatsPeriodically() func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(statsLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				stats := c.Stats()
+				c.logger.Info("jetstream stats", "events", stats.Events, "bytes received", stats.BytesReceived, "uncompressed bytes", stats.UncompressedBytes, "compression ratio", fmt.Sprintf("%.2f", stats.CompressionRatio()))
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// startCursor returns the cursor to connect with, which continues on from the last event
+// that was handled.
+func (c *consumer) startCursor() int64 {
+	cursor := c.cursor.Load()
+	if cursor == 0 {
+		// remember where we started from so that if no events are received before the
+		// connection fails, the next connection doesn't skip any
+		cursor = time.Now().Add(-initialCursorLookback).UnixMicro()
+		c.cursor.CompareAndSwap(0, cursor)
+		return cursor
 	}
 
-	return nil
+	if c.rewindCursor {
+		c.rewindCursor = false
+		cursor -= cursorHandoffRewind.Microseconds()
+	}
+	return cursor
 }
@@ -0,0 +1,313 @@
+package statusphere_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+	"github.com/willdot/statusphere-go/internal/fakejetstream"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+const consumerTestDID = "did:plc:jetstreamaccount"
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db, err := database.New(path.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatalf("create database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestJetstream serves a fake Jetstream, returning it and its subscribe URL.
+func newTestJetstream(t *testing.T) (*fakejetstream.Server, string) {
+	t.Helper()
+
+	js, err := fakejetstream.New()
+	if err != nil {
+		t.Fatalf("create jetstream: %s", err)
+	}
+	srv := httptest.NewServer(js)
+	t.Cleanup(srv.Close)
+	t.Cleanup(js.Close)
+	return js, "ws" + strings.TrimPrefix(srv.URL, "http") + "/subscribe"
+}
+
+// consumeInBackground runs Consume once, returning a channel that receives what it returned.
+func consumeInBackground(ctx context.Context, consumer interface{ Consume(context.Context) error }) <-chan error {
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- consumer.Consume(ctx)
+	}()
+	return consumed
+}
+
+// stopConsuming cancels the consumer and waits for it to stop, which it should do straight
+// away rather than once it next receives a message.
+func stopConsuming(t *testing.T, cancel context.CancelFunc, consumed <-chan error) {
+	t.Helper()
+
+	cancel()
+	select {
+	case err := <-consumed:
+		if err != nil {
+			t.Fatalf("expected the consumer to stop cleanly, got %s", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("consumer didn't stop")
+	}
+}
+
+// expectStatuses waits for the stored statuses, most recent first, to be the ones given by
+// their record key and status.
+func expectStatuses(t *testing.T, db *database.DB, expected ...string) {
+	t.Helper()
+
+	testapp.WaitFor(t, func() error {
+		statuses, err := db.GetStatuses(context.Background(), 10)
+		if err != nil {
+			return fmt.Errorf("get statuses: %w", err)
+		}
+		var got []string
+		for _, status := range statuses {
+			got = append(got, status.URI[strings.LastIndex(status.URI, "/")+1:]+" "+status.Status)
+		}
+		if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+			return fmt.Errorf("expected statuses %v, got %v", expected, got)
+		}
+		return nil
+	})
+}
+
+// statusEvent returns an event creating a status that Jetstream received at the given time.
+func statusEvent(t *testing.T, rkey, status string, at time.Time) *models.Event {
+	t.Helper()
+
+	record, err := json.Marshal(map[string]any{
+		"$type":     "xyz.statusphere.status",
+		"status":    status,
+		"createdAt": at.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		t.Fatalf("marshal record: %s", err)
+	}
+	return &models.Event{
+		Did:    consumerTestDID,
+		TimeUS: at.UnixMicro(),
+		Kind:   models.EventKindCommit,
+		Commit: &models.Commit{
+			Rev:        rkey,
+			Operation:  models.CommitOperationCreate,
+			Collection: "xyz.statusphere.status",
+			RKey:       rkey,
+			Record:     record,
+			CID:        "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+		},
+	}
+}
+
+func TestConsumeCreateUpdateDelete(t *testing.T) {
+	db := newTestDB(t)
+	js, url := newTestJetstream(t)
+	createdAt := time.Now()
+
+	// published before connecting, which are sent as the consumer starts from a minute ago
+	if err := js.CreateStatus(consumerTestDID, "3lsxb3n2bqs2a", "👍", createdAt); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+	if err := js.CreateStatus(consumerTestDID, "3lsxb3n2bqs2b", "🤔", createdAt.Add(time.Second)); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+
+	consumer, err := statusphere.NewConsumer([]string{url}, slog.Default(), db)
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := consumeInBackground(ctx, consumer)
+	defer stopConsuming(t, cancel, consumed)
+
+	expectStatuses(t, db, "3lsxb3n2bqs2b 🤔", "3lsxb3n2bqs2a 👍")
+
+	if err := js.UpdateStatus(consumerTestDID, "3lsxb3n2bqs2a", "😊", createdAt); err != nil {
+		t.Fatalf("update status: %s", err)
+	}
+	expectStatuses(t, db, "3lsxb3n2bqs2b 🤔", "3lsxb3n2bqs2a 😊")
+
+	js.DeleteStatus(consumerTestDID, "3lsxb3n2bqs2b")
+	expectStatuses(t, db, "3lsxb3n2bqs2a 😊")
+
+	current, err := db.GetCurrentStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get current statuses: %s", err)
+	}
+	if len(current) != 1 || current[0].Status != "😊" {
+		t.Fatalf("expected the updated status to be the current status, got %+v", current)
+	}
+}
+
+// TestConsumeReplayedEvents consumes the same events twice, as a consumer that starts again
+// does, and checks the statuses are only stored and counted once.
+func TestConsumeReplayedEvents(t *testing.T) {
+	db := newTestDB(t)
+	js, url := newTestJetstream(t)
+	createdAt := time.Now()
+
+	if err := js.CreateStatus(consumerTestDID, "3lsxb3n2bqs2a", "👍", createdAt); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+	if err := js.CreateStatus(consumerTestDID, "3lsxb3n2bqs2b", "👍", createdAt.Add(time.Second)); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+
+	for i, last := range []string{"3lsxb3n2bqs2c", "3lsxb3n2bqs2d"} {
+		consumer, err := statusphere.NewConsumer([]string{url}, slog.Default(), db)
+		if err != nil {
+			t.Fatalf("create consumer: %s", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		consumed := consumeInBackground(ctx, consumer)
+
+		// once a status published after connecting is stored, every event before it has
+		// been handled
+		if err := js.CreateStatus(consumerTestDID, last, "🤔", createdAt.Add(time.Second*time.Duration(2+i))); err != nil {
+			t.Fatalf("create status: %s", err)
+		}
+		testapp.WaitFor(t, func() error {
+			statuses, err := db.GetStatuses(context.Background(), 10)
+			if err != nil {
+				return fmt.Errorf("get statuses: %w", err)
+			}
+			if len(statuses) == 0 || !strings.HasSuffix(statuses[0].URI, last) {
+				return fmt.Errorf("expected %s to be stored", last)
+			}
+			return nil
+		})
+		stopConsuming(t, cancel, consumed)
+	}
+
+	expectStatuses(t, db, "3lsxb3n2bqs2d 🤔", "3lsxb3n2bqs2c 🤔", "3lsxb3n2bqs2b 👍", "3lsxb3n2bqs2a 👍")
+	top, err := db.GetTopStatuses(context.Background(), createdAt.UTC().Format(time.DateOnly), 10)
+	if err != nil {
+		t.Fatalf("get top statuses: %s", err)
+	}
+	if len(top) != 2 || top[0].Count != 2 || top[1].Count != 2 {
+		t.Fatalf("expected each status to be counted twice, got %+v", top)
+	}
+}
+
+// TestConsumeRewindsCursorOnHandoff disconnects the consumer from one Jetstream instance so
+// that it moves on to another, which has an event from just before the last one the consumer
+// handled that the first instance never sent, as instances' clocks differ. The cursor is
+// rewound when handing over so the event isn't missed.
+func TestConsumeRewindsCursorOnHandoff(t *testing.T) {
+	db := newTestDB(t)
+	first, firstURL := newTestJetstream(t)
+	second, secondURL := newTestJetstream(t)
+
+	consumer, err := statusphere.NewConsumer([]string{firstURL, secondURL}, slog.Default(), db)
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	createdAt := time.Now()
+	if err := first.CreateStatus(consumerTestDID, "3lsxb3n2bqs2b", "👍", createdAt); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+	consumed := consumeInBackground(ctx, consumer)
+	expectStatuses(t, db, "3lsxb3n2bqs2b 👍")
+	cursor := consumer.Status().Cursor
+
+	// the second instance saw an event two seconds before the first instance's last one
+	second.Publish(statusEvent(t, "3lsxb3n2bqs2a", "🤔", time.UnixMicro(cursor).Add(-time.Second*2)))
+
+	first.Close()
+	select {
+	case err := <-consumed:
+		if err == nil {
+			t.Fatal("expected the consumer to fail when disconnected")
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("consumer didn't notice being disconnected")
+	}
+
+	consumed = consumeInBackground(ctx, consumer)
+	defer stopConsuming(t, cancel, consumed)
+	expectStatuses(t, db, "3lsxb3n2bqs2b 👍", "3lsxb3n2bqs2a 🤔")
+	if status := consumer.Status(); status.Endpoint != secondURL {
+		t.Fatalf("expected the consumer to move on to the second instance, got %s", status.Endpoint)
+	}
+}
+
+// TestConsumeResumesFromStartCursor starts a consumer from the cursor a previous consumer
+// saved, which is from before the last minute, and checks it picks up the event the previous
+// consumer was handling when it stopped but not the ones before it.
+func TestConsumeResumesFromStartCursor(t *testing.T) {
+	db := newTestDB(t)
+	js, url := newTestJetstream(t)
+
+	handled := time.Now().Add(-time.Minute * 10)
+	js.Publish(statusEvent(t, "3lsxb3n2bqs2a", "👍", handled))
+	unfinished := time.Now().Add(-time.Minute * 3)
+	js.Publish(statusEvent(t, "3lsxb3n2bqs2b", "🤔", unfinished))
+	cursor := unfinished.Add(time.Second).UnixMicro()
+
+	consumer, err := statusphere.NewConsumer([]string{url}, slog.Default(), db, statusphere.WithStartCursor(cursor))
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := consumeInBackground(ctx, consumer)
+	defer stopConsuming(t, cancel, consumed)
+
+	expectStatuses(t, db, "3lsxb3n2bqs2b 🤔")
+}
+
+// TestConsumeFallsBackToUncompressed connects to a Jetstream that ignores the request for
+// compressed messages and checks the consumer reconnects without compression, counting the
+// uncompressed messages as received at their full size.
+func TestConsumeFallsBackToUncompressed(t *testing.T) {
+	db := newTestDB(t)
+	js, url := newTestJetstream(t)
+	js.SetCompressionSupported(false)
+	if err := js.CreateStatus(consumerTestDID, "3lsxb3n2bqs2a", "👍", time.Now()); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+
+	consumer, err := statusphere.NewConsumer([]string{url}, slog.Default(), db)
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := consumer.Consume(ctx); err == nil {
+		t.Fatal("expected consuming to fail when messages aren't compressed")
+	}
+	if delay := consumer.RetryDelay(); delay != 0 {
+		t.Fatalf("expected to reconnect straight away without compression, got a delay of %s", delay)
+	}
+
+	consumed := consumeInBackground(ctx, consumer)
+	defer stopConsuming(t, cancel, consumed)
+	expectStatuses(t, db, "3lsxb3n2bqs2a 👍")
+
+	stats := consumer.Stats()
+	if stats.BytesReceived == 0 || stats.UncompressedBytes != stats.BytesReceived {
+		t.Fatalf("expected uncompressed messages to be counted at their full size, got %+v", stats)
+	}
+}
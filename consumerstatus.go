@@ -0,0 +1,65 @@
+package statusphere
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// consumerStatusInterval is how often a consumer's status is saved while it's running.
+const consumerStatusInterval = time.Second * 10
+
+// ConsumerStatus is what a consumer last reported about its connection. It's saved to the
+// store so that it can be seen from the admin dashboard, which may be served by a different
+// process to the one consuming.
+type ConsumerStatus struct {
+	// Name identifies the consumer, which is jetstream, firehose or labeler followed by the
+	// labeler's DID.
+	Name string
+	// Endpoint is the URL being consumed from.
+	Endpoint string
+	// Cursor is how far through the stream the consumer has got. For Jetstream it's the
+	// time of the last event handled in unix microseconds, otherwise it's the sequence
+	// number of the last event.
+	Cursor    int64
+	Connected bool
+	// UpdatedAt is when the status was saved, in unix milliseconds.
+	UpdatedAt int64
+}
+
+// ConsumerStatusStore stores the latest status of each consumer.
+type ConsumerStatusStore interface {
+	SaveConsumerStatus(ctx context.Context, status ConsumerStatus) error
+	GetConsumerStatuses(ctx context.Context) ([]ConsumerStatus, error)
+}
+
+// ReportConsumerStatus saves the consumer's status every 10 seconds until the context is
+// cancelled, after which it's saved once more as disconnected.
+func ReportConsumerStatus(ctx context.Context, store ConsumerStatusStore, status func() ConsumerStatus, logger *slog.Logger) {
+	save := func(ctx context.Context, s ConsumerStatus) {
+		s.UpdatedAt = time.Now().UnixMilli()
+		if err := store.SaveConsumerStatus(ctx, s); err != nil && ctx.Err() == nil {
+			logger.Error("save consumer status", "name", s.Name, "error", err)
+		}
+	}
+
+	ticker := time.NewTicker(consumerStatusInterval)
+	defer ticker.Stop()
+
+	save(ctx, status())
+	for {
+		select {
+		case <-ctx.Done():
+			// the context is already cancelled so a fresh one is needed to save the last
+			// status
+			saveCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			s := status()
+			s.Connected = false
+			save(saveCtx, s)
+			return
+		case <-ticker.C:
+			save(ctx, status())
+		}
+	}
+}
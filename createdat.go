@@ -0,0 +1,39 @@
+package statusphere
+
+import "time"
+
+// A status's createdAt comes from its record, so it's whatever the client that created it
+// said. Statuses with a createdAt that can't be right are still stored, but are ordered by
+// when they were indexed instead and flagged so they can be reviewed.
+const (
+	// createdAtMaxSkew is how far after a status was indexed it can say it was created,
+	// allowing for clients with clocks that are a little ahead.
+	createdAtMaxSkew = time.Minute * 10
+
+	// FlagFutureCreatedAt flags a status that says it was created after it was indexed.
+	FlagFutureCreatedAt = "future createdAt"
+	// FlagAncientCreatedAt flags a status that says it was created before the network
+	// existed.
+	FlagAncientCreatedAt = "implausibly old createdAt"
+)
+
+// earliestCreatedAt is earlier than any status could really have been created, as the
+// network didn't exist yet. Unlike the future bound it's a fixed date rather than relative to
+// indexedAt, since backfill indexes statuses long after they were created, so a status can
+// legitimately say it was created months or years before it was indexed.
+var earliestCreatedAt = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// EffectiveCreatedAt returns when a status is treated as having been created, in unix
+// milliseconds, along with a flag if its createdAt isn't plausible. That's its createdAt
+// unless it's more than a few minutes after it was indexed or before the network existed,
+// in which case it's when it was indexed.
+func EffectiveCreatedAt(createdAt, indexedAt int64) (int64, string) {
+	switch {
+	case createdAt > indexedAt+createdAtMaxSkew.Milliseconds():
+		return indexedAt, FlagFutureCreatedAt
+	case createdAt < earliestCreatedAt.UnixMilli():
+		return indexedAt, FlagAncientCreatedAt
+	default:
+		return createdAt, ""
+	}
+}
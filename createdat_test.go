@@ -0,0 +1,43 @@
+package statusphere
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEffectiveCreatedAt(t *testing.T) {
+	indexedAt := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		createdAt     time.Time
+		indexedAt     time.Time
+		expectedAt    time.Time
+		expectedFlag  string
+		zeroCreatedAt bool
+	}{
+		{name: "normal", createdAt: indexedAt.Add(-time.Second), indexedAt: indexedAt, expectedAt: indexedAt.Add(-time.Second)},
+		{name: "clock a little ahead", createdAt: indexedAt.Add(createdAtMaxSkew), indexedAt: indexedAt, expectedAt: indexedAt.Add(createdAtMaxSkew)},
+		{name: "future", createdAt: indexedAt.Add(createdAtMaxSkew + time.Millisecond), indexedAt: indexedAt, expectedAt: indexedAt, expectedFlag: FlagFutureCreatedAt},
+		{name: "far future", createdAt: time.Date(2099, time.January, 1, 0, 0, 0, 0, time.UTC), indexedAt: indexedAt, expectedAt: indexedAt, expectedFlag: FlagFutureCreatedAt},
+		{name: "backfilled long after it was created", createdAt: earliestCreatedAt, indexedAt: indexedAt, expectedAt: earliestCreatedAt},
+		{name: "before the network existed", createdAt: earliestCreatedAt.Add(-time.Millisecond), indexedAt: indexedAt, expectedAt: indexedAt, expectedFlag: FlagAncientCreatedAt},
+		{name: "zero", zeroCreatedAt: true, indexedAt: indexedAt, expectedAt: indexedAt, expectedFlag: FlagAncientCreatedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			createdAt := tt.createdAt.UnixMilli()
+			if tt.zeroCreatedAt {
+				createdAt = 0
+			}
+			effectiveAt, flag := EffectiveCreatedAt(createdAt, tt.indexedAt.UnixMilli())
+			if effectiveAt != tt.expectedAt.UnixMilli() {
+				t.Errorf("expected effective createdAt %s, got %s", tt.expectedAt, time.UnixMilli(effectiveAt).UTC())
+			}
+			if flag != tt.expectedFlag {
+				t.Errorf("expected flag %q, got %q", tt.expectedFlag, flag)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package statusphere
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+// cursorTracker keeps the consumer's cursor at the time of the latest event that has been
+// handled with none received before it still being handled. Events for different DIDs are
+// handled at the same time by the parallel scheduler and batched statuses are written later,
+// so the latest event handled isn't safe to resume from, as events before it may not have
+// been stored yet.
+type cursorTracker struct {
+	cursor *atomic.Int64
+
+	mu sync.Mutex
+	// events that are still being handled, in the order they were received
+	pending []*models.Event
+	// how many things are still being done with each pending event. An event is finished
+	// once it's been handled and, if its status was batched, the batch has been written.
+	refs map[*models.Event]int
+}
+
+func newCursorTracker(cursor *atomic.Int64) *cursorTracker {
+	return &cursorTracker{
+		cursor: cursor,
+		refs:   make(map[*models.Event]int),
+	}
+}
+
+// add records that something is being done with the event, which stops the cursor moving
+// past it until done is called for it.
+func (t *cursorTracker) add(event *models.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.refs[event]; !ok {
+		t.pending = append(t.pending, event)
+	}
+	t.refs[event]++
+}
+
+// done records that something being done with the event has finished, moving the cursor on
+// past the events that are now all finished.
+func (t *cursorTracker) done(event *models.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refs[event]--
+	if t.refs[event] > 0 {
+		return
+	}
+	for len(t.pending) > 0 && t.refs[t.pending[0]] == 0 {
+		finished := t.pending[0]
+		delete(t.refs, finished)
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+		t.advance(finished.TimeUS)
+	}
+}
+
+func (t *cursorTracker) advance(timeUS int64) {
+	for {
+		current := t.cursor.Load()
+		if timeUS <= current || t.cursor.CompareAndSwap(current, timeUS) {
+			return
+		}
+	}
+}
+
+// trackingScheduler starts tracking each event as it's received, before it's passed on to be
+// handled, so that the order they were received in is known.
+type trackingScheduler struct {
+	client.Scheduler
+	tracker *cursorTracker
+}
+
+func (s *trackingScheduler) AddWork(ctx context.Context, repo string, evt *models.Event) error {
+	s.tracker.add(evt)
+	return s.Scheduler.AddWork(ctx, repo, evt)
+}
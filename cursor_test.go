@@ -0,0 +1,54 @@
+package statusphere
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+func TestCursorTracker(t *testing.T) {
+	var cursor atomic.Int64
+	cursor.Store(50)
+	tracker := newCursorTracker(&cursor)
+
+	events := make([]*models.Event, 4)
+	for i := range events {
+		events[i] = &models.Event{Did: "did:plc:test", TimeUS: int64(100 * (i + 1))}
+		tracker.add(events[i])
+	}
+	// the second event's status is batched, so it's not finished when it's been handled
+	tracker.add(events[1])
+
+	expectCursor := func(expected int64) {
+		t.Helper()
+		if got := cursor.Load(); got != expected {
+			t.Fatalf("expected cursor %d, got %d", expected, got)
+		}
+	}
+
+	// events for other DIDs finish before the first one, so the cursor can't move past it
+	tracker.done(events[2])
+	tracker.done(events[3])
+	expectCursor(50)
+
+	tracker.done(events[0])
+	expectCursor(100)
+
+	// handled, but its batch hasn't been written yet
+	tracker.done(events[1])
+	expectCursor(100)
+
+	tracker.done(events[1])
+	expectCursor(400)
+	if len(tracker.pending) != 0 || len(tracker.refs) != 0 {
+		t.Fatalf("expected no events to be pending, got %d", len(tracker.pending))
+	}
+
+	// an event from before the cursor, such as one replayed after rewinding, doesn't move
+	// the cursor back
+	replayed := &models.Event{Did: "did:plc:test", TimeUS: 300}
+	tracker.add(replayed)
+	tracker.done(replayed)
+	expectCursor(400)
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/willdot/statusphere-go"
+)
+
+func createConsumerStatusTable(db *sql.DB) error {
+	createConsumerStatusTableSQL := `CREATE TABLE IF NOT EXISTS consumerstatus (
+		"name" TEXT NOT NULL PRIMARY KEY,
+		"endpoint" TEXT NOT NULL,
+		"cursor" integer NOT NULL,
+		"connected" integer NOT NULL,
+		"updatedAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create consumerstatus table...")
+	statement, err := db.Prepare(createConsumerStatusTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create consumerstatus table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create consumerstatus table: %w", err)
+	}
+	slog.Info("consumerstatus table created")
+
+	return nil
+}
+
+// SaveConsumerStatus stores the consumer's status, replacing the one it saved before.
+func (d *DB) SaveConsumerStatus(ctx context.Context, status statusphere.ConsumerStatus) error {
+	sql := `INSERT INTO consumerstatus (name, endpoint, cursor, connected, updatedAt) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET endpoint = excluded.endpoint, cursor = excluded.cursor, connected = excluded.connected, updatedAt = excluded.updatedAt;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, status.Name, status.Endpoint, status.Cursor, status.Connected, status.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("exec save consumer status: %w", err)
+	}
+	return nil
+}
+
+// GetConsumerStatuses returns the last status saved by each consumer, ordered by name.
+func (d *DB) GetConsumerStatuses(ctx context.Context) ([]statusphere.ConsumerStatus, error) {
+	sql := "SELECT name, endpoint, cursor, connected, updatedAt FROM consumerstatus ORDER BY name;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get consumer statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var statuses []statusphere.ConsumerStatus
+	for rows.Next() {
+		var s statusphere.ConsumerStatus
+		if err := rows.Scan(&s.Name, &s.Endpoint, &s.Cursor, &s.Connected, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
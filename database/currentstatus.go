@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// The currentstatus table has the URI of each user's current status, which is the one they
+// created most recently. It's worked out again from the user's statuses whenever one is
+// stored or deleted, so statuses arriving out of order and deletes are handled.
+func createCurrentStatusTable(db *sql.DB) error {
+	createCurrentStatusTableSQL := `CREATE TABLE IF NOT EXISTS currentstatus (
+		"did" TEXT NOT NULL PRIMARY KEY,
+		"uri" TEXT NOT NULL
+	  );`
+
+	slog.Info("Create currentstatus table...")
+	statement, err := db.Prepare(createCurrentStatusTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create currentstatus table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create currentstatus table: %w", err)
+	}
+	slog.Info("currentstatus table created")
+
+	return nil
+}
+
+// refreshCurrentStatus sets the user's current status to the status they created most
+// recently, going by when statuses are treated as having been created, or removes it if
+// they have no statuses left. Statuses created at the same time are ordered by URI, as
+// record keys are TIDs that sort by when they were made. Statuses hidden by the app's
+// moderators or by a label are skipped.
+func refreshCurrentStatus(ctx context.Context, tx *sql.Tx, did string) error {
+	sql := `INSERT INTO currentstatus (did, uri)
+		SELECT did, uri FROM status WHERE did = ? AND uri NOT IN (SELECT uri FROM hiddenstatuses) AND uri NOT IN (` + hiddenByLabelSQL + `)
+		ORDER BY effectiveAt DESC, uri DESC LIMIT 1
+		ON CONFLICT(did) DO UPDATE SET uri = excluded.uri;`
+	res, err := tx.ExecContext(ctx, sql, did)
+	if err != nil {
+		return fmt.Errorf("exec update current status: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM currentstatus WHERE did = ?;", did)
+	if err != nil {
+		return fmt.Errorf("exec delete current status: %w", err)
+	}
+	return nil
+}
+
+// GetCurrentStatuses returns each user's current status, most recently created first,
+// leaving out blocked accounts, along with the labels that apply to each.
+func (d *DB) GetCurrentStatuses(ctx context.Context, limit int) ([]statusphere.Status, error) {
+	sql := "SELECT " + statusColumns + " FROM status WHERE uri IN (SELECT uri FROM currentstatus) AND " + visibleStatusSQL + " ORDER BY effectiveAt desc LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get current statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var results []statusphere.Status
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachLabels(ctx, d.db, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -1,17 +1,27 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	_ "github.com/glebarez/go-sqlite"
+	"github.com/willdot/statusphere-go"
 )
 
+// defaultOperationTimeout is the longest a single query or statement is allowed
+// to run before it's aborted, regardless of the deadline of the caller.
+const defaultOperationTimeout = time.Second * 5
+
+const busyTimeout = time.Second * 5
+
 type DB struct {
-	db *sql.DB
+	db               *sql.DB
+	operationTimeout time.Duration
 }
 
 func New(dbPath string) (*DB, error) {
@@ -22,7 +32,14 @@ func New(dbPath string) (*DB, error) {
 		}
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	dsn := dbPath
+	if dbPath != ":memory:" {
+		// WAL lets reads carry on while the consumer is writing, and the busy timeout waits for
+		// locks to be released rather than failing straight away when writes happen at once
+		dsn += fmt.Sprintf("?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", busyTimeout.Milliseconds())
+	}
+
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
@@ -52,7 +69,78 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("creating profile table: %w", err)
 	}
 
-	return &DB{db: db}, nil
+	err = createDeadLettersTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating dead letters table: %w", err)
+	}
+
+	err = createPaletteTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating palette table: %w", err)
+	}
+
+	err = createCurrentStatusTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating current status table: %w", err)
+	}
+
+	err = createStatsTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating stats tables: %w", err)
+	}
+
+	err = createModerationTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating moderation tables: %w", err)
+	}
+
+	err = createLabelsTables(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating labels tables: %w", err)
+	}
+
+	err = createRateLimitsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating rate limits table: %w", err)
+	}
+
+	err = createLeasesTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating leases table: %w", err)
+	}
+
+	err = createConsumerStatusTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating consumer status table: %w", err)
+	}
+
+	err = createFollowGraphsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating follow graphs table: %w", err)
+	}
+
+	err = createFollowsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating follows table: %w", err)
+	}
+
+	err = createMigrationsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	err = applyMigrations(db)
+	if err != nil {
+		return nil, fmt.Errorf("applying migrations: %w", err)
+	}
+
+	return &DB{db: db, operationTimeout: defaultOperationTimeout}, nil
+}
+
+// withTimeout derives a context for a single database operation so that a slow
+// query is aborted if either the caller gives up or the operation timeout passes.
+func (d *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, d.operationTimeout)
 }
 
 func (d *DB) Close() {
@@ -74,3 +162,57 @@ func createDbFile(dbFilename string) error {
 	f.Close()
 	return nil
 }
+
+// Vacuum rebuilds the database file to reclaim the space left by deleted rows. It can take
+// a while on large databases so the operation timeout isn't applied.
+func (d *DB) Vacuum(ctx context.Context) error {
+	_, err := d.db.ExecContext(ctx, "VACUUM;")
+	if err != nil {
+		return fmt.Errorf("exec vacuum: %w", err)
+	}
+	return nil
+}
+
+// GetDatabaseStats returns the size of the database file and how many rows each table has.
+// Counting rows scans every table, so it can take a while on large databases.
+func (d *DB) GetDatabaseStats(ctx context.Context) (statusphere.DatabaseStats, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var stats statusphere.DatabaseStats
+	sql := "SELECT page_count * page_size, freelist_count * page_size FROM pragma_page_count(), pragma_page_size(), pragma_freelist_count();"
+	err := d.db.QueryRowContext(ctx, sql).Scan(&stats.SizeBytes, &stats.FreeBytes)
+	if err != nil {
+		return stats, fmt.Errorf("run query to get database size: %w", err)
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name;")
+	if err != nil {
+		return stats, fmt.Errorf("run query to get tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return stats, fmt.Errorf("scan row: %w", err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	for _, table := range tables {
+		count := statusphere.TableRowCount{Table: table}
+		// the table names come from sqlite_master rather than a user so they're safe to
+		// put in the query
+		err := d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+table+`";`).Scan(&count.Rows)
+		if err != nil {
+			return stats, fmt.Errorf("count rows in %s: %w", table, err)
+		}
+		stats.Tables = append(stats.Tables, count)
+	}
+	return stats, nil
+}
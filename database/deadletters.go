@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	statusphere "github.com/willdot/statusphere-go"
+)
+
+func createDeadLettersTable(db *sql.DB) error {
+	createDeadLettersTableSQL := `CREATE TABLE IF NOT EXISTS deadletters (
+		"id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"did" TEXT,
+		"rkey" TEXT,
+		"event" TEXT,
+		"error" TEXT,
+		"attempts" integer,
+		"retryable" integer,
+		"createdAt" integer,
+		"lastAttemptAt" integer,
+		"nextAttemptAt" integer
+	  );`
+
+	slog.Info("Create deadletters table...")
+	statement, err := db.Prepare(createDeadLettersTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create deadletters table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create deadletters table: %w", err)
+	}
+	slog.Info("deadletters table created")
+
+	return nil
+}
+
+const deadLetterColumns = "id, did, rkey, event, error, attempts, retryable, createdAt, lastAttemptAt, nextAttemptAt"
+
+func (d *DB) CreateDeadLetter(ctx context.Context, deadLetter statusphere.DeadLetter) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := `INSERT INTO deadletters (did, rkey, event, error, attempts, retryable, createdAt, lastAttemptAt, nextAttemptAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := d.db.ExecContext(ctx, sql, deadLetter.Did, deadLetter.RKey, string(deadLetter.Event), deadLetter.Error, deadLetter.Attempts, deadLetter.Retryable, deadLetter.CreatedAt, deadLetter.LastAttemptAt, deadLetter.NextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("exec insert dead letter: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeadLetters returns the most recent dead letters.
+func (d *DB) GetDeadLetters(ctx context.Context, limit int) ([]statusphere.DeadLetter, error) {
+	sql := "SELECT " + deadLetterColumns + " FROM deadletters ORDER BY id desc LIMIT ?;"
+	return d.queryDeadLetters(ctx, sql, limit)
+}
+
+// GetDueDeadLetters returns retryable dead letters whose next attempt is due by now.
+func (d *DB) GetDueDeadLetters(ctx context.Context, now int64, limit int) ([]statusphere.DeadLetter, error) {
+	sql := "SELECT " + deadLetterColumns + " FROM deadletters WHERE retryable = 1 AND nextAttemptAt <= ? ORDER BY nextAttemptAt LIMIT ?;"
+	return d.queryDeadLetters(ctx, sql, now, limit)
+}
+
+func (d *DB) GetDeadLetter(ctx context.Context, id int64) (statusphere.DeadLetter, error) {
+	sql := "SELECT " + deadLetterColumns + " FROM deadletters WHERE id = ?;"
+	deadLetters, err := d.queryDeadLetters(ctx, sql, id)
+	if err != nil {
+		return statusphere.DeadLetter{}, err
+	}
+	if len(deadLetters) == 0 {
+		return statusphere.DeadLetter{}, statusphere.ErrorNotFound
+	}
+	return deadLetters[0], nil
+}
+
+func (d *DB) UpdateDeadLetter(ctx context.Context, deadLetter statusphere.DeadLetter) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := `UPDATE deadletters SET error = ?, attempts = ?, retryable = ?, lastAttemptAt = ?, nextAttemptAt = ? WHERE id = ?;`
+	_, err := d.db.ExecContext(ctx, sql, deadLetter.Error, deadLetter.Attempts, deadLetter.Retryable, deadLetter.LastAttemptAt, deadLetter.NextAttemptAt, deadLetter.ID)
+	if err != nil {
+		return fmt.Errorf("exec update dead letter: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) DeleteDeadLetter(ctx context.Context, id int64) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := "DELETE FROM deadletters WHERE id = ?;"
+	_, err := d.db.ExecContext(ctx, sql, id)
+	if err != nil {
+		return fmt.Errorf("exec delete dead letter: %w", err)
+	}
+	return nil
+}
+
+// PurgeDeadLetters deletes all dead letters and returns how many were deleted.
+func (d *DB) PurgeDeadLetters(ctx context.Context) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := "DELETE FROM deadletters;"
+	res, err := d.db.ExecContext(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete dead letters: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteDeadLettersBefore deletes dead letters created before the given time in unix
+// milliseconds and returns how many were deleted.
+func (d *DB) DeleteDeadLettersBefore(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := "DELETE FROM deadletters WHERE createdAt < ?;"
+	res, err := d.db.ExecContext(ctx, sql, before)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete dead letters: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (d *DB) queryDeadLetters(ctx context.Context, sql string, args ...any) ([]statusphere.DeadLetter, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var results []statusphere.DeadLetter
+	for rows.Next() {
+		var deadLetter statusphere.DeadLetter
+		var event string
+		if err := rows.Scan(&deadLetter.ID, &deadLetter.Did, &deadLetter.RKey, &event, &deadLetter.Error, &deadLetter.Attempts, &deadLetter.Retryable, &deadLetter.CreatedAt, &deadLetter.LastAttemptAt, &deadLetter.NextAttemptAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		deadLetter.Event = []byte(event)
+		results = append(results, deadLetter)
+	}
+	return results, rows.Err()
+}
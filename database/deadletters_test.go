@@ -0,0 +1,94 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+func TestDeadLetters(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	deadLetters := []statusphere.DeadLetter{
+		{Did: "did:plc:alice", RKey: "3lsxb3n2bqs2a", Event: []byte(`{"did":"did:plc:alice"}`), Error: "invalid record", Attempts: 1, Retryable: false, NextAttemptAt: now.Add(-time.Minute).UnixMilli()},
+		{Did: "did:plc:bob", RKey: "3lsxb3n2bqs2b", Event: []byte(`{"did":"did:plc:bob"}`), Error: "database is locked", Attempts: 1, Retryable: true, NextAttemptAt: now.Add(-time.Minute).UnixMilli()},
+		{Did: "did:plc:carol", RKey: "3lsxb3n2bqs2c", Event: []byte(`{"did":"did:plc:carol"}`), Error: "database is locked", Attempts: 1, Retryable: true, NextAttemptAt: now.Add(time.Minute).UnixMilli()},
+	}
+	for _, deadLetter := range deadLetters {
+		deadLetter.CreatedAt = now.UnixMilli()
+		deadLetter.LastAttemptAt = now.UnixMilli()
+		if err := db.CreateDeadLetter(ctx, deadLetter); err != nil {
+			t.Fatalf("create dead letter: %s", err)
+		}
+	}
+
+	listed, err := db.GetDeadLetters(ctx, 2)
+	if err != nil {
+		t.Fatalf("get dead letters: %s", err)
+	}
+	if len(listed) != 2 || listed[0].Did != "did:plc:carol" || listed[1].Did != "did:plc:bob" {
+		t.Fatalf("expected the two most recent dead letters, got %+v", listed)
+	}
+
+	got, err := db.GetDeadLetter(ctx, listed[1].ID)
+	if err != nil {
+		t.Fatalf("get dead letter: %s", err)
+	}
+	if got.Did != "did:plc:bob" || got.RKey != "3lsxb3n2bqs2b" || string(got.Event) != `{"did":"did:plc:bob"}` || !got.Retryable {
+		t.Fatalf("expected the dead letter as it was stored, got %+v", got)
+	}
+	if _, err := db.GetDeadLetter(ctx, 1000); !errors.Is(err, statusphere.ErrorNotFound) {
+		t.Fatalf("expected a dead letter that doesn't exist to be not found, got %v", err)
+	}
+
+	// only bob's is retryable and due
+	due, err := db.GetDueDeadLetters(ctx, now.UnixMilli(), 10)
+	if err != nil {
+		t.Fatalf("get due dead letters: %s", err)
+	}
+	if len(due) != 1 || due[0].Did != "did:plc:bob" {
+		t.Fatalf("expected only the retryable dead letter that's due, got %+v", due)
+	}
+
+	got.Attempts = 2
+	got.Error = "still locked"
+	got.Retryable = false
+	got.NextAttemptAt = now.Add(time.Hour).UnixMilli()
+	if err := db.UpdateDeadLetter(ctx, got); err != nil {
+		t.Fatalf("update dead letter: %s", err)
+	}
+	updated, err := db.GetDeadLetter(ctx, got.ID)
+	if err != nil {
+		t.Fatalf("get dead letter: %s", err)
+	}
+	if updated.Attempts != 2 || updated.Error != "still locked" || updated.Retryable || updated.NextAttemptAt != got.NextAttemptAt {
+		t.Fatalf("expected the dead letter to be updated, got %+v", updated)
+	}
+
+	if err := db.DeleteDeadLetter(ctx, got.ID); err != nil {
+		t.Fatalf("delete dead letter: %s", err)
+	}
+	if _, err := db.GetDeadLetter(ctx, got.ID); !errors.Is(err, statusphere.ErrorNotFound) {
+		t.Fatalf("expected the deleted dead letter to be not found, got %v", err)
+	}
+
+	purged, err := db.PurgeDeadLetters(ctx)
+	if err != nil {
+		t.Fatalf("purge dead letters: %s", err)
+	}
+	if purged != 2 {
+		t.Fatalf("expected 2 dead letters to be purged, got %d", purged)
+	}
+	listed, err = db.GetDeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatalf("get dead letters: %s", err)
+	}
+	if len(listed) != 0 {
+		t.Fatalf("expected no dead letters after purging, got %d", len(listed))
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// filterSQL builds a WHERE clause, and its arguments, that matches rows whose didColumn is
+// one of the filter's DIDs and whose timeColumn is within its time range. Either column can
+// be empty to not filter on it. If nothing is filtered on the clause is empty.
+func filterSQL(filter statusphere.ExportFilter, didColumn, timeColumn string) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if didColumn != "" && len(filter.Dids) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(filter.Dids)), ", ")
+		conditions = append(conditions, didColumn+" IN ("+placeholders+")")
+		for _, did := range filter.Dids {
+			args = append(args, did)
+		}
+	}
+	if timeColumn != "" && filter.Since > 0 {
+		conditions = append(conditions, timeColumn+" >= ?")
+		args = append(args, filter.Since)
+	}
+	if timeColumn != "" && filter.Until > 0 {
+		conditions = append(conditions, timeColumn+" < ?")
+		args = append(args, filter.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
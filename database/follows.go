@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// followsInsertBatchSize is how many follows are inserted per statement, keeping the number
+// of parameters well under SQLite's limit.
+const followsInsertBatchSize = 500
+
+func createFollowGraphsTable(db *sql.DB) error {
+	createFollowGraphsTableSQL := `CREATE TABLE IF NOT EXISTS followgraphs (
+		"did" TEXT NOT NULL PRIMARY KEY,
+		"refreshedAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create followgraphs table...")
+	statement, err := db.Prepare(createFollowGraphsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create followgraphs table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create followgraphs table: %w", err)
+	}
+	slog.Info("followgraphs table created")
+
+	return nil
+}
+
+func createFollowsTable(db *sql.DB) error {
+	createFollowsTableSQL := `CREATE TABLE IF NOT EXISTS follows (
+		"did" TEXT NOT NULL,
+		"subject" TEXT NOT NULL,
+		PRIMARY KEY (did, subject)
+	  );`
+
+	slog.Info("Create follows table...")
+	statement, err := db.Prepare(createFollowsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create follows table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create follows table: %w", err)
+	}
+	slog.Info("follows table created")
+
+	return nil
+}
+
+// GetFollowsRefreshedAt returns when the accounts the user follows were last saved, in unix
+// milliseconds, or statusphere.ErrorNotFound if they never have been.
+func (d *DB) GetFollowsRefreshedAt(ctx context.Context, did string) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var refreshedAt int64
+	err := d.db.QueryRowContext(ctx, "SELECT refreshedAt FROM followgraphs WHERE did = ?;", did).Scan(&refreshedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, statusphere.ErrorNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("run query to get follows refreshed at: %w", err)
+	}
+	return refreshedAt, nil
+}
+
+// SaveFollows replaces the accounts the user follows, inserting them in batches.
+func (d *DB) SaveFollows(ctx context.Context, did string, subjects []string, refreshedAt int64) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM follows WHERE did = ?;", did)
+	if err != nil {
+		return fmt.Errorf("exec delete follows: %w", err)
+	}
+	for batch := range slices.Chunk(subjects, followsInsertBatchSize) {
+		args := make([]any, 0, len(batch)*2)
+		for _, subject := range batch {
+			args = append(args, did, subject)
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(batch)), ", ")
+		_, err = tx.ExecContext(ctx, "INSERT INTO follows (did, subject) VALUES "+values+" ON CONFLICT DO NOTHING;", args...)
+		if err != nil {
+			return fmt.Errorf("exec insert follows: %w", err)
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO followgraphs (did, refreshedAt) VALUES (?, ?)
+		ON CONFLICT(did) DO UPDATE SET refreshedAt = excluded.refreshedAt;`, did, refreshedAt)
+	if err != nil {
+		return fmt.Errorf("exec save follow graph: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// GetFollowingStatuses returns the statuses of the accounts the user follows, and the user's
+// own, most recently created first, leaving out blocked accounts, along with the labels
+// that apply to each.
+func (d *DB) GetFollowingStatuses(ctx context.Context, did string, limit int) ([]statusphere.Status, error) {
+	sql := "SELECT " + statusColumns + " FROM status WHERE (did = ? OR did IN (SELECT subject FROM follows WHERE did = ?)) AND " + visibleStatusSQL + " ORDER BY effectiveAt desc LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, did, did, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get following statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var results []statusphere.Status
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, status)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachLabels(ctx, d.db, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// DeleteFollowGraphsBefore deletes the follows of users whose follows haven't been refreshed
+// since before the time, in unix milliseconds, as they've stopped using the following feed.
+// It returns how many users' follows were deleted.
+func (d *DB) DeleteFollowGraphsBefore(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM follows WHERE did IN (SELECT did FROM followgraphs WHERE refreshedAt < ?);", before)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete follows: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM followgraphs WHERE refreshedAt < ?;", before)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete follow graphs: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("get deleted follow graphs: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestSaveFollows saves more follows than are inserted in one batch, including a duplicate,
+// then replaces them, and checks each is stored once.
+func TestSaveFollows(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	var subjects []string
+	for i := range followsInsertBatchSize*2 + 1 {
+		subjects = append(subjects, fmt.Sprintf("did:plc:followed%d", i))
+	}
+	subjects = append(subjects, subjects[0])
+
+	countFollows := func() int {
+		t.Helper()
+		var n int
+		if err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM follows WHERE did = ?;", "did:plc:alice").Scan(&n); err != nil {
+			t.Fatalf("count follows: %s", err)
+		}
+		return n
+	}
+
+	if err := db.SaveFollows(ctx, "did:plc:alice", subjects, time.Now().UnixMilli()); err != nil {
+		t.Fatalf("save follows: %s", err)
+	}
+	if n := countFollows(); n != followsInsertBatchSize*2+1 {
+		t.Fatalf("expected %d follows, got %d", followsInsertBatchSize*2+1, n)
+	}
+
+	if err := db.SaveFollows(ctx, "did:plc:alice", subjects[:3], time.Now().UnixMilli()); err != nil {
+		t.Fatalf("save follows: %s", err)
+	}
+	if n := countFollows(); n != 3 {
+		t.Fatalf("expected the follows to be replaced, got %d", n)
+	}
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// activeLabelSQL is the condition for a label to apply, which is that it hasn't expired.
+const activeLabelSQL = "(exp IS NULL OR exp > unixepoch() * 1000)"
+
+// hiddenByLabelSQL selects the DIDs of accounts and URIs of statuses that have a label that
+// hides them.
+var hiddenByLabelSQL = "SELECT uri FROM labels WHERE val IN (" + quoteSQLStrings(statusphere.HiddenLabelValues()) + ") AND " + activeLabelSQL
+
+func quoteSQLStrings(vals []string) string {
+	quoted := make([]string, 0, len(vals))
+	for _, val := range vals {
+		quoted = append(quoted, "'"+strings.ReplaceAll(val, "'", "''")+"'")
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// The labels table has the labels that labelers have applied to accounts, where uri is the
+// account's DID, and statuses. Each labeler can only apply a value to a subject once, so a
+// newer label replaces an older one. The labelercursors table has how far through each
+// labeler's stream the app has got.
+func createLabelsTables(db *sql.DB) error {
+	createLabelsTableSQL := `CREATE TABLE IF NOT EXISTS labels (
+		"src" TEXT NOT NULL,
+		"uri" TEXT NOT NULL,
+		"val" TEXT NOT NULL,
+		"cid" TEXT,
+		"cts" integer NOT NULL,
+		"exp" integer,
+		PRIMARY KEY (src, uri, val)
+	  );`
+
+	slog.Info("Create labels table...")
+	statement, err := db.Prepare(createLabelsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create labels table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create labels table: %w", err)
+	}
+	slog.Info("labels table created")
+
+	createLabelerCursorsTableSQL := `CREATE TABLE IF NOT EXISTS labelercursors (
+		"labeler" TEXT NOT NULL PRIMARY KEY,
+		"cursor" integer NOT NULL
+	  );`
+
+	slog.Info("Create labelercursors table...")
+	statement, err = db.Prepare(createLabelerCursorsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create labelercursors table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create labelercursors table: %w", err)
+	}
+	slog.Info("labelercursors table created")
+
+	return nil
+}
+
+// ApplyLabels stores the labels and saves the labeler's cursor in a single transaction. A
+// negation label removes the label it negates. Labels older than the stored label with the
+// same value are ignored, as are labels for accounts that haven't created any statuses,
+// which labelers such as Bluesky's label far more of. If a label is for a status, its
+// user's current status is worked out again in case the label hides it.
+func (d *DB) ApplyLabels(ctx context.Context, labeler string, cursor int64, labels []statusphere.Label) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, label := range labels {
+		if label.Neg {
+			_, err = tx.ExecContext(ctx, "DELETE FROM labels WHERE src = ? AND uri = ? AND val = ? AND cts <= ?;", label.Src, label.URI, label.Val, label.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("exec delete label: %w", err)
+			}
+		} else {
+			if strings.HasPrefix(label.URI, "did:") {
+				hasStatuses, err := didHasStatuses(ctx, tx, label.URI)
+				if err != nil {
+					return err
+				}
+				if !hasStatuses {
+					continue
+				}
+			}
+
+			sql := `INSERT INTO labels (src, uri, val, cid, cts, exp) VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, 0))
+				ON CONFLICT(src, uri, val) DO UPDATE SET cid = excluded.cid, cts = excluded.cts, exp = excluded.exp WHERE excluded.cts >= labels.cts;`
+			_, err = tx.ExecContext(ctx, sql, label.Src, label.URI, label.Val, label.CID, label.CreatedAt, label.ExpiresAt)
+			if err != nil {
+				return fmt.Errorf("exec insert label: %w", err)
+			}
+		}
+
+		if strings.HasPrefix(label.URI, "at://") {
+			if err := refreshCurrentStatusOf(ctx, tx, label.URI); err != nil {
+				return err
+			}
+		}
+	}
+
+	sql := `INSERT INTO labelercursors (labeler, cursor) VALUES (?, ?)
+		ON CONFLICT(labeler) DO UPDATE SET cursor = excluded.cursor;`
+	_, err = tx.ExecContext(ctx, sql, labeler, cursor)
+	if err != nil {
+		return fmt.Errorf("exec save labeler cursor: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetLabelerCursor returns how far through the labeler's stream the app has got, or 0 if
+// it hasn't consumed from the labeler before.
+func (d *DB) GetLabelerCursor(ctx context.Context, labeler string) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var cursor int64
+	err := d.db.QueryRowContext(ctx, "SELECT cursor FROM labelercursors WHERE labeler = ?;", labeler).Scan(&cursor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("run query to get labeler cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+func didHasStatuses(ctx context.Context, tx *sql.Tx, did string) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM status WHERE did = ?);", did).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check account has statuses: %w", err)
+	}
+	return exists, nil
+}
+
+// DeleteExpiredLabels deletes labels that have expired and returns how many were deleted.
+// The current status of each user with a status whose label expired is worked out again,
+// in case the label was hiding it.
+func (d *DB) DeleteExpiredLabels(ctx context.Context) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "DELETE FROM labels WHERE NOT "+activeLabelSQL+" RETURNING uri;")
+	if err != nil {
+		return 0, fmt.Errorf("exec delete expired labels: %w", err)
+	}
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan row: %w", err)
+		}
+		uris = append(uris, uri)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, uri := range uris {
+		if strings.HasPrefix(uri, "at://") {
+			if err := refreshCurrentStatusOf(ctx, tx, uri); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("commit transaction: %w", err)
+	}
+	return int64(len(uris)), nil
+}
+
+// attachLabels sets the values of the labels that apply to each status, either directly or
+// to its account.
+func attachLabels(ctx context.Context, db *sql.DB, statuses []statusphere.Status) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	subjects := make([]any, 0, len(statuses)*2)
+	for _, status := range statuses {
+		subjects = append(subjects, status.URI, status.Did)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(subjects)), ", ")
+	sql := "SELECT DISTINCT uri, val FROM labels WHERE uri IN (" + placeholders + ") AND " + activeLabelSQL + ";"
+	rows, err := db.QueryContext(ctx, sql, subjects...)
+	if err != nil {
+		return fmt.Errorf("run query to get labels: %w", err)
+	}
+	defer rows.Close()
+
+	vals := make(map[string][]string)
+	for rows.Next() {
+		var uri, val string
+		if err := rows.Scan(&uri, &val); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+		vals[uri] = append(vals[uri], val)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, status := range statuses {
+		labels := append(slices.Clone(vals[status.URI]), vals[status.Did]...)
+		slices.Sort(labels)
+		statuses[i].Labels = slices.Compact(labels)
+	}
+	return nil
+}
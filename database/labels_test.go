@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// TestApplyLabelsIgnoresAccountsWithoutStatuses labels two accounts, only one of which has
+// created a status, and checks only that account's label is stored.
+func TestApplyLabelsIgnoresAccountsWithoutStatuses(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	status := newTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", time.Now())
+	if err := db.CreateStatus(ctx, status); err != nil {
+		t.Fatalf("store status: %s", err)
+	}
+
+	labels := []statusphere.Label{
+		{Src: "did:plc:labeler", URI: "did:plc:alice", Val: "!hide", CreatedAt: time.Now().UnixMilli()},
+		{Src: "did:plc:labeler", URI: "did:plc:bob", Val: "!hide", CreatedAt: time.Now().UnixMilli()},
+	}
+	if err := db.ApplyLabels(ctx, "did:plc:labeler", 1, labels); err != nil {
+		t.Fatalf("apply labels: %s", err)
+	}
+
+	var uris []string
+	rows, err := db.db.QueryContext(ctx, "SELECT uri FROM labels;")
+	if err != nil {
+		t.Fatalf("get labels: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			t.Fatalf("scan label: %s", err)
+		}
+		uris = append(uris, uri)
+	}
+	if len(uris) != 1 || uris[0] != "did:plc:alice" {
+		t.Fatalf("expected only the label for the account with a status to be stored, got %v", uris)
+	}
+}
+
+// TestDeleteExpiredLabels hides a user's latest status with a label that then expires, and
+// checks it becomes their current status again once expired labels are deleted.
+func TestDeleteExpiredLabels(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	createdAt := time.Now()
+	previous := newTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", createdAt.Add(-time.Minute))
+	latest := newTestStatus("did:plc:alice", "3lsxb3n2bqs2b", "🤔", createdAt)
+	for _, status := range []statusphere.Status{previous, latest} {
+		if err := db.CreateStatus(ctx, status); err != nil {
+			t.Fatalf("store status: %s", err)
+		}
+	}
+
+	label := statusphere.Label{
+		Src:       "did:plc:labeler",
+		URI:       latest.URI,
+		Val:       "!hide",
+		CreatedAt: createdAt.UnixMilli(),
+		ExpiresAt: createdAt.Add(time.Hour).UnixMilli(),
+	}
+	if err := db.ApplyLabels(ctx, "did:plc:labeler", 1, []statusphere.Label{label}); err != nil {
+		t.Fatalf("apply labels: %s", err)
+	}
+	expectCurrentStatus(t, db, previous.URI)
+
+	// expire the label without waiting an hour
+	if _, err := db.db.ExecContext(ctx, "UPDATE labels SET exp = ?;", createdAt.Add(-time.Second).UnixMilli()); err != nil {
+		t.Fatalf("expire label: %s", err)
+	}
+	deleted, err := db.DeleteExpiredLabels(ctx)
+	if err != nil {
+		t.Fatalf("delete expired labels: %s", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 expired label to be deleted, got %d", deleted)
+	}
+	expectCurrentStatus(t, db, latest.URI)
+}
+
+func expectCurrentStatus(t *testing.T, db *DB, uri string) {
+	t.Helper()
+
+	current, err := db.GetCurrentStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get current statuses: %s", err)
+	}
+	if len(current) != 1 || current[0].URI != uri {
+		t.Fatalf("expected the current status to be %s, got %+v", uri, current)
+	}
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/willdot/statusphere-go"
+)
+
+func createLeasesTable(db *sql.DB) error {
+	createLeasesTableSQL := `CREATE TABLE IF NOT EXISTS leases (
+		"name" TEXT NOT NULL PRIMARY KEY,
+		"holder" TEXT,
+		"expiresAt" integer
+	  );`
+
+	slog.Info("Create leases table...")
+	statement, err := db.Prepare(createLeasesTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create leases table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create leases table: %w", err)
+	}
+	slog.Info("leases table created")
+
+	return nil
+}
+
+// AcquireLease takes the named lease for the holder until expiresAt, in unix milliseconds,
+// and reports whether it was taken. The lease is only taken if nobody holds it, the holder
+// already holds it, in which case it's extended, or the previous holder's lease expired
+// before now.
+func (d *DB) AcquireLease(ctx context.Context, name, holder string, now, expiresAt int64) (bool, error) {
+	sql := `INSERT INTO leases (name, holder, expiresAt) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expiresAt = excluded.expiresAt
+		WHERE leases.holder = excluded.holder OR leases.expiresAt < ?;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	res, err := d.db.ExecContext(ctx, sql, name, holder, expiresAt, now)
+	if err != nil {
+		return false, fmt.Errorf("exec acquire lease: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ReleaseLease gives up the named lease if the holder holds it, so that another holder can
+// take it straight away rather than waiting for it to expire.
+func (d *DB) ReleaseLease(ctx context.Context, name, holder string) error {
+	sql := "DELETE FROM leases WHERE name = ? AND holder = ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, name, holder)
+	if err != nil {
+		return fmt.Errorf("exec delete lease: %w", err)
+	}
+	return nil
+}
+
+// GetLeases returns the leases that are held, ordered by name.
+func (d *DB) GetLeases(ctx context.Context) ([]statusphere.Lease, error) {
+	sql := "SELECT name, COALESCE(holder, ''), COALESCE(expiresAt, 0) FROM leases ORDER BY name;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get leases: %w", err)
+	}
+	defer rows.Close()
+
+	var leases []statusphere.Lease
+	for rows.Next() {
+		var l statusphere.Lease
+		if err := rows.Scan(&l.Name, &l.Holder, &l.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations change tables that already exist. Each table's create function creates it as
+// it was when the table was first added, and every later change to it, such as a new column
+// or index, is made by a migration, which a new database runs too. Tables added later are
+// created in full by their create functions. Migrations are applied in order and must never
+// be edited or removed once released.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "add createdAt to oauthrequests",
+		sql:     `ALTER TABLE oauthrequests ADD COLUMN "createdAt" integer;`,
+	},
+	{
+		version: 2,
+		name:    "add createdAt to oauthsessions",
+		sql:     `ALTER TABLE oauthsessions ADD COLUMN "createdAt" integer;`,
+	},
+	{
+		version: 3,
+		name:    "add cid to status",
+		sql:     `ALTER TABLE status ADD COLUMN "cid" TEXT;`,
+	},
+	{
+		version: 4,
+		name:    "add rev to status",
+		sql:     `ALTER TABLE status ADD COLUMN "rev" TEXT;`,
+	},
+	{
+		version: 5,
+		name:    "add divergedCid to status",
+		sql:     `ALTER TABLE status ADD COLUMN "divergedCid" TEXT;`,
+	},
+	{
+		// the rollups are kept up to date as statuses are stored, so only statuses stored
+		// before they existed need to be counted
+		version: 6,
+		name:    "populate stats rollups",
+		sql: `INSERT INTO statusdailycounts (day, status, count)
+			SELECT date(createdAt / 1000, 'unixepoch'), status, COUNT(*) FROM status GROUP BY 1, 2;
+			INSERT INTO statusdailyusers (day, did, count)
+			SELECT date(createdAt / 1000, 'unixepoch'), did, COUNT(*) FROM status GROUP BY 1, 2;`,
+	},
+	{
+		// the index is used to find each user's current status as their statuses are stored
+		version: 7,
+		name:    "populate current statuses",
+		sql: `CREATE INDEX IF NOT EXISTS statusdidcreatedat ON status (did, createdAt);
+			INSERT INTO currentstatus (did, uri)
+			SELECT did, uri FROM (
+				SELECT did, uri, ROW_NUMBER() OVER (PARTITION BY did ORDER BY createdAt DESC, uri DESC) AS n FROM status
+			) WHERE n = 1;`,
+	},
+	{
+		// statuses are flagged if createdAt is more than 10 minutes after indexedAt or before
+		// 2023-01-01, which must match statusphere.EffectiveCreatedAt at the time of this
+		// migration. The current statuses and stats rollups are then rebuilt to use effectiveAt.
+		version: 8,
+		name:    "add effectiveAt and flag to status",
+		sql: `ALTER TABLE status ADD COLUMN "effectiveAt" integer;
+			ALTER TABLE status ADD COLUMN "flag" TEXT;
+			UPDATE status SET
+				effectiveAt = CASE WHEN createdAt > indexedAt + 600000 OR createdAt < 1672531200000 THEN indexedAt ELSE createdAt END,
+				flag = CASE WHEN createdAt > indexedAt + 600000 THEN 'future createdAt' WHEN createdAt < 1672531200000 THEN 'implausibly old createdAt' END;
+			DROP INDEX IF EXISTS statusdidcreatedat;
+			CREATE INDEX statusdideffectiveat ON status (did, effectiveAt);
+			CREATE INDEX statuseffectiveat ON status (effectiveAt);
+			DELETE FROM currentstatus;
+			INSERT INTO currentstatus (did, uri)
+			SELECT did, uri FROM (
+				SELECT did, uri, ROW_NUMBER() OVER (PARTITION BY did ORDER BY effectiveAt DESC, uri DESC) AS n FROM status
+			) WHERE n = 1;
+			DELETE FROM statusdailycounts;
+			INSERT INTO statusdailycounts (day, status, count)
+			SELECT date(effectiveAt / 1000, 'unixepoch'), status, COUNT(*) FROM status GROUP BY 1, 2;
+			DELETE FROM statusdailyusers;
+			INSERT INTO statusdailyusers (day, did, count)
+			SELECT date(effectiveAt / 1000, 'unixepoch'), did, COUNT(*) FROM status GROUP BY 1, 2;`,
+	},
+	{
+		version: 9,
+		name:    "add uri index to labels",
+		sql:     `CREATE INDEX IF NOT EXISTS labelsuri ON labels (uri);`,
+	},
+	{
+		version: 10,
+		name:    "add subject index to moderationactions",
+		sql:     `CREATE INDEX IF NOT EXISTS moderationactionssubject ON moderationactions (subject, id);`,
+	},
+	{
+		// SQLite can't drop a unique constraint, so the table is rebuilt to allow an account
+		// to be logged in from more than one browser at once
+		version: 11,
+		name:    "allow several oauth sessions per account",
+		sql: `CREATE TABLE oauthsessionsrebuilt (
+				"id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"accountDID" TEXT,
+				"sessionID" TEXT,
+				"hostURL" TEXT,
+				"authServerURL" TEXT,
+				"authServerTokenEndpoint" TEXT,
+				"scopes" TEXT,
+				"accessToken" TEXT,
+				"refreshToken" TEXT,
+				"dpopAuthServerNonce" TEXT,
+				"dpopHostNonce" TEXT,
+				"dpopPrivateKeyMultibase" TEXT,
+				"createdAt" integer,
+				UNIQUE(accountDID, sessionID)
+			);
+			INSERT INTO oauthsessionsrebuilt SELECT id, accountDID, sessionID, hostURL, authServerURL, authServerTokenEndpoint, scopes, accessToken, refreshToken, dpopAuthServerNonce, dpopHostNonce, dpopPrivateKeyMultibase, createdAt FROM oauthsessions;
+			DROP TABLE oauthsessions;
+			ALTER TABLE oauthsessionsrebuilt RENAME TO oauthsessions;`,
+	},
+}
+
+// AppliedMigration is a migration that has been applied to the database.
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt int64
+}
+
+func createMigrationsTable(db *sql.DB) error {
+	createMigrationsTableSQL := `CREATE TABLE IF NOT EXISTS migrations (
+		"version" integer NOT NULL PRIMARY KEY,
+		"name" TEXT,
+		"appliedAt" integer
+	  );`
+
+	slog.Info("Create migrations table...")
+	statement, err := db.Prepare(createMigrationsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create migrations table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create migrations table: %w", err)
+	}
+	slog.Info("migrations table created")
+
+	return nil
+}
+
+// applyMigrations applies any migrations that haven't been applied yet, each in its own
+// transaction so that a failed migration leaves the database at the previous version.
+func applyMigrations(db *sql.DB) error {
+	var current int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM migrations;").Scan(&current)
+	if err != nil {
+		return fmt.Errorf("get schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		slog.Info("Apply migration...", "version", m.version, "name", m.name)
+		err := applyMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("apply migration %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("migration applied", "version", m.version)
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(m.sql)
+	if err != nil {
+		return fmt.Errorf("exec migration: %w", err)
+	}
+	_, err = tx.Exec("INSERT INTO migrations (version, name, appliedAt) VALUES (?, ?, ?);", m.version, m.name, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("record migration: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetAppliedMigrations returns the migrations that have been applied, oldest first.
+func (d *DB) GetAppliedMigrations(ctx context.Context) ([]AppliedMigration, error) {
+	sql := "SELECT version, name, appliedAt FROM migrations ORDER BY version;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get migrations: %w", err)
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// LatestMigrationVersion is the schema version the database is migrated to when opened.
+func LatestMigrationVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
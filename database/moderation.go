@@ -0,0 +1,349 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// visibleStatusSQL is the condition for a status to be shown, which is that it isn't hidden
+// and its account isn't blocked, either by the app's moderators or by a labeler.
+var visibleStatusSQL = "did NOT IN (SELECT did FROM blockeddids) AND uri NOT IN (SELECT uri FROM hiddenstatuses)" +
+	" AND did NOT IN (" + hiddenByLabelSQL + ") AND uri NOT IN (" + hiddenByLabelSQL + ")"
+
+// hiddenStatusSQL is the condition for a status not to be shown, the opposite of
+// visibleStatusSQL, written so that the statuses are looked up from the moderation tables
+// rather than every status being checked.
+var hiddenStatusSQL = "(did IN (SELECT did FROM blockeddids) OR uri IN (SELECT uri FROM hiddenstatuses)" +
+	" OR did IN (" + hiddenByLabelSQL + ") OR uri IN (" + hiddenByLabelSQL + "))"
+
+func createModerationTables(db *sql.DB) error {
+	createBlockedDIDsTableSQL := `CREATE TABLE IF NOT EXISTS blockeddids (
+		"did" TEXT NOT NULL PRIMARY KEY,
+		"reason" TEXT NOT NULL,
+		"createdAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create blockeddids table...")
+	statement, err := db.Prepare(createBlockedDIDsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create blockeddids table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create blockeddids table: %w", err)
+	}
+	slog.Info("blockeddids table created")
+
+	createHiddenStatusesTableSQL := `CREATE TABLE IF NOT EXISTS hiddenstatuses (
+		"uri" TEXT NOT NULL PRIMARY KEY,
+		"reason" TEXT NOT NULL,
+		"createdAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create hiddenstatuses table...")
+	statement, err = db.Prepare(createHiddenStatusesTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create hiddenstatuses table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create hiddenstatuses table: %w", err)
+	}
+	slog.Info("hiddenstatuses table created")
+
+	createModerationActionsTableSQL := `CREATE TABLE IF NOT EXISTS moderationactions (
+		"id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"action" TEXT NOT NULL,
+		"subject" TEXT NOT NULL,
+		"reason" TEXT NOT NULL,
+		"actor" TEXT NOT NULL,
+		"createdAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create moderationactions table...")
+	statement, err = db.Prepare(createModerationActionsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create moderationactions table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create moderationactions table: %w", err)
+	}
+	slog.Info("moderationactions table created")
+
+	return nil
+}
+
+// BlockDID blocks an account, so that its statuses are no longer stored or shown, or updates
+// the reason if it's already blocked. Statuses that are already stored are kept so that
+// unblocking the account shows them again.
+func (d *DB) BlockDID(ctx context.Context, did, reason, actor string) error {
+	return d.moderate(ctx, statusphere.ModerationActionBlock, did, reason, actor, func(ctx context.Context, tx *sql.Tx, now int64) (bool, error) {
+		sql := `INSERT INTO blockeddids (did, reason, createdAt) VALUES (?, ?, ?)
+			ON CONFLICT(did) DO UPDATE SET reason = excluded.reason;`
+		_, err := tx.ExecContext(ctx, sql, did, reason, now)
+		if err != nil {
+			return false, fmt.Errorf("exec insert blocked DID: %w", err)
+		}
+		return true, nil
+	})
+}
+
+// UnblockDID unblocks an account, returning statusphere.ErrorNotFound if it isn't blocked.
+// Statuses created while it was blocked aren't stored, so they need to be backfilled.
+func (d *DB) UnblockDID(ctx context.Context, did, actor string) error {
+	return d.moderate(ctx, statusphere.ModerationActionUnblock, did, "", actor, func(ctx context.Context, tx *sql.Tx, _ int64) (bool, error) {
+		res, err := tx.ExecContext(ctx, "DELETE FROM blockeddids WHERE did = ?;", did)
+		if err != nil {
+			return false, fmt.Errorf("exec delete blocked DID: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, fmt.Errorf("get rows affected: %w", err)
+		}
+		return n > 0, nil
+	})
+}
+
+// HideStatus hides a status, or updates the reason if it's already hidden. The status can be
+// hidden before it's stored. If it's its user's current status, the one they created before
+// it becomes their current status instead.
+func (d *DB) HideStatus(ctx context.Context, uri, reason, actor string) error {
+	return d.moderate(ctx, statusphere.ModerationActionHide, uri, reason, actor, func(ctx context.Context, tx *sql.Tx, now int64) (bool, error) {
+		sql := `INSERT INTO hiddenstatuses (uri, reason, createdAt) VALUES (?, ?, ?)
+			ON CONFLICT(uri) DO UPDATE SET reason = excluded.reason;`
+		_, err := tx.ExecContext(ctx, sql, uri, reason, now)
+		if err != nil {
+			return false, fmt.Errorf("exec insert hidden status: %w", err)
+		}
+		return true, refreshCurrentStatusOf(ctx, tx, uri)
+	})
+}
+
+// UnhideStatus shows a hidden status again, returning statusphere.ErrorNotFound if it isn't
+// hidden.
+func (d *DB) UnhideStatus(ctx context.Context, uri, actor string) error {
+	return d.moderate(ctx, statusphere.ModerationActionUnhide, uri, "", actor, func(ctx context.Context, tx *sql.Tx, _ int64) (bool, error) {
+		res, err := tx.ExecContext(ctx, "DELETE FROM hiddenstatuses WHERE uri = ?;", uri)
+		if err != nil {
+			return false, fmt.Errorf("exec delete hidden status: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return false, fmt.Errorf("get rows affected: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+		return true, refreshCurrentStatusOf(ctx, tx, uri)
+	})
+}
+
+// moderate makes a moderation change and records it in the audit log in a single
+// transaction. The change reports whether there was anything to change, and if there
+// wasn't statusphere.ErrorNotFound is returned.
+func (d *DB) moderate(ctx context.Context, action, subject, reason, actor string, change func(ctx context.Context, tx *sql.Tx, now int64) (bool, error)) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	changed, err := change(ctx, tx, now)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return statusphere.ErrorNotFound
+	}
+
+	sql := "INSERT INTO moderationactions (action, subject, reason, actor, createdAt) VALUES (?, ?, ?, ?, ?);"
+	_, err = tx.ExecContext(ctx, sql, action, subject, reason, actor, now)
+	if err != nil {
+		return fmt.Errorf("exec insert moderation action: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// refreshCurrentStatusOf refreshes the current status of the user who created the status,
+// if it's stored.
+func refreshCurrentStatusOf(ctx context.Context, tx *sql.Tx, uri string) error {
+	var did string
+	err := tx.QueryRowContext(ctx, "SELECT did FROM status WHERE uri = ?;", uri).Scan(&did)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get status DID: %w", err)
+	}
+	return refreshCurrentStatus(ctx, tx, did)
+}
+
+// IsDIDBlocked reports whether the account is blocked.
+func (d *DB) IsDIDBlocked(ctx context.Context, did string) (bool, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	var blocked bool
+	err := d.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM blockeddids WHERE did = ?);", did).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("run query to check blocked DID: %w", err)
+	}
+	return blocked, nil
+}
+
+// GetBlockedDIDs returns the blocked accounts, most recently blocked first.
+func (d *DB) GetBlockedDIDs(ctx context.Context) ([]statusphere.BlockedDID, error) {
+	sql := "SELECT did, reason, createdAt FROM blockeddids ORDER BY createdAt DESC;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get blocked DIDs: %w", err)
+	}
+	defer rows.Close()
+
+	var blocked []statusphere.BlockedDID
+	for rows.Next() {
+		var b statusphere.BlockedDID
+		if err := rows.Scan(&b.Did, &b.Reason, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		blocked = append(blocked, b)
+	}
+	return blocked, rows.Err()
+}
+
+// GetHiddenStatuses returns the hidden statuses, most recently hidden first.
+func (d *DB) GetHiddenStatuses(ctx context.Context) ([]statusphere.HiddenStatus, error) {
+	sql := "SELECT uri, reason, createdAt FROM hiddenstatuses ORDER BY createdAt DESC;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get hidden statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var hidden []statusphere.HiddenStatus
+	for rows.Next() {
+		var h statusphere.HiddenStatus
+		if err := rows.Scan(&h.URI, &h.Reason, &h.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		hidden = append(hidden, h)
+	}
+	return hidden, rows.Err()
+}
+
+// GetModerationActions returns the most recent entries in the moderation audit log.
+func (d *DB) GetModerationActions(ctx context.Context, limit int) ([]statusphere.ModerationAction, error) {
+	sql := "SELECT id, action, subject, reason, actor, createdAt FROM moderationactions ORDER BY id DESC LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get moderation actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []statusphere.ModerationAction
+	for rows.Next() {
+		var a statusphere.ModerationAction
+		if err := rows.Scan(&a.ID, &a.Action, &a.Subject, &a.Reason, &a.Actor, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// GetModerationActionsAfter returns up to limit entries in the moderation audit log with an
+// ID greater than after, oldest first.
+func (d *DB) GetModerationActionsAfter(ctx context.Context, after int64, limit int) ([]statusphere.ModerationAction, error) {
+	sql := "SELECT id, action, subject, reason, actor, createdAt FROM moderationactions WHERE id > ? ORDER BY id LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get moderation actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []statusphere.ModerationAction
+	for rows.Next() {
+		var a statusphere.ModerationAction
+		if err := rows.Scan(&a.ID, &a.Action, &a.Subject, &a.Reason, &a.Actor, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// GetActiveModerationActions returns up to limit of the blocks and hides that are still in
+// effect, which are those that are the latest action for their subject, oldest first and
+// with an ID greater than after. Only subjects that match one of the patterns are returned,
+// where a pattern ending in * matches subjects that start with what's before it.
+func (d *DB) GetActiveModerationActions(ctx context.Context, patterns []string, after int64, limit int) ([]statusphere.ModerationAction, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	var conditions []string
+	args := []any{statusphere.ModerationActionBlock, statusphere.ModerationActionHide, after}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			conditions = append(conditions, "substr(subject, 1, ?) = ?")
+			args = append(args, len(prefix), prefix)
+		} else {
+			conditions = append(conditions, "subject = ?")
+			args = append(args, pattern)
+		}
+	}
+	args = append(args, limit)
+
+	sql := `SELECT id, action, subject, reason, actor, createdAt FROM moderationactions
+		WHERE id IN (SELECT MAX(id) FROM moderationactions GROUP BY subject)
+		AND action IN (?, ?) AND id > ? AND (` + strings.Join(conditions, " OR ") + `)
+		ORDER BY id LIMIT ?;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get active moderation actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []statusphere.ModerationAction
+	for rows.Next() {
+		var a statusphere.ModerationAction
+		if err := rows.Scan(&a.ID, &a.Action, &a.Subject, &a.Reason, &a.Actor, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
 	"github.com/bluesky-social/indigo/atproto/syntax"
@@ -45,8 +46,11 @@ func (d *DB) SaveAuthRequestInfo(ctx context.Context, info oauth.AuthRequestData
 		did = info.AccountDID.String()
 	}
 
-	sql := `INSERT INTO oauthrequests (state, authServerURL, accountDID, scope, requestURI, authServerTokenEndpoint, pkceVerifier, dpopAuthserverNonce, dpopPrivateKeyMultibase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(state) DO NOTHING;`
-	_, err := d.db.Exec(sql, info.State, info.AuthServerURL, did, info.Scope, info.RequestURI, info.AuthServerTokenEndpoint, info.PKCEVerifier, info.DPoPAuthServerNonce, info.DPoPPrivateKeyMultibase)
+	sql := `INSERT INTO oauthrequests (state, authServerURL, accountDID, scope, requestURI, authServerTokenEndpoint, pkceVerifier, dpopAuthserverNonce, dpopPrivateKeyMultibase, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(state) DO NOTHING;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, info.State, info.AuthServerURL, did, info.Scope, info.RequestURI, info.AuthServerTokenEndpoint, info.PKCEVerifier, info.DPoPAuthServerNonce, info.DPoPPrivateKeyMultibase, time.Now().UnixMilli())
 	if err != nil {
 		slog.Error("saving auth request info", "error", err)
 		return fmt.Errorf("exec insert oauth request: %w", err)
@@ -58,7 +62,10 @@ func (d *DB) SaveAuthRequestInfo(ctx context.Context, info oauth.AuthRequestData
 func (d *DB) GetAuthRequestInfo(ctx context.Context, state string) (*oauth.AuthRequestData, error) {
 	var oauthRequest oauth.AuthRequestData
 	sql := "SELECT state, authServerURL, accountDID, scope, requestURI, authServerTokenEndpoint, pkceVerifier, dpopAuthserverNonce, dpopPrivateKeyMultibase FROM oauthrequests where state = ?;"
-	rows, err := d.db.Query(sql, state)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, state)
 	if err != nil {
 		return nil, fmt.Errorf("run query to get oauth request: %w", err)
 	}
@@ -86,9 +93,27 @@ func (d *DB) GetAuthRequestInfo(ctx context.Context, state string) (*oauth.AuthR
 
 func (d *DB) DeleteAuthRequestInfo(ctx context.Context, state string) error {
 	sql := "DELETE FROM oauthrequests WHERE state = ?;"
-	_, err := d.db.Exec(sql, state)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, state)
 	if err != nil {
 		return fmt.Errorf("exec delete oauth request: %w", err)
 	}
 	return nil
 }
+
+// DeleteAuthRequestsBefore deletes auth requests created before the given time in unix
+// milliseconds, which are logins that were never completed. Requests stored before their
+// creation time was recorded are treated as old.
+func (d *DB) DeleteAuthRequestsBefore(ctx context.Context, before int64) (int64, error) {
+	sql := "DELETE FROM oauthrequests WHERE createdAt IS NULL OR createdAt < ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	res, err := d.db.ExecContext(ctx, sql, before)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete oauth requests: %w", err)
+	}
+	return res.RowsAffected()
+}
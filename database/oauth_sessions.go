@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	statusphere "github.com/willdot/statusphere-go"
 )
 
 func createOauthSessionsTable(db *sql.DB) error {
@@ -42,6 +45,9 @@ func createOauthSessionsTable(db *sql.DB) error {
 	return nil
 }
 
+// SaveSession stores a new session or, when the OAuth client has refreshed its tokens,
+// updates the existing one. An account can have a session for each browser it's logged in
+// from.
 func (d *DB) SaveSession(ctx context.Context, sess oauth.ClientSessionData) error {
 	scopes, err := json.Marshal(sess.Scopes)
 	if err != nil {
@@ -50,8 +56,17 @@ func (d *DB) SaveSession(ctx context.Context, sess oauth.ClientSessionData) erro
 
 	slog.Info("session to save", "did", sess.AccountDID.String(), "session id", sess.SessionID)
 
-	sql := `INSERT INTO oauthsessions (accountDID, sessionID, hostURL,  authServerURL, authServerTokenEndpoint, scopes, accessToken, refreshToken, dpopAuthServerNonce, dpopHostNonce, dpopPrivateKeyMultibase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(accountDID) DO NOTHING;` // TODO: update on conflict
-	_, err = d.db.Exec(sql, sess.AccountDID.String(), sess.SessionID, sess.HostURL, sess.AuthServerURL, sess.AuthServerTokenEndpoint, string(scopes), sess.AccessToken, sess.RefreshToken, sess.DPoPAuthServerNonce, sess.DPoPHostNonce, sess.DPoPPrivateKeyMultibase)
+	sql := `INSERT INTO oauthsessions (accountDID, sessionID, hostURL,  authServerURL, authServerTokenEndpoint, scopes, accessToken, refreshToken, dpopAuthServerNonce, dpopHostNonce, dpopPrivateKeyMultibase, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(accountDID, sessionID) DO UPDATE SET
+			scopes = excluded.scopes,
+			accessToken = excluded.accessToken,
+			refreshToken = excluded.refreshToken,
+			dpopAuthServerNonce = excluded.dpopAuthServerNonce,
+			dpopHostNonce = excluded.dpopHostNonce;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err = d.db.ExecContext(ctx, sql, sess.AccountDID.String(), sess.SessionID, sess.HostURL, sess.AuthServerURL, sess.AuthServerTokenEndpoint, string(scopes), sess.AccessToken, sess.RefreshToken, sess.DPoPAuthServerNonce, sess.DPoPHostNonce, sess.DPoPPrivateKeyMultibase, time.Now().UnixMilli())
 	if err != nil {
 		slog.Error("saving session", "error", err)
 		return fmt.Errorf("exec insert oauth session: %w", err)
@@ -60,10 +75,15 @@ func (d *DB) SaveSession(ctx context.Context, sess oauth.ClientSessionData) erro
 	return nil
 }
 
+// GetSession returns a session, or statusphere.ErrorNotFound if the user has been logged out
+// of it.
 func (d *DB) GetSession(ctx context.Context, did syntax.DID, sessionID string) (*oauth.ClientSessionData, error) {
 	var session oauth.ClientSessionData
 	sql := "SELECT hostURL, authServerURL, authServerTokenEndpoint, scopes, accessToken, refreshToken, dpopAuthServerNonce, dpopHostNonce, dpopPrivateKeyMultibase FROM oauthsessions where accountDID = ? AND sessionID = ?;"
-	rows, err := d.db.Query(sql, did.String(), sessionID)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, did.String(), sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("run query to get oauth session: %w", err)
 	}
@@ -86,14 +106,55 @@ func (d *DB) GetSession(ctx context.Context, did syntax.DID, sessionID string) (
 
 		return &session, nil
 	}
-	return nil, fmt.Errorf("not found")
+	return nil, statusphere.ErrorNotFound
 }
 
 func (d *DB) DeleteSession(ctx context.Context, did syntax.DID, sessionID string) error {
-	sql := "DELETE FROM oauthsessions WHERE accountDID = ?;"
-	_, err := d.db.Exec(sql, did.String())
+	sql := "DELETE FROM oauthsessions WHERE accountDID = ? AND sessionID = ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, did.String(), sessionID)
 	if err != nil {
 		return fmt.Errorf("exec delete oauth session: %w", err)
 	}
 	return nil
 }
+
+// GetSessions returns the OAuth sessions of logged in users, most recent first.
+func (d *DB) GetSessions(ctx context.Context) ([]statusphere.OAuthSession, error) {
+	sql := "SELECT accountDID, sessionID, hostURL, scopes, COALESCE(createdAt, 0) FROM oauthsessions ORDER BY id desc;"
+	return d.querySessions(ctx, sql)
+}
+
+// GetAccountSessions returns the OAuth sessions of an account, one for each browser it's
+// logged in from, most recent first.
+func (d *DB) GetAccountSessions(ctx context.Context, did syntax.DID) ([]statusphere.OAuthSession, error) {
+	sql := "SELECT accountDID, sessionID, hostURL, scopes, COALESCE(createdAt, 0) FROM oauthsessions WHERE accountDID = ? ORDER BY id desc;"
+	return d.querySessions(ctx, sql, did.String())
+}
+
+func (d *DB) querySessions(ctx context.Context, sql string, args ...any) ([]statusphere.OAuthSession, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get oauth sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []statusphere.OAuthSession
+	for rows.Next() {
+		var session statusphere.OAuthSession
+		var scopes string
+		if err := rows.Scan(&session.Did, &session.SessionID, &session.HostURL, &scopes, &session.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		if err := json.Unmarshal([]byte(scopes), &session.Scopes); err != nil {
+			return nil, fmt.Errorf("parsing scopes: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/willdot/statusphere-go"
+)
+
+func createPaletteTable(db *sql.DB) error {
+	createPaletteTableSQL := `CREATE TABLE IF NOT EXISTS palette (
+		"status" TEXT NOT NULL PRIMARY KEY,
+		"label" TEXT NOT NULL,
+		"category" TEXT NOT NULL,
+		"position" integer NOT NULL
+	  );`
+
+	slog.Info("Create palette table...")
+	statement, err := db.Prepare(createPaletteTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create palette table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create palette table: %w", err)
+	}
+	slog.Info("palette table created")
+
+	return nil
+}
+
+// GetPalette returns the statuses in the palette in the order they're shown.
+func (d *DB) GetPalette(ctx context.Context) ([]statusphere.PaletteStatus, error) {
+	sql := "SELECT status, label, category FROM palette ORDER BY position;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get palette: %w", err)
+	}
+	defer rows.Close()
+
+	var palette []statusphere.PaletteStatus
+	for rows.Next() {
+		var status statusphere.PaletteStatus
+		if err := rows.Scan(&status.Status, &status.Label, &status.Category); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		palette = append(palette, status)
+	}
+	return palette, rows.Err()
+}
+
+// SavePaletteStatus adds a status to the end of the palette, or updates its label and
+// category if it's already in the palette.
+func (d *DB) SavePaletteStatus(ctx context.Context, status statusphere.PaletteStatus) error {
+	sql := `INSERT INTO palette (status, label, category, position) VALUES (?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM palette))
+		ON CONFLICT(status) DO UPDATE SET label = excluded.label, category = excluded.category;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, status.Status, status.Label, status.Category)
+	if err != nil {
+		return fmt.Errorf("exec save palette status: %w", err)
+	}
+	return nil
+}
+
+// DeletePaletteStatus removes a status from the palette.
+func (d *DB) DeletePaletteStatus(ctx context.Context, status string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, "DELETE FROM palette WHERE status = ?;", status)
+	if err != nil {
+		return fmt.Errorf("exec delete palette status: %w", err)
+	}
+	return nil
+}
+
+// ReplacePalette replaces the whole palette, which is shown in the order given.
+func (d *DB) ReplacePalette(ctx context.Context, palette []statusphere.PaletteStatus) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM palette;"); err != nil {
+		return fmt.Errorf("exec delete palette: %w", err)
+	}
+	for i, status := range palette {
+		sql := "INSERT INTO palette (status, label, category, position) VALUES (?, ?, ?, ?);"
+		if _, err := tx.ExecContext(ctx, sql, status.Status, status.Label, status.Category, i+1); err != nil {
+			return fmt.Errorf("exec insert palette status: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
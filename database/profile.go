@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -29,9 +30,12 @@ func createProfileTable(db *sql.DB) error {
 	return nil
 }
 
-func (d *DB) CreateProfile(profile statusphere.UserProfile) error {
+func (d *DB) CreateProfile(ctx context.Context, profile statusphere.UserProfile) error {
 	sql := `INSERT INTO profile (did, handle, displayName) VALUES (?, ?, ?) ON CONFLICT(did) DO NOTHING;` // TODO: What about when users change their handle or display name???
-	_, err := d.db.Exec(sql, profile.Did, profile.Handle, profile.DisplayName)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, sql, profile.Did, profile.Handle, profile.DisplayName)
 	if err != nil {
 		return fmt.Errorf("exec insert profile: %w", err)
 	}
@@ -39,9 +43,46 @@ func (d *DB) CreateProfile(profile statusphere.UserProfile) error {
 	return nil
 }
 
-func (d *DB) GetHandleAndDisplayNameForDid(did string) (statusphere.UserProfile, error) {
+// CreateProfiles stores all of the profiles in a single transaction. Profiles that are
+// already stored are left as they are.
+func (d *DB) CreateProfiles(ctx context.Context, profiles []statusphere.UserProfile) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	sql := `INSERT INTO profile (did, handle, displayName) VALUES (?, ?, ?) ON CONFLICT(did) DO NOTHING;`
+	statement, err := tx.PrepareContext(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("prepare insert profile: %w", err)
+	}
+	defer statement.Close()
+
+	for _, profile := range profiles {
+		_, err = statement.ExecContext(ctx, profile.Did, profile.Handle, profile.DisplayName)
+		if err != nil {
+			return fmt.Errorf("exec insert profile: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (d *DB) GetHandleAndDisplayNameForDid(ctx context.Context, did string) (statusphere.UserProfile, error) {
 	sql := "SELECT did, handle, displayName FROM profile WHERE did = ?;"
-	rows, err := d.db.Query(sql, did)
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, did)
 	if err != nil {
 		return statusphere.UserProfile{}, fmt.Errorf("run query to get profile': %w", err)
 	}
@@ -57,3 +98,53 @@ func (d *DB) GetHandleAndDisplayNameForDid(did string) (statusphere.UserProfile,
 	}
 	return profile, statusphere.ErrorNotFound
 }
+
+// EachProfile calls fn for every profile that matches the filter, ordered by DID, stopping
+// at the first error. Profiles don't have a time of their own, so the time range of the
+// filter matches the profiles of accounts that posted a status within it. Like EachStatus,
+// rows are streamed and the operation timeout isn't applied.
+func (d *DB) EachProfile(ctx context.Context, filter statusphere.ExportFilter, fn func(profile statusphere.UserProfile) error) error {
+	where, args := filterSQL(statusphere.ExportFilter{Dids: filter.Dids}, "did", "")
+	if filter.Since > 0 || filter.Until > 0 {
+		statusWhere, statusArgs := filterSQL(statusphere.ExportFilter{Since: filter.Since, Until: filter.Until}, "", "createdAt")
+		if where == "" {
+			where = " WHERE "
+		} else {
+			where += " AND "
+		}
+		where += "did IN (SELECT did FROM status" + statusWhere + ")"
+		args = append(args, statusArgs...)
+	}
+
+	sql := "SELECT did, handle, displayName FROM profile" + where + " ORDER BY did;"
+	rows, err := d.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("run query to get profiles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profile statusphere.UserProfile
+		if err := rows.Scan(&profile.Did, &profile.Handle, &profile.DisplayName); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+		if err := fn(profile); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteUnusedProfiles deletes cached profiles of accounts that don't have any statuses and
+// returns how many were deleted. They are looked up again if they're needed.
+func (d *DB) DeleteUnusedProfiles(ctx context.Context) (int64, error) {
+	sql := "DELETE FROM profile WHERE did NOT IN (SELECT did FROM status);"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	res, err := d.db.ExecContext(ctx, sql)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete profiles: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The ratelimits table has a token bucket for each rate limited key, so that processes
+// sharing the database share rate limits.
+func createRateLimitsTable(db *sql.DB) error {
+	createRateLimitsTableSQL := `CREATE TABLE IF NOT EXISTS ratelimits (
+		"key" TEXT NOT NULL PRIMARY KEY,
+		"tokens" REAL NOT NULL,
+		"updatedAt" integer NOT NULL
+	  );`
+
+	slog.Info("Create ratelimits table...")
+	statement, err := db.Prepare(createRateLimitsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create ratelimits table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create ratelimits table: %w", err)
+	}
+	slog.Info("ratelimits table created")
+
+	return nil
+}
+
+// TakeRateLimitToken takes a token from the key's bucket, which is refilled at the limit up
+// to the burst. The bucket is refilled and the token taken in a single statement, so
+// processes sharing the database can't take the same token. If the bucket is empty it
+// returns false and how long until there's a token.
+func (d *DB) TakeRateLimitToken(ctx context.Context, key string, now time.Time, limit rate.Limit, burst int) (bool, time.Duration, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	nowMilli := now.UnixMilli()
+	perMilli := float64(limit) / 1000
+	sql := `INSERT INTO ratelimits (key, tokens, updatedAt) VALUES (?, ? - 1, ?)
+		ON CONFLICT(key) DO UPDATE SET tokens = MIN(?, tokens + MAX(0, ? - updatedAt) * ?) - 1, updatedAt = ?
+		WHERE MIN(?, tokens + MAX(0, ? - updatedAt) * ?) >= 1;`
+	res, err := d.db.ExecContext(ctx, sql, key, burst, nowMilli, burst, nowMilli, perMilli, nowMilli, burst, nowMilli, perMilli)
+	if err != nil {
+		return false, 0, fmt.Errorf("exec take rate limit token: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, 0, fmt.Errorf("get rows affected: %w", err)
+	}
+	if n == 1 {
+		return true, 0, nil
+	}
+
+	var tokens float64
+	var updatedAt int64
+	err = d.db.QueryRowContext(ctx, "SELECT tokens, updatedAt FROM ratelimits WHERE key = ?;", key).Scan(&tokens, &updatedAt)
+	if err != nil {
+		return false, 0, fmt.Errorf("get rate limit bucket: %w", err)
+	}
+	tokens = math.Min(float64(burst), tokens+float64(max(0, nowMilli-updatedAt))*perMilli)
+	wait := time.Duration((1 - tokens) / perMilli * float64(time.Millisecond))
+	return false, max(wait, time.Millisecond), nil
+}
+
+// ReturnRateLimitToken puts a token taken with TakeRateLimitToken back in the key's bucket,
+// without letting it hold more than the burst.
+func (d *DB) ReturnRateLimitToken(ctx context.Context, key string, burst int) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	_, err := d.db.ExecContext(ctx, "UPDATE ratelimits SET tokens = MIN(?, tokens + 1) WHERE key = ?;", burst, key)
+	if err != nil {
+		return fmt.Errorf("exec return rate limit token: %w", err)
+	}
+	return nil
+}
+
+// DeleteRateLimitsBefore deletes the buckets of keys that haven't been rate limited since
+// the given time in unix milliseconds and returns how many were deleted. Buckets that have
+// had time to refill are the same as new ones, so they can be deleted.
+func (d *DB) DeleteRateLimitsBefore(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	sql := "DELETE FROM ratelimits WHERE updatedAt < ?;"
+	res, err := d.db.ExecContext(ctx, sql, before)
+	if err != nil {
+		return 0, fmt.Errorf("exec delete rate limits: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TestReturnRateLimitToken empties a bucket, puts a token back and checks it can be taken
+// again, but that putting back more than were taken doesn't overfill the bucket.
+func TestReturnRateLimitToken(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	limit := rate.Every(time.Hour)
+
+	take := func() bool {
+		t.Helper()
+		allowed, _, err := db.TakeRateLimitToken(ctx, "test", now, limit, 1)
+		if err != nil {
+			t.Fatalf("take token: %s", err)
+		}
+		return allowed
+	}
+
+	if !take() {
+		t.Fatal("expected a token to be taken from a new bucket")
+	}
+	if take() {
+		t.Fatal("expected the bucket to be empty")
+	}
+	for range 2 {
+		if err := db.ReturnRateLimitToken(ctx, "test", 1); err != nil {
+			t.Fatalf("return token: %s", err)
+		}
+	}
+	if !take() {
+		t.Fatal("expected the returned token to be taken")
+	}
+	if take() {
+		t.Fatal("expected the bucket to only hold its burst")
+	}
+}
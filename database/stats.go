@@ -0,0 +1,179 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// The rollup tables hold running totals per UTC day of when statuses were created, which
+// are kept up to date as statuses are stored so that stats don't need to scan every status.
+// They count every stored status, as statuses can be hidden and shown again by moderation
+// and labels at any time, so the statuses that aren't shown are taken off when they're read.
+func createStatsTables(db *sql.DB) error {
+	createStatusDailyCountsTableSQL := `CREATE TABLE IF NOT EXISTS statusdailycounts (
+		"day" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"count" integer NOT NULL,
+		PRIMARY KEY (day, status)
+	  );`
+
+	slog.Info("Create statusdailycounts table...")
+	statement, err := db.Prepare(createStatusDailyCountsTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create statusdailycounts table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create statusdailycounts table: %w", err)
+	}
+	slog.Info("statusdailycounts table created")
+
+	// the number of statuses each user posted each day, so that unique users per day is the
+	// number of rows for the day
+	createStatusDailyUsersTableSQL := `CREATE TABLE IF NOT EXISTS statusdailyusers (
+		"day" TEXT NOT NULL,
+		"did" TEXT NOT NULL,
+		"count" integer NOT NULL,
+		PRIMARY KEY (day, did)
+	  );`
+
+	slog.Info("Create statusdailyusers table...")
+	statement, err = db.Prepare(createStatusDailyUsersTableSQL)
+	if err != nil {
+		return fmt.Errorf("prepare DB statement to create statusdailyusers table: %w", err)
+	}
+	_, err = statement.Exec()
+	if err != nil {
+		return fmt.Errorf("exec sql statement to create statusdailyusers table: %w", err)
+	}
+	slog.Info("statusdailyusers table created")
+
+	return nil
+}
+
+// statsDay is the UTC day a status is counted in, going by when it's treated as having been
+// created.
+func statsDay(effectiveAt int64) string {
+	return time.UnixMilli(effectiveAt).UTC().Format(time.DateOnly)
+}
+
+// statsDaySQL is statsDay for a status in SQL.
+const statsDaySQL = "date(effectiveAt / 1000, 'unixepoch')"
+
+// updateStatsRollups adds delta to the rollups that the status is counted in, so a status
+// being stored is counted with 1 and a status being removed with -1.
+func updateStatsRollups(ctx context.Context, tx *sql.Tx, status statusphere.Status, delta int) error {
+	day := statsDay(status.EffectiveAt)
+
+	sql := `INSERT INTO statusdailycounts (day, status, count) VALUES (?, ?, ?) ON CONFLICT(day, status) DO UPDATE SET count = count + excluded.count;`
+	if _, err := tx.ExecContext(ctx, sql, day, status.Status, delta); err != nil {
+		return fmt.Errorf("exec update status daily count: %w", err)
+	}
+	sql = `INSERT INTO statusdailyusers (day, did, count) VALUES (?, ?, ?) ON CONFLICT(day, did) DO UPDATE SET count = count + excluded.count;`
+	if _, err := tx.ExecContext(ctx, sql, day, status.Did, delta); err != nil {
+		return fmt.Errorf("exec update status daily users: %w", err)
+	}
+
+	if delta < 0 {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM statusdailycounts WHERE day = ? AND status = ? AND count <= 0;", day, status.Status); err != nil {
+			return fmt.Errorf("exec delete status daily count: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM statusdailyusers WHERE day = ? AND did = ? AND count <= 0;", day, status.Did); err != nil {
+			return fmt.Errorf("exec delete status daily users: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetTopStatuses returns the most posted statuses on the UTC day, most popular first, leaving
+// out statuses that aren't shown.
+func (d *DB) GetTopStatuses(ctx context.Context, day string, limit int) ([]statusphere.StatusCount, error) {
+	sql := `SELECT status, SUM(count) AS total FROM (
+			SELECT status, count FROM statusdailycounts WHERE day = ?
+			UNION ALL
+			SELECT status, -COUNT(*) FROM status WHERE ` + hiddenStatusSQL + ` AND ` + statsDaySQL + ` = ? GROUP BY status
+		) GROUP BY status HAVING total > 0 ORDER BY total desc, status LIMIT ?;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, day, day, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get top statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []statusphere.StatusCount
+	for rows.Next() {
+		var count statusphere.StatusCount
+		if err := rows.Scan(&count.Status, &count.Count); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetDailyStatusCounts returns how many times each status was posted on each UTC day from
+// the first day to the last, inclusive, ordered by day, leaving out statuses that aren't
+// shown.
+func (d *DB) GetDailyStatusCounts(ctx context.Context, from, to string) ([]statusphere.DailyStatusCount, error) {
+	sql := `SELECT day, status, SUM(count) AS total FROM (
+			SELECT day, status, count FROM statusdailycounts WHERE day >= ? AND day <= ?
+			UNION ALL
+			SELECT ` + statsDaySQL + `, status, -COUNT(*) FROM status WHERE ` + hiddenStatusSQL + ` AND ` + statsDaySQL + ` BETWEEN ? AND ? GROUP BY 1, 2
+		) GROUP BY day, status HAVING total > 0 ORDER BY day, total desc, status;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, from, to, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get daily status counts: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []statusphere.DailyStatusCount
+	for rows.Next() {
+		var count statusphere.DailyStatusCount
+		if err := rows.Scan(&count.Day, &count.Status, &count.Count); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetDailyUsers returns how many different users posted a status on each UTC day from the
+// first day to the last, inclusive, ordered by day, going by the statuses that are shown.
+// Days nobody posted on are left out.
+func (d *DB) GetDailyUsers(ctx context.Context, from, to string) ([]statusphere.DailyUsers, error) {
+	sql := `SELECT day, COUNT(*) FROM (
+			SELECT day, did, SUM(count) AS total FROM (
+				SELECT day, did, count FROM statusdailyusers WHERE day >= ? AND day <= ?
+				UNION ALL
+				SELECT ` + statsDaySQL + `, did, -COUNT(*) FROM status WHERE ` + hiddenStatusSQL + ` AND ` + statsDaySQL + ` BETWEEN ? AND ? GROUP BY 1, 2
+			) GROUP BY day, did HAVING total > 0
+		) GROUP BY day ORDER BY day;`
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, from, to, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get daily users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []statusphere.DailyUsers
+	for rows.Next() {
+		var day statusphere.DailyUsers
+		if err := rows.Scan(&day.Day, &day.Users); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		users = append(users, day)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+// TestStatsLeaveOutHiddenStatuses stores statuses from three accounts, then hides one of
+// them and blocks another account, and checks the stats only count the statuses that are
+// still shown.
+func TestStatsLeaveOutHiddenStatuses(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	createdAt := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	day := statsDay(createdAt.UnixMilli())
+	shown := newTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", createdAt)
+	hidden := newTestStatus("did:plc:bob", "3lsxb3n2bqs2b", "👍", createdAt)
+	blocked := newTestStatus("did:plc:carol", "3lsxb3n2bqs2c", "🤔", createdAt)
+	for _, status := range []statusphere.Status{shown, hidden, blocked} {
+		if err := db.CreateStatus(ctx, status); err != nil {
+			t.Fatalf("store status: %s", err)
+		}
+	}
+	if err := db.HideStatus(ctx, hidden.URI, "test", "test"); err != nil {
+		t.Fatalf("hide status: %s", err)
+	}
+	if err := db.BlockDID(ctx, blocked.Did, "test", "test"); err != nil {
+		t.Fatalf("block DID: %s", err)
+	}
+
+	top, err := db.GetTopStatuses(ctx, day, 10)
+	if err != nil {
+		t.Fatalf("get top statuses: %s", err)
+	}
+	if expected := []statusphere.StatusCount{{Status: "👍", Count: 1}}; !slices.Equal(top, expected) {
+		t.Errorf("expected top statuses %+v, got %+v", expected, top)
+	}
+
+	counts, err := db.GetDailyStatusCounts(ctx, day, day)
+	if err != nil {
+		t.Fatalf("get daily status counts: %s", err)
+	}
+	if expected := []statusphere.DailyStatusCount{{Day: day, Status: "👍", Count: 1}}; !slices.Equal(counts, expected) {
+		t.Errorf("expected daily status counts %+v, got %+v", expected, counts)
+	}
+
+	users, err := db.GetDailyUsers(ctx, day, day)
+	if err != nil {
+		t.Fatalf("get daily users: %s", err)
+	}
+	if expected := []statusphere.DailyUsers{{Day: day, Users: 1}}; !slices.Equal(users, expected) {
+		t.Errorf("expected daily users %+v, got %+v", expected, users)
+	}
+
+	// showing them again counts them again
+	if err := db.UnhideStatus(ctx, hidden.URI, "test"); err != nil {
+		t.Fatalf("unhide status: %s", err)
+	}
+	if err := db.UnblockDID(ctx, blocked.Did, "test"); err != nil {
+		t.Fatalf("unblock DID: %s", err)
+	}
+	users, err = db.GetDailyUsers(ctx, day, day)
+	if err != nil {
+		t.Fatalf("get daily users: %s", err)
+	}
+	if expected := []statusphere.DailyUsers{{Day: day, Users: 3}}; !slices.Equal(users, expected) {
+		t.Errorf("expected daily users %+v once shown again, got %+v", expected, users)
+	}
+}
@@ -1,7 +1,9 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -31,19 +33,165 @@ func createStatusTable(db *sql.DB) error {
 	return nil
 }
 
-func (d *DB) CreateStatus(status statusphere.Status) error {
-	sql := `INSERT INTO status (uri, did, status, createdAt, indexedAt) VALUES (?, ?, ?, ?, ?) ON CONFLICT(uri) DO NOTHING;`
-	_, err := d.db.Exec(sql, status.URI, status.Did, status.Status, status.CreatedAt, status.IndexedAt)
+// insertStatus stores a status, or replaces the stored copy if the new one has a different
+// CID and a revision that's at least as new. The CID of the copy that was replaced is kept
+// in divergedCid. If a status was stored without a CID, the new copy replaces it without
+// being treated as diverged. The status's effective creation time and flag are worked out
+// as it's stored, and the stats rollups are updated to match.
+func insertStatus(ctx context.Context, tx *sql.Tx, status statusphere.Status) error {
+	var stored statusphere.Status
+	err := tx.QueryRowContext(ctx, "SELECT status, createdAt, indexedAt, effectiveAt, COALESCE(cid, ''), COALESCE(rev, '') FROM status WHERE uri = ?;", status.URI).
+		Scan(&stored.Status, &stored.CreatedAt, &stored.IndexedAt, &stored.EffectiveAt, &stored.CID, &stored.Rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		status.EffectiveAt, status.Flag = statusphere.EffectiveCreatedAt(status.CreatedAt, status.IndexedAt)
+		if status.Flag != "" {
+			slog.Warn("flagging status with suspicious createdAt", "uri", status.URI, "flag", status.Flag, "created at", status.CreatedAt, "indexed at", status.IndexedAt)
+		}
+
+		sql := `INSERT INTO status (uri, did, status, createdAt, indexedAt, cid, rev, effectiveAt, flag) VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, NULLIF(?, ''));`
+		_, err := tx.ExecContext(ctx, sql, status.URI, status.Did, status.Status, status.CreatedAt, status.IndexedAt, status.CID, status.Rev, status.EffectiveAt, status.Flag)
+		if err != nil {
+			return fmt.Errorf("exec insert status: %w", err)
+		}
+		if err := updateStatsRollups(ctx, tx, status, 1); err != nil {
+			return err
+		}
+		return refreshCurrentStatus(ctx, tx, status.Did)
+	}
+	if err != nil {
+		return fmt.Errorf("get stored status: %w", err)
+	}
+
+	if !replacesStoredStatus(stored, status) {
+		return nil
+	}
+	if stored.CID != "" {
+		slog.Warn("status copies diverged, replacing the stored copy", "uri", status.URI, "stored cid", stored.CID, "cid", status.CID, "rev", status.Rev)
+	}
+
+	// the stored copy keeps when it was first indexed
+	status.EffectiveAt, status.Flag = statusphere.EffectiveCreatedAt(status.CreatedAt, stored.IndexedAt)
+	sql := `UPDATE status SET status = ?, createdAt = ?, cid = ?, rev = COALESCE(NULLIF(?, ''), rev), divergedCid = COALESCE(cid, divergedCid), effectiveAt = ?, flag = NULLIF(?, '') WHERE uri = ?;`
+	_, err = tx.ExecContext(ctx, sql, status.Status, status.CreatedAt, status.CID, status.Rev, status.EffectiveAt, status.Flag, status.URI)
+	if err != nil {
+		return fmt.Errorf("exec update status: %w", err)
+	}
+
+	stored.Did = status.Did
+	if err := updateStatsRollups(ctx, tx, stored, -1); err != nil {
+		return err
+	}
+	if err := updateStatsRollups(ctx, tx, status, 1); err != nil {
+		return err
+	}
+	// the new copy may have been created at a different time
+	return refreshCurrentStatus(ctx, tx, status.Did)
+}
+
+func replacesStoredStatus(stored, status statusphere.Status) bool {
+	if status.CID == "" || status.CID == stored.CID {
+		return false
+	}
+	if stored.CID == "" {
+		return true
+	}
+	// revisions are TIDs so they sort by time
+	return stored.Rev == "" || status.Rev == "" || status.Rev >= stored.Rev
+}
+
+// CreateStatus stores a status. If the status is already stored it's only replaced if the
+// new copy has a CID that differs from the stored one and isn't from an older revision of
+// the repo, which is logged as the copies having diverged.
+func (d *DB) CreateStatus(ctx context.Context, status statusphere.Status) error {
+	return d.CreateStatuses(ctx, []statusphere.Status{status})
+}
+
+// CreateStatuses stores all of the statuses in a single transaction, each in the same way
+// as CreateStatus.
+func (d *DB) CreateStatuses(ctx context.Context, statuses []statusphere.Status) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, status := range statuses {
+		err = insertStatus(ctx, tx, status)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteStatus deletes a status, uncounting it from the stats and updating its user's
+// current status. Deleting a status that isn't stored does nothing.
+func (d *DB) DeleteStatus(ctx context.Context, uri string) error {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stored statusphere.Status
+	err = tx.QueryRowContext(ctx, "DELETE FROM status WHERE uri = ? RETURNING did, status, effectiveAt;", uri).
+		Scan(&stored.Did, &stored.Status, &stored.EffectiveAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("exec delete status: %w", err)
+	}
+
+	if err := updateStatsRollups(ctx, tx, stored, -1); err != nil {
+		return err
+	}
+	if err := refreshCurrentStatus(ctx, tx, stored.Did); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		return fmt.Errorf("exec insert status: %w", err)
+		return fmt.Errorf("commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-func (d *DB) GetStatuses(limit int) ([]statusphere.Status, error) {
-	sql := "SELECT uri, did, status, createdAt FROM status ORDER BY createdAt desc LIMIT ?;"
-	rows, err := d.db.Query(sql, limit)
+// statusColumns are the columns scanned by scanStatus.
+const statusColumns = "uri, did, status, createdAt, indexedAt, COALESCE(cid, ''), COALESCE(rev, ''), COALESCE(divergedCid, ''), effectiveAt, COALESCE(flag, '')"
+
+func scanStatus(rows *sql.Rows) (statusphere.Status, error) {
+	var status statusphere.Status
+	err := rows.Scan(&status.URI, &status.Did, &status.Status, &status.CreatedAt, &status.IndexedAt, &status.CID, &status.Rev, &status.DivergedCID, &status.EffectiveAt, &status.Flag)
+	if err != nil {
+		return status, fmt.Errorf("scan row: %w", err)
+	}
+	return status, nil
+}
+
+// GetStatuses returns the most recent statuses, going by when they're treated as having
+// been created so that statuses that say they were created in the future don't stay at the
+// top. Hidden statuses and the statuses of blocked accounts aren't included, and the labels
+// that apply to each status are set.
+func (d *DB) GetStatuses(ctx context.Context, limit int) ([]statusphere.Status, error) {
+	sql := "SELECT " + statusColumns + " FROM status WHERE " + visibleStatusSQL + " ORDER BY effectiveAt desc LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, limit)
 	if err != nil {
 		return nil, fmt.Errorf("run query to get status': %w", err)
 	}
@@ -51,12 +199,92 @@ func (d *DB) GetStatuses(limit int) ([]statusphere.Status, error) {
 
 	var results []statusphere.Status
 	for rows.Next() {
-		var status statusphere.Status
-		if err := rows.Scan(&status.URI, &status.Did, &status.Status, &status.CreatedAt); err != nil {
-			return nil, fmt.Errorf("scan row: %w", err)
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
 		}
 
 		results = append(results, status)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachLabels(ctx, d.db, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
+
+// EachStatus calls fn for every status that matches the filter, oldest first, stopping at
+// the first error. Rows are streamed rather than loaded at once, so the operation timeout
+// isn't applied and the caller's context should be used to bound it instead.
+func (d *DB) EachStatus(ctx context.Context, filter statusphere.ExportFilter, fn func(status statusphere.Status) error) error {
+	where, args := filterSQL(filter, "did", "createdAt")
+	sql := "SELECT " + statusColumns + " FROM status" + where + " ORDER BY createdAt, uri;"
+	rows, err := d.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("run query to get status': %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(status); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetFlaggedStatuses returns the most recently indexed statuses that were flagged as having
+// a suspicious createdAt.
+func (d *DB) GetFlaggedStatuses(ctx context.Context, limit int) ([]statusphere.Status, error) {
+	sql := "SELECT " + statusColumns + " FROM status WHERE flag IS NOT NULL ORDER BY indexedAt desc LIMIT ?;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql, limit)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get flagged statuses: %w", err)
+	}
+	defer rows.Close()
+
+	var results []statusphere.Status
+	for rows.Next() {
+		status, err := scanStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, status)
+	}
+	return results, rows.Err()
+}
+
+// GetStatusDids returns the DID of every account that has a status.
+func (d *DB) GetStatusDids(ctx context.Context) ([]string, error) {
+	sql := "SELECT DISTINCT did FROM status ORDER BY did;"
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := d.db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("run query to get status DIDs: %w", err)
+	}
+	defer rows.Close()
+
+	var dids []string
+	for rows.Next() {
+		var did string
+		if err := rows.Scan(&did); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		dids = append(dids, did)
+	}
+	return dids, rows.Err()
+}
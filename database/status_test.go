@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := New(path.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatalf("create database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestStatus(did, rkey, status string, createdAt time.Time) statusphere.Status {
+	return statusphere.Status{
+		URI:       "at://" + did + "/xyz.statusphere.status/" + rkey,
+		Did:       did,
+		Status:    status,
+		CreatedAt: createdAt.UnixMilli(),
+		IndexedAt: createdAt.UnixMilli(),
+		CID:       "bafyreib2rxk3rybk3aobmv5cjuql3bm2twh4jo5uxgf5ak3oltptnoc2vi",
+		Rev:       "3lsxb3mzejc2o",
+	}
+}
+
+// TestCreateStatusDivergedCopy stores a copy of a status with a different CID, as if the copy
+// that came from Jetstream didn't match the one the app created, and checks it replaces the
+// stored one unless it's from an older revision.
+func TestCreateStatusDivergedCopy(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	original := newTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", time.Now())
+	if err := db.CreateStatus(ctx, original); err != nil {
+		t.Fatalf("store status: %s", err)
+	}
+
+	diverged := original
+	diverged.Status = "🤔"
+	diverged.CID = "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	if err := db.CreateStatus(ctx, diverged); err != nil {
+		t.Fatalf("store diverged copy: %s", err)
+	}
+	statuses, err := db.GetStatuses(ctx, 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 1 || statuses[0].CID != diverged.CID || statuses[0].Status != diverged.Status || statuses[0].DivergedCID != original.CID {
+		t.Fatalf("expected the diverged copy to replace the original, got %+v", statuses)
+	}
+
+	older := original
+	older.Rev = "2222222222222"
+	if err := db.CreateStatus(ctx, older); err != nil {
+		t.Fatalf("store older copy: %s", err)
+	}
+	statuses, err = db.GetStatuses(ctx, 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 1 || statuses[0].CID != diverged.CID {
+		t.Fatalf("expected a copy from an older revision to be ignored, got %+v", statuses)
+	}
+}
@@ -0,0 +1,169 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+const (
+	deadLetterStoreTimeout = time.Second * 5
+
+	deadLetterRetryInterval  = time.Second * 30
+	deadLetterRetryBatchSize = 50
+	deadLetterMinBackoff     = time.Second * 30
+	deadLetterMaxBackoff     = time.Hour
+	// once a dead letter has been retried this many times it's left for an operator
+	deadLetterMaxAttempts = 10
+)
+
+// DeadLetter is an event that the consumer failed to handle.
+type DeadLetter struct {
+	ID  int64
+	Did string
+	// RKey is the record key of the record in the event, if it was a commit
+	RKey string
+	// Event is the raw JSON of the Jetstream event
+	Event     []byte
+	Error     string
+	Attempts  int
+	Retryable bool
+	CreatedAt int64
+	// LastAttemptAt and NextAttemptAt are unix milliseconds. NextAttemptAt is only
+	// relevant if the dead letter is retryable.
+	LastAttemptAt int64
+	NextAttemptAt int64
+}
+
+// DeadLetterStore manages the dead letters of events that failed to be handled.
+type DeadLetterStore interface {
+	HandlerStore
+	GetDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	GetDeadLetter(ctx context.Context, id int64) (DeadLetter, error)
+	GetDueDeadLetters(ctx context.Context, now int64, limit int) ([]DeadLetter, error)
+	UpdateDeadLetter(ctx context.Context, deadLetter DeadLetter) error
+	DeleteDeadLetter(ctx context.Context, id int64) error
+	PurgeDeadLetters(ctx context.Context) (int64, error)
+}
+
+func newDeadLetter(event *models.Event, handleErr error) (DeadLetter, error) {
+	raw, err := json.Marshal(event)
+	if err != nil {
+		return DeadLetter{}, fmt.Errorf("marshal event: %w", err)
+	}
+
+	now := time.Now()
+	deadLetter := DeadLetter{
+		Did:           event.Did,
+		Event:         raw,
+		Error:         handleErr.Error(),
+		Attempts:      1,
+		Retryable:     !errors.Is(handleErr, errInvalidRecord),
+		CreatedAt:     now.UnixMilli(),
+		LastAttemptAt: now.UnixMilli(),
+		NextAttemptAt: now.Add(deadLetterBackoff(1)).UnixMilli(),
+	}
+	if event.Commit != nil {
+		deadLetter.RKey = event.Commit.RKey
+	}
+	return deadLetter, nil
+}
+
+// deadLetterBackoff is how long to wait before retrying a dead letter that has failed the
+// given number of attempts.
+func deadLetterBackoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return deadLetterMaxBackoff
+	}
+	return min(deadLetterMinBackoff<<(attempts-1), deadLetterMaxBackoff)
+}
+
+// ReplayDeadLetter handles the dead letter's event again. If it succeeds the dead letter is
+// deleted, otherwise the failed attempt is recorded and the error returned.
+func ReplayDeadLetter(ctx context.Context, store DeadLetterStore, deadLetter DeadLetter) error {
+	var event models.Event
+	err := json.Unmarshal(deadLetter.Event, &event)
+	if err != nil {
+		handleErr := fmt.Errorf("%w: unmarshal event: %s", errInvalidRecord, err)
+		return recordFailedAttempt(ctx, store, deadLetter, handleErr)
+	}
+
+	h := &handler{store: store}
+	handleErr := h.processEvent(ctx, &event)
+	if handleErr != nil {
+		return recordFailedAttempt(ctx, store, deadLetter, handleErr)
+	}
+
+	err = store.DeleteDeadLetter(ctx, deadLetter.ID)
+	if err != nil {
+		return fmt.Errorf("delete replayed dead letter: %w", err)
+	}
+	return nil
+}
+
+func recordFailedAttempt(ctx context.Context, store DeadLetterStore, deadLetter DeadLetter, handleErr error) error {
+	now := time.Now()
+	deadLetter.Attempts++
+	deadLetter.Error = handleErr.Error()
+	deadLetter.LastAttemptAt = now.UnixMilli()
+	deadLetter.NextAttemptAt = now.Add(deadLetterBackoff(deadLetter.Attempts)).UnixMilli()
+	deadLetter.Retryable = !errors.Is(handleErr, errInvalidRecord) && deadLetter.Attempts < deadLetterMaxAttempts
+
+	err := store.UpdateDeadLetter(ctx, deadLetter)
+	if err != nil {
+		return errors.Join(handleErr, fmt.Errorf("update dead letter: %w", err))
+	}
+	return handleErr
+}
+
+// DeadLetterRetrier periodically retries dead letters that failed for reasons that may be
+// transient, such as the database being unavailable, backing off exponentially between
+// attempts for each dead letter.
+type DeadLetterRetrier struct {
+	store  DeadLetterStore
+	logger *slog.Logger
+}
+
+func NewDeadLetterRetrier(store DeadLetterStore, logger *slog.Logger) *DeadLetterRetrier {
+	return &DeadLetterRetrier{
+		store:  store,
+		logger: logger.With("component", "dead-letter-retrier"),
+	}
+}
+
+// Run retries dead letters until the context is cancelled.
+func (r *DeadLetterRetrier) Run(ctx context.Context) {
+	ticker := time.NewTicker(deadLetterRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.retryDue(ctx)
+		}
+	}
+}
+
+func (r *DeadLetterRetrier) retryDue(ctx context.Context) {
+	deadLetters, err := r.store.GetDueDeadLetters(ctx, time.Now().UnixMilli(), deadLetterRetryBatchSize)
+	if err != nil {
+		r.logger.Error("get dead letters due a retry", "error", err)
+		return
+	}
+
+	for _, deadLetter := range deadLetters {
+		err := ReplayDeadLetter(ctx, r.store, deadLetter)
+		if err != nil {
+			r.logger.Warn("retrying dead letter failed", "id", deadLetter.ID, "attempts", deadLetter.Attempts+1, "error", err)
+			continue
+		}
+		r.logger.Info("retried dead letter", "id", deadLetter.ID, "did", deadLetter.Did, "rkey", deadLetter.RKey)
+	}
+}
@@ -0,0 +1,107 @@
+package statusphere_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+// failingStore fails to store statuses, as when the database is unavailable.
+type failingStore struct {
+	*database.DB
+}
+
+func (s failingStore) CreateStatus(ctx context.Context, status statusphere.Status) error {
+	return errors.New("database is locked")
+}
+
+// storeDeadLetter stores a dead letter of the event and returns it as it was stored.
+func storeDeadLetter(t *testing.T, db *database.DB, event []byte) statusphere.DeadLetter {
+	t.Helper()
+
+	createdAt := time.Now().Add(-time.Minute).UnixMilli()
+	err := db.CreateDeadLetter(context.Background(), statusphere.DeadLetter{
+		Did:           consumerTestDID,
+		Event:         event,
+		Error:         "store status: database is locked",
+		Attempts:      1,
+		Retryable:     true,
+		CreatedAt:     createdAt,
+		LastAttemptAt: createdAt,
+		NextAttemptAt: createdAt,
+	})
+	if err != nil {
+		t.Fatalf("create dead letter: %s", err)
+	}
+	deadLetters, err := db.GetDeadLetters(context.Background(), 1)
+	if err != nil || len(deadLetters) != 1 {
+		t.Fatalf("get dead letter: %v", err)
+	}
+	return deadLetters[0]
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	event, err := json.Marshal(statusEvent(t, "3lsxb3n2bqs2a", "👍", time.Now()))
+	if err != nil {
+		t.Fatalf("marshal event: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		event []byte
+		// failStore makes storing the status fail
+		failStore bool
+		// expectStored is set if the replay should succeed and store the status
+		expectStored bool
+		// retryable is whether the dead letter should be retried again after failing
+		retryable bool
+	}{
+		{name: "succeeds", event: event, expectStored: true},
+		{name: "fails to store", event: event, failStore: true, retryable: true},
+		{name: "invalid event", event: []byte(`{"did":`), retryable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			deadLetter := storeDeadLetter(t, db, tt.event)
+
+			var store statusphere.DeadLetterStore = db
+			if tt.failStore {
+				store = failingStore{db}
+			}
+			err := statusphere.ReplayDeadLetter(ctx, store, deadLetter)
+
+			if tt.expectStored {
+				if err != nil {
+					t.Fatalf("replay dead letter: %s", err)
+				}
+				if _, err := db.GetDeadLetter(ctx, deadLetter.ID); !errors.Is(err, statusphere.ErrorNotFound) {
+					t.Fatalf("expected the dead letter to be deleted, got %v", err)
+				}
+				expectStatuses(t, db, "3lsxb3n2bqs2a 👍")
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected the replay to fail")
+			}
+			updated, getErr := db.GetDeadLetter(ctx, deadLetter.ID)
+			if getErr != nil {
+				t.Fatalf("get dead letter: %s", getErr)
+			}
+			if updated.Attempts != 2 || updated.Error != err.Error() || updated.Retryable != tt.retryable {
+				t.Fatalf("expected the failed attempt to be recorded, got %+v", updated)
+			}
+			if updated.LastAttemptAt <= deadLetter.LastAttemptAt || updated.NextAttemptAt <= updated.LastAttemptAt {
+				t.Fatalf("expected the next attempt to be backed off from the last, got %+v", updated)
+			}
+		})
+	}
+}
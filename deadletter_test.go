@@ -0,0 +1,74 @@
+package statusphere
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+func TestNewDeadLetter(t *testing.T) {
+	event := &models.Event{
+		Did:  "did:plc:test",
+		Kind: models.EventKindCommit,
+		Commit: &models.Commit{
+			Operation:  models.CommitOperationCreate,
+			Collection: statusCollection,
+			RKey:       "3lsxb3n2bqs2a",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "invalid record", err: fmt.Errorf("%w: unmarshal record: unexpected end of JSON input", errInvalidRecord), retryable: false},
+		{name: "wrapped invalid record", err: fmt.Errorf("handle event: %w", fmt.Errorf("%w: bad", errInvalidRecord)), retryable: false},
+		{name: "store failure", err: errors.New("store status: database is locked"), retryable: true},
+		{name: "blocked check failure", err: fmt.Errorf("check blocked DID: %w", errors.New("database is locked")), retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now()
+			deadLetter, err := newDeadLetter(event, tt.err)
+			if err != nil {
+				t.Fatalf("create dead letter: %s", err)
+			}
+			if deadLetter.Retryable != tt.retryable {
+				t.Errorf("expected retryable to be %t", tt.retryable)
+			}
+			if deadLetter.Did != event.Did || deadLetter.RKey != event.Commit.RKey || deadLetter.Error != tt.err.Error() || deadLetter.Attempts != 1 {
+				t.Errorf("expected the dead letter to describe the event and error, got %+v", deadLetter)
+			}
+			if next := time.UnixMilli(deadLetter.NextAttemptAt).Sub(before); next < deadLetterMinBackoff-time.Second || next > deadLetterMinBackoff+time.Second {
+				t.Errorf("expected the next attempt to be in %s, got %s", deadLetterMinBackoff, next)
+			}
+		})
+	}
+}
+
+func TestDeadLetterBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: time.Second * 30},
+		{attempts: 2, expected: time.Minute},
+		{attempts: 3, expected: time.Minute * 2},
+		{attempts: 7, expected: time.Minute * 32},
+		{attempts: 8, expected: time.Hour},
+		{attempts: 16, expected: time.Hour},
+		{attempts: 17, expected: time.Hour},
+		{attempts: 1000, expected: time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := deadLetterBackoff(tt.attempts); got != tt.expected {
+			t.Errorf("deadLetterBackoff(%d) = %s, expected %s", tt.attempts, got, tt.expected)
+		}
+	}
+}
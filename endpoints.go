@@ -0,0 +1,128 @@
+package statusphere
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// a connection that stays up for this long is considered healthy and resets the
+	// backoff, even if it then fails
+	healthyConnectionDuration = time.Second * 30
+
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+type endpoint struct {
+	url                 string
+	consecutiveFailures int
+	lastFailure         time.Time
+}
+
+// endpointPool tracks the health of each Jetstream endpoint and decides which one
+// to connect to next.
+type endpointPool struct {
+	mu        sync.Mutex
+	endpoints []*endpoint
+	current   int
+	// failures is the number of connections that have failed in a row across all
+	// endpoints and is used to work out how long to back off for
+	failures int
+}
+
+func newEndpointPool(urls []string) *endpointPool {
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, url := range urls {
+		endpoints = append(endpoints, &endpoint{url: url})
+	}
+	return &endpointPool{endpoints: endpoints}
+}
+
+// Use makes the endpoint with the URL current, reporting whether there is one.
+func (p *endpointPool) Use(url string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, endpoint := range p.endpoints {
+		if endpoint.url == url {
+			p.current = i
+			return true
+		}
+	}
+	return false
+}
+
+// Current returns the URL of the endpoint that should be connected to.
+func (p *endpointPool) Current() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.endpoints[p.current].url
+}
+
+// Succeeded records that a connection to the current endpoint was healthy.
+func (p *endpointPool) Succeeded() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.endpoints[p.current].consecutiveFailures = 0
+	p.failures = 0
+}
+
+// Failed records that the connection to the current endpoint failed after being
+// connected for the given duration and rotates to the healthiest of the other
+// endpoints. It reports whether a different endpoint was chosen.
+func (p *endpointPool) Failed(connectedFor time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if connectedFor >= healthyConnectionDuration {
+		p.endpoints[p.current].consecutiveFailures = 0
+		p.failures = 0
+	}
+
+	failed := p.endpoints[p.current]
+	failed.consecutiveFailures++
+	failed.lastFailure = time.Now()
+	p.failures++
+
+	// starting from the endpoint after the one that failed, pick the one that has failed
+	// the fewest times in a row, preferring the one that failed longest ago on a tie
+	next := p.current
+	for i := 1; i <= len(p.endpoints); i++ {
+		idx := (p.current + i) % len(p.endpoints)
+		candidate := p.endpoints[idx]
+		best := p.endpoints[next]
+		if next == p.current ||
+			candidate.consecutiveFailures < best.consecutiveFailures ||
+			(candidate.consecutiveFailures == best.consecutiveFailures && candidate.lastFailure.Before(best.lastFailure)) {
+			next = idx
+		}
+	}
+
+	rotated := next != p.current
+	p.current = next
+	return rotated
+}
+
+// RetryDelay returns how long to wait before connecting again. It backs off
+// exponentially with the number of failures in a row, with jitter so that several
+// instances don't all reconnect at the same moment.
+func (p *endpointPool) RetryDelay() time.Duration {
+	p.mu.Lock()
+	failures := p.failures
+	p.mu.Unlock()
+
+	if failures == 0 {
+		return 0
+	}
+
+	delay := maxRetryDelay
+	// cap the shift so it can't overflow
+	if failures <= 16 {
+		delay = min(minRetryDelay<<(failures-1), maxRetryDelay)
+	}
+
+	// equal jitter: half the delay is fixed and the other half random
+	half := delay / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,153 @@
+package statusphere
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEndpointPoolFailed(t *testing.T) {
+	// each step is a connection to the current endpoint either succeeding or failing after
+	// being connected for connectedFor
+	type step struct {
+		succeeded    bool
+		connectedFor time.Duration
+		// expected is the endpoint that's current after the step
+		expected string
+		rotated  bool
+	}
+	tests := []struct {
+		name  string
+		urls  []string
+		steps []step
+	}{
+		{
+			name: "single endpoint stays current",
+			urls: []string{"a"},
+			steps: []step{
+				{expected: "a"},
+				{expected: "a"},
+			},
+		},
+		{
+			name: "rotates to the next endpoint",
+			urls: []string{"a", "b", "c"},
+			steps: []step{
+				{expected: "b", rotated: true},
+				{expected: "c", rotated: true},
+				{expected: "a", rotated: true},
+			},
+		},
+		{
+			name: "prefers the endpoint that has failed the fewest times in a row",
+			urls: []string{"a", "b", "c"},
+			steps: []step{
+				// a fails, then b, then c, then a again, so a has failed twice
+				{expected: "b", rotated: true},
+				{expected: "c", rotated: true},
+				{expected: "a", rotated: true},
+				{expected: "b", rotated: true},
+				// b has now failed twice too, so c is the only one that's failed once
+				{expected: "c", rotated: true},
+				// c has now failed twice, so all have, and a failed longest ago
+				{expected: "a", rotated: true},
+			},
+		},
+		{
+			name: "tie is broken by the endpoint that failed longest ago",
+			urls: []string{"a", "b", "c"},
+			steps: []step{
+				// b and c have never failed, so b is chosen as it comes first after a
+				{expected: "b", rotated: true},
+				// c has never failed, so it's preferred to a
+				{expected: "c", rotated: true},
+				// a and b have failed once each, a longer ago
+				{expected: "a", rotated: true},
+			},
+		},
+		{
+			name: "success resets an endpoint's failures",
+			urls: []string{"a", "b"},
+			steps: []step{
+				{expected: "b", rotated: true},
+				{expected: "a", rotated: true},
+				{expected: "b", rotated: true},
+				// b failed once but has now succeeded, so when it fails again it's still
+				// failed fewer times in a row than a and is retried
+				{succeeded: true, expected: "b"},
+				{expected: "b"},
+				// both have failed twice in a row now, and a longer ago
+				{expected: "a", rotated: true},
+			},
+		},
+		{
+			name: "long connection counts as healthy before failing",
+			urls: []string{"a", "b"},
+			steps: []step{
+				{expected: "b", rotated: true},
+				{expected: "a", rotated: true},
+				// a failed after being healthy, which resets its failures so it's failed
+				// once, the same as b, which failed longer ago
+				{connectedFor: healthyConnectionDuration, expected: "b", rotated: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newEndpointPool(tt.urls)
+			for i, step := range tt.steps {
+				if step.succeeded {
+					pool.Succeeded()
+				} else {
+					if rotated := pool.Failed(step.connectedFor); rotated != step.rotated {
+						t.Fatalf("step %d: expected rotated to be %t", i, step.rotated)
+					}
+					// make sure failures are ordered even on a coarse clock
+					time.Sleep(time.Millisecond)
+				}
+				if current := pool.Current(); current != step.expected {
+					t.Fatalf("step %d: expected %s to be current, got %s", i, step.expected, current)
+				}
+			}
+		})
+	}
+}
+
+func TestEndpointPoolRetryDelay(t *testing.T) {
+	pool := newEndpointPool([]string{"a", "b"})
+	if delay := pool.RetryDelay(); delay != 0 {
+		t.Fatalf("expected no delay before any failures, got %s", delay)
+	}
+
+	tests := []struct {
+		failures int
+		// the delay before jitter, of which at least half is always waited
+		backoff time.Duration
+	}{
+		{failures: 1, backoff: time.Second},
+		{failures: 2, backoff: time.Second * 2},
+		{failures: 3, backoff: time.Second * 4},
+		{failures: 6, backoff: time.Second * 32},
+		{failures: 7, backoff: maxRetryDelay},
+		{failures: 20, backoff: maxRetryDelay},
+		{failures: 100, backoff: maxRetryDelay},
+	}
+
+	failures := 0
+	for _, tt := range tests {
+		for ; failures < tt.failures; failures++ {
+			pool.Failed(0)
+		}
+		for range 100 {
+			delay := pool.RetryDelay()
+			if delay < tt.backoff/2 || delay > tt.backoff {
+				t.Fatalf("after %d failures expected a delay between %s and %s, got %s", tt.failures, tt.backoff/2, tt.backoff, delay)
+			}
+		}
+	}
+
+	pool.Succeeded()
+	if delay := pool.RetryDelay(); delay != 0 {
+		t.Fatalf("expected no delay after a success, got %s", delay)
+	}
+}
@@ -0,0 +1,331 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The tables that can be exported and imported.
+const (
+	ExportTableStatus  = "status"
+	ExportTableProfile = "profile"
+)
+
+// The formats that tables can be exported and imported in. JSONL is one JSON object per
+// line, and CSV has a header row naming the columns.
+const (
+	ExportFormatJSONL = "jsonl"
+	ExportFormatCSV   = "csv"
+)
+
+// importBatchSize is how many rows are stored in each transaction when importing.
+const importBatchSize = 500
+
+// ExportFilter limits which rows are exported. Zero values don't filter.
+type ExportFilter struct {
+	// Dids only exports rows for these accounts.
+	Dids []string
+	// Since and Until only export statuses created in [Since, Until), in unix milliseconds.
+	Since int64
+	Until int64
+}
+
+type ExportStore interface {
+	EachStatus(ctx context.Context, filter ExportFilter, fn func(status Status) error) error
+	EachProfile(ctx context.Context, filter ExportFilter, fn func(profile UserProfile) error) error
+}
+
+type ImportStore interface {
+	CreateStatuses(ctx context.Context, statuses []Status) error
+	CreateProfiles(ctx context.Context, profiles []UserProfile) error
+	IsDIDBlocked(ctx context.Context, did string) (bool, error)
+}
+
+// rowCodec converts rows of a table to and from CSV records. JSONL uses the row's JSON
+// encoding.
+type rowCodec[T any] struct {
+	header []string
+	// optional columns can be missing from files being imported, such as columns that were
+	// added after the file was exported
+	optional []string
+	toCSV    func(row T) []string
+	// fromCSV is given a function that returns the value of a column in the record
+	fromCSV  func(field func(column string) string) (T, error)
+	validate func(row T) error
+}
+
+var statusCodec = rowCodec[Status]{
+	header:   []string{"uri", "did", "status", "createdAt", "indexedAt", "cid", "rev"},
+	optional: []string{"cid", "rev"},
+	toCSV: func(status Status) []string {
+		return []string{status.URI, status.Did, status.Status, strconv.FormatInt(status.CreatedAt, 10), strconv.FormatInt(status.IndexedAt, 10), status.CID, status.Rev}
+	},
+	fromCSV: func(field func(column string) string) (Status, error) {
+		createdAt, err := strconv.ParseInt(field("createdAt"), 10, 64)
+		if err != nil {
+			return Status{}, fmt.Errorf("invalid createdAt: %w", err)
+		}
+		indexedAt, err := strconv.ParseInt(field("indexedAt"), 10, 64)
+		if err != nil {
+			return Status{}, fmt.Errorf("invalid indexedAt: %w", err)
+		}
+		return Status{URI: field("uri"), Did: field("did"), Status: field("status"), CreatedAt: createdAt, IndexedAt: indexedAt, CID: field("cid"), Rev: field("rev")}, nil
+	},
+	validate: func(status Status) error {
+		if status.URI == "" || status.Did == "" {
+			return fmt.Errorf("missing uri or did")
+		}
+		return nil
+	},
+}
+
+var profileCodec = rowCodec[UserProfile]{
+	header: []string{"did", "handle", "displayName"},
+	toCSV: func(profile UserProfile) []string {
+		return []string{profile.Did, profile.Handle, profile.DisplayName}
+	},
+	fromCSV: func(field func(column string) string) (UserProfile, error) {
+		return UserProfile{Did: field("did"), Handle: field("handle"), DisplayName: field("displayName")}, nil
+	},
+	validate: func(profile UserProfile) error {
+		if profile.Did == "" {
+			return fmt.Errorf("missing did")
+		}
+		return nil
+	},
+}
+
+// ParseExportFilter builds a filter from DIDs, ignoring empty ones, and RFC 3339 times,
+// either of which can be empty to not filter on it.
+func ParseExportFilter(dids []string, since, until string) (ExportFilter, error) {
+	var filter ExportFilter
+	for _, did := range dids {
+		if did = strings.TrimSpace(did); did != "" {
+			filter.Dids = append(filter.Dids, did)
+		}
+	}
+
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("invalid since: %w", err)
+		}
+		filter.Since = t.UnixMilli()
+	}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("invalid until: %w", err)
+		}
+		filter.Until = t.UnixMilli()
+	}
+	return filter, nil
+}
+
+// ExportContentType returns the MIME type of an export in the format.
+func ExportContentType(format string) string {
+	if format == ExportFormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// ValidateExport checks that the table and format can be exported, so that callers can
+// report a bad request before they start writing a response.
+func ValidateExport(table, format string) error {
+	if table != ExportTableStatus && table != ExportTableProfile {
+		return fmt.Errorf("unknown table %q", table)
+	}
+	if format != ExportFormatJSONL && format != ExportFormatCSV {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
+
+// Export writes the rows of the table that match the filter to w in the format, returning
+// how many were written. Rows are streamed from the store so that large tables don't have
+// to fit in memory.
+func Export(ctx context.Context, store ExportStore, w io.Writer, table, format string, filter ExportFilter) (int, error) {
+	if err := ValidateExport(table, format); err != nil {
+		return 0, err
+	}
+
+	switch table {
+	case ExportTableStatus:
+		return exportRows(w, format, statusCodec, func(fn func(Status) error) error {
+			return store.EachStatus(ctx, filter, fn)
+		})
+	default:
+		return exportRows(w, format, profileCodec, func(fn func(UserProfile) error) error {
+			return store.EachProfile(ctx, filter, fn)
+		})
+	}
+}
+
+// Import stores the rows of the table read from r in the format, returning how many were
+// stored. Rows are stored in the same way as when they're consumed, so importing the same
+// export twice is safe: a status that's already stored is only replaced by a copy with a
+// different CID that isn't from an older revision of the repo, and a profile that's already
+// stored is left as it is. Statuses from blocked accounts are skipped.
+func Import(ctx context.Context, store ImportStore, r io.Reader, table, format string) (int, error) {
+	if err := ValidateExport(table, format); err != nil {
+		return 0, err
+	}
+
+	switch table {
+	case ExportTableStatus:
+		// whether each account is blocked, so that it's only looked up once per import
+		blocked := make(map[string]bool)
+		return importRows(r, format, statusCodec, func(statuses []Status) (int, error) {
+			allowed := make([]Status, 0, len(statuses))
+			for _, status := range statuses {
+				isBlocked, ok := blocked[status.Did]
+				if !ok {
+					var err error
+					isBlocked, err = store.IsDIDBlocked(ctx, status.Did)
+					if err != nil {
+						return 0, fmt.Errorf("check blocked DID: %w", err)
+					}
+					blocked[status.Did] = isBlocked
+				}
+				if !isBlocked {
+					allowed = append(allowed, status)
+				}
+			}
+			if len(allowed) == 0 {
+				return 0, nil
+			}
+			return len(allowed), store.CreateStatuses(ctx, allowed)
+		})
+	default:
+		return importRows(r, format, profileCodec, func(profiles []UserProfile) (int, error) {
+			return len(profiles), store.CreateProfiles(ctx, profiles)
+		})
+	}
+}
+
+func exportRows[T any](w io.Writer, format string, codec rowCodec[T], each func(fn func(T) error) error) (int, error) {
+	count := 0
+
+	if format == ExportFormatCSV {
+		cw := csv.NewWriter(w)
+		if err := cw.Write(codec.header); err != nil {
+			return 0, fmt.Errorf("write header: %w", err)
+		}
+		err := each(func(row T) error {
+			count++
+			return cw.Write(codec.toCSV(row))
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+		return count, err
+	}
+
+	enc := json.NewEncoder(w)
+	err := each(func(row T) error {
+		count++
+		return enc.Encode(row)
+	})
+	return count, err
+}
+
+// importRows reads rows and passes them to store in batches, which returns how many of them
+// it stored.
+func importRows[T any](r io.Reader, format string, codec rowCodec[T], store func([]T) (int, error)) (int, error) {
+	next, err := rowReader(r, format, codec)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := make([]T, 0, importBatchSize)
+	read := 0
+	count := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		stored, err := store(batch)
+		if err != nil {
+			return err
+		}
+		count += stored
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		read++
+		if err == nil {
+			err = codec.validate(row)
+		}
+		if err != nil {
+			return count, fmt.Errorf("row %d: %w", read, err)
+		}
+
+		batch = append(batch, row)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	return count, flush()
+}
+
+// rowReader returns a function that reads the next row from r, returning io.EOF when there
+// are no more.
+func rowReader[T any](r io.Reader, format string, codec rowCodec[T]) (func() (T, error), error) {
+	if format == ExportFormatCSV {
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("read header: %w", err)
+		}
+		columns := make(map[string]int, len(header))
+		for i, column := range header {
+			columns[column] = i
+		}
+		for _, column := range codec.header {
+			if _, ok := columns[column]; !ok && !slices.Contains(codec.optional, column) {
+				return nil, fmt.Errorf("missing column %q", column)
+			}
+		}
+
+		return func() (T, error) {
+			record, err := cr.Read()
+			if err != nil {
+				var zero T
+				return zero, err
+			}
+			return codec.fromCSV(func(column string) string {
+				i, ok := columns[column]
+				if !ok {
+					return ""
+				}
+				return record[i]
+			})
+		}, nil
+	}
+
+	dec := json.NewDecoder(r)
+	return func() (T, error) {
+		var row T
+		err := dec.Decode(&row)
+		return row, err
+	}, nil
+}
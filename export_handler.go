@@ -0,0 +1,87 @@
+package statusphere
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// exportMiddleware lets scripts download exports with the admin token, either as a bearer
+// token or as the password of basic auth, as well as letting through admins who are logged
+// in. The token only works for downloading exports, the rest of the admin pages need an
+// admin to be logged in.
+func (s *Server) exportMiddleware(next func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	admin := s.adminMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.hasAdminToken(r) {
+			next(w, r)
+			return
+		}
+		// requests from scripts are told to use the token rather than sent to log in
+		if s.adminToken != "" && (len(s.adminDIDs) == 0 || r.Header.Get("Authorization") != "") {
+			w.Header().Add("WWW-Authenticate", "Bearer")
+			w.Header().Add("WWW-Authenticate", `Basic realm="statusphere admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		admin(w, r)
+	}
+}
+
+// hasAdminToken reports whether the request has the admin token, either as a bearer token
+// or as the password of basic auth.
+func (s *Server) hasAdminToken(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// HandleExport downloads a table as JSONL or CSV. The table, format, did (which can be
+// repeated or comma separated), since and until query parameters work like the flags of
+// the export command.
+func (s *Server) HandleExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	table := query.Get("table")
+	if table == "" {
+		table = ExportTableStatus
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = ExportFormatJSONL
+	}
+	if err := ValidateExport(table, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var dids []string
+	for _, did := range query["did"] {
+		dids = append(dids, strings.Split(did, ",")...)
+	}
+	filter, err := ParseExportFilter(dids, query.Get("since"), query.Get("until"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", table+"."+format))
+
+	// rows are written as they're read, so once the first has been written it's too late to
+	// send an error status and the download is cut short instead
+	count, err := Export(r.Context(), s.store, w, table, format, filter)
+	if err != nil {
+		slog.Error("export", "table", table, "rows", count, "error", err)
+		if count == 0 {
+			http.Error(w, "failed to export", http.StatusInternalServerError)
+		}
+		return
+	}
+}
@@ -0,0 +1,305 @@
+package statusphere_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+var exportTestStart = time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+
+// exportTestStatus returns a status created the given number of hours after exportTestStart.
+func exportTestStatus(did, rkey, status string, hours int) statusphere.Status {
+	createdAt := exportTestStart.Add(time.Hour * time.Duration(hours)).UnixMilli()
+	return statusphere.Status{
+		URI:       "at://" + did + "/xyz.statusphere.status/" + rkey,
+		Did:       did,
+		Status:    status,
+		CreatedAt: createdAt,
+		IndexedAt: createdAt,
+		CID:       "bafyreib2rxk3rybk3aobmv5cjuql3bm2twh4jo5uxgf5ak3oltptnoc2vi",
+		Rev:       rkey,
+	}
+}
+
+// newExportTestDB returns a database with statuses and profiles for two accounts, one of
+// which has a display name that has to be quoted in CSV.
+func newExportTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db := newTestDB(t)
+	ctx := context.Background()
+	profiles := []statusphere.UserProfile{
+		{Did: "did:plc:alice", Handle: "alice.test", DisplayName: `Alice "Al", of the "test"`},
+		{Did: "did:plc:bob", Handle: "bob.test", DisplayName: "Bob\nSmith"},
+	}
+	if err := db.CreateProfiles(ctx, profiles); err != nil {
+		t.Fatalf("create profiles: %s", err)
+	}
+	statuses := []statusphere.Status{
+		exportTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", 0),
+		exportTestStatus("did:plc:bob", "3lsxb3n2bqs2b", "🤔", 1),
+		exportTestStatus("did:plc:alice", "3lsxb3n2bqs2c", "😊", 2),
+	}
+	if err := db.CreateStatuses(ctx, statuses); err != nil {
+		t.Fatalf("create statuses: %s", err)
+	}
+	return db
+}
+
+func exportToString(t *testing.T, db *database.DB, table, format string, filter statusphere.ExportFilter) (string, int) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	count, err := statusphere.Export(context.Background(), db, &buf, table, format, filter)
+	if err != nil {
+		t.Fatalf("export %s as %s: %s", table, format, err)
+	}
+	return buf.String(), count
+}
+
+// TestExportImportRoundTrip exports each table in each format, imports it into an empty
+// database and checks exporting that gives the same rows. Importing it again changes nothing.
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, table := range []string{statusphere.ExportTableStatus, statusphere.ExportTableProfile} {
+		for _, format := range []string{statusphere.ExportFormatJSONL, statusphere.ExportFormatCSV} {
+			t.Run(table+" "+format, func(t *testing.T) {
+				ctx := context.Background()
+				exported, count := exportToString(t, newExportTestDB(t), table, format, statusphere.ExportFilter{})
+				expectedRows := map[string]int{statusphere.ExportTableStatus: 3, statusphere.ExportTableProfile: 2}[table]
+				if count != expectedRows {
+					t.Fatalf("expected %d rows to be exported, got %d", expectedRows, count)
+				}
+
+				imported := newTestDB(t)
+				for i := range 2 {
+					count, err := statusphere.Import(ctx, imported, strings.NewReader(exported), table, format)
+					if err != nil {
+						t.Fatalf("import %d: %s", i+1, err)
+					}
+					if count != expectedRows {
+						t.Fatalf("import %d: expected %d rows to be imported, got %d", i+1, expectedRows, count)
+					}
+
+					reexported, _ := exportToString(t, imported, table, format, statusphere.ExportFilter{})
+					if reexported != exported {
+						t.Fatalf("import %d: expected the imported rows to export the same\nexported:\n%s\nimported:\n%s", i+1, exported, reexported)
+					}
+				}
+
+				if table == statusphere.ExportTableStatus {
+					counts, err := imported.GetDailyStatusCounts(ctx, "2026-03-10", "2026-03-10")
+					if err != nil {
+						t.Fatalf("get daily status counts: %s", err)
+					}
+					total := 0
+					for _, count := range counts {
+						total += count.Count
+					}
+					if total != expectedRows {
+						t.Fatalf("expected importing twice to count each status once, got %d", total)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	db := newExportTestDB(t)
+
+	tests := []struct {
+		name  string
+		table string
+		dids  []string
+		since string
+		until string
+		// expected are the rkeys of the statuses or the DIDs of the profiles exported
+		expected []string
+	}{
+		{name: "no filter", table: statusphere.ExportTableStatus, expected: []string{"3lsxb3n2bqs2a", "3lsxb3n2bqs2b", "3lsxb3n2bqs2c"}},
+		{name: "did", table: statusphere.ExportTableStatus, dids: []string{"did:plc:alice", ""}, expected: []string{"3lsxb3n2bqs2a", "3lsxb3n2bqs2c"}},
+		{name: "since is inclusive", table: statusphere.ExportTableStatus, since: "2026-03-10T13:00:00Z", expected: []string{"3lsxb3n2bqs2b", "3lsxb3n2bqs2c"}},
+		{name: "until is exclusive", table: statusphere.ExportTableStatus, until: "2026-03-10T13:00:00Z", expected: []string{"3lsxb3n2bqs2a"}},
+		{name: "did and time range", table: statusphere.ExportTableStatus, dids: []string{"did:plc:alice"}, since: "2026-03-10T12:30:00Z", until: "2026-03-10T15:00:00Z", expected: []string{"3lsxb3n2bqs2c"}},
+		{name: "nothing in range", table: statusphere.ExportTableStatus, since: "2026-03-11T00:00:00Z"},
+		{name: "profiles by did", table: statusphere.ExportTableProfile, dids: []string{"did:plc:bob"}, expected: []string{"did:plc:bob"}},
+		{name: "profiles with statuses in range", table: statusphere.ExportTableProfile, since: "2026-03-10T13:00:00Z", until: "2026-03-10T14:00:00Z", expected: []string{"did:plc:bob"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := statusphere.ParseExportFilter(tt.dids, tt.since, tt.until)
+			if err != nil {
+				t.Fatalf("parse filter: %s", err)
+			}
+			exported, count := exportToString(t, db, tt.table, statusphere.ExportFormatCSV, filter)
+			if count != len(tt.expected) {
+				t.Fatalf("expected %d rows, got %d:\n%s", len(tt.expected), count, exported)
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(exported, want) {
+					t.Errorf("expected %s to be exported", want)
+				}
+			}
+		})
+	}
+
+	if _, err := statusphere.ParseExportFilter(nil, "yesterday", ""); err == nil {
+		t.Fatal("expected an invalid since to be an error")
+	}
+}
+
+func TestImportInvalidCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		csv   string
+		// expectedErr is part of the error expected, or empty if the import should succeed
+		expectedErr string
+		// expected is how many rows should be imported
+		expected int
+	}{
+		{
+			name:        "missing column",
+			table:       statusphere.ExportTableStatus,
+			csv:         "uri,did,status,createdAt\nat://did:plc:alice/xyz.statusphere.status/3lsxb3n2bqs2a,did:plc:alice,👍,1741608000000\n",
+			expectedErr: `missing column "indexedAt"`,
+		},
+		{
+			name:     "missing optional columns",
+			table:    statusphere.ExportTableStatus,
+			csv:      "uri,did,status,createdAt,indexedAt\nat://did:plc:alice/xyz.statusphere.status/3lsxb3n2bqs2a,did:plc:alice,👍,1741608000000,1741608000000\n",
+			expected: 1,
+		},
+		{
+			name:     "columns in a different order",
+			table:    statusphere.ExportTableProfile,
+			csv:      "handle,did,displayName\nalice.test,did:plc:alice,Alice\n",
+			expected: 1,
+		},
+		{
+			name:        "invalid number",
+			table:       statusphere.ExportTableStatus,
+			csv:         "uri,did,status,createdAt,indexedAt\nat://did:plc:alice/xyz.statusphere.status/3lsxb3n2bqs2a,did:plc:alice,👍,yesterday,1741608000000\n",
+			expectedErr: "row 1: invalid createdAt",
+		},
+		{
+			name:        "missing did",
+			table:       statusphere.ExportTableProfile,
+			csv:         "did,handle,displayName\ndid:plc:alice,alice.test,Alice\n,bob.test,Bob\n",
+			expectedErr: "row 2: missing did",
+		},
+		{
+			name:        "wrong number of fields",
+			table:       statusphere.ExportTableProfile,
+			csv:         "did,handle,displayName\ndid:plc:alice,alice.test\n",
+			expectedErr: "wrong number of fields",
+		},
+		{
+			name:        "empty",
+			table:       statusphere.ExportTableProfile,
+			expectedErr: "read header",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := statusphere.Import(context.Background(), newTestDB(t), strings.NewReader(tt.csv), tt.table, statusphere.ExportFormatCSV)
+			if tt.expectedErr == "" && err != nil {
+				t.Fatalf("expected the import to succeed, got %s", err)
+			}
+			if tt.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tt.expectedErr)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.expectedErr, err)
+			}
+			if count != tt.expected {
+				t.Fatalf("expected %d rows to be imported, got %d", tt.expected, count)
+			}
+		})
+	}
+}
+
+// TestImportSkipsBlockedAccounts imports statuses into a database where one of the accounts
+// is blocked, which are skipped as they are when consumed.
+func TestImportSkipsBlockedAccounts(t *testing.T) {
+	ctx := context.Background()
+	exported, _ := exportToString(t, newExportTestDB(t), statusphere.ExportTableStatus, statusphere.ExportFormatJSONL, statusphere.ExportFilter{})
+
+	db := newTestDB(t)
+	if err := db.BlockDID(ctx, "did:plc:alice", "spam", "test"); err != nil {
+		t.Fatalf("block DID: %s", err)
+	}
+	count, err := statusphere.Import(ctx, db, strings.NewReader(exported), statusphere.ExportTableStatus, statusphere.ExportFormatJSONL)
+	if err != nil {
+		t.Fatalf("import: %s", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected only the status from the account that isn't blocked to be imported, got %d", count)
+	}
+	expectStatuses(t, db, "3lsxb3n2bqs2b 🤔")
+}
+
+// TestExportDownload downloads an export as a logged in admin and with the admin token, and
+// checks that other requests can't.
+func TestExportDownload(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "test-admin-token")
+	app := testapp.NewLoggedIn(t)
+	if err := app.DB.CreateStatus(context.Background(), exportTestStatus("did:plc:alice", "3lsxb3n2bqs2a", "👍", 0)); err != nil {
+		t.Fatalf("create status: %s", err)
+	}
+
+	resp, body := app.Get(t, "/admin/export?format=csv")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the admin to download the export, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Fatalf("expected a CSV content type, got %s", contentType)
+	}
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != `attachment; filename="status.csv"` {
+		t.Fatalf("expected the export to download as status.csv, got %s", disposition)
+	}
+	if !strings.HasPrefix(body, "uri,did,status,createdAt") || !strings.Contains(body, "3lsxb3n2bqs2a") {
+		t.Fatalf("expected the status as CSV, got %s", body)
+	}
+
+	download := func(query, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, app.URL+"/admin/export"+query, nil)
+		if err != nil {
+			t.Fatalf("create request: %s", err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("download export: %s", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp = download("?table=profile", "test-admin-token")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the admin token to download the export, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Fatalf("expected a JSON Lines content type, got %s", contentType)
+	}
+	if resp := download("", "wrong-token"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the wrong token to be unauthorized, got %d", resp.StatusCode)
+	}
+	if resp := download("?format=xml", "test-admin-token"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an unknown format to be a bad request, got %d", resp.StatusCode)
+	}
+	if resp := download("?since=yesterday", "test-admin-token"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid since to be a bad request, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,320 @@
+package statusphere
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+)
+
+const statusCollection = "xyz.statusphere.status"
+
+// firehoseConsumer consumes commits directly from a relay's com.atproto.sync.subscribeRepos
+// firehose rather than from Jetstream. Each commit that contains statuses has its signature
+// verified against the signing key in the account's DID document and its records checked
+// against the signed repo tree, including that deleted records are absent from it, before
+// being passed to the same handler Jetstream events are.
+type firehoseConsumer struct {
+	endpoints *endpointPool
+	directory identity.Directory
+	handler   *handler
+	logger    *slog.Logger
+	// seq is the sequence number of the last event handled from the current relay, which
+	// is only moved past a commit once its statuses are stored. Sequence numbers are
+	// specific to a relay so it's reset when changing relay.
+	seq atomic.Int64
+	// consuming is set while Consume is running
+	consuming atomic.Bool
+}
+
+// FirehoseOption configures a firehose consumer.
+type FirehoseOption func(c *firehoseConsumer)
+
+// WithFirehoseStartCursor makes the consumer start from the sequence number another consumer
+// reached on the relay, such as the one saved by the process that was consuming before this
+// one, rather than from the relay's live stream. As sequence numbers are specific to a relay,
+// it's ignored if the relay isn't one of the consumer's.
+func WithFirehoseStartCursor(relay string, seq int64) FirehoseOption {
+	return func(c *firehoseConsumer) {
+		if seq > 0 && c.endpoints.Use(relay) {
+			c.seq.Store(seq)
+		}
+	}
+}
+
+// NewFirehoseConsumer creates a consumer that reads from the firehose of the given relay
+// hosts (eg wss://bsky.network). Only one relay is connected to at a time and if the
+// connection fails, the next healthiest relay is used. The directory is used to look up
+// the signing keys of accounts.
+func NewFirehoseConsumer(relayAddrs []string, logger *slog.Logger, store HandlerStore, directory identity.Directory, opts ...FirehoseOption) (*firehoseConsumer, error) {
+	if len(relayAddrs) == 0 {
+		return nil, fmt.Errorf("no relay addresses provided")
+	}
+
+	c := &firehoseConsumer{
+		endpoints: newEndpointPool(relayAddrs),
+		directory: directory,
+		handler: &handler{
+			store: store,
+		},
+		logger: logger.With("component", "firehose-consumer"),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Consume connects to the current relay and handles commits until the context is cancelled
+// or the connection fails. If it fails, the next call to Consume will use a different relay
+// if one is available, so callers should retry after waiting for RetryDelay.
+func (c *firehoseConsumer) Consume(ctx context.Context) error {
+	c.consuming.Store(true)
+	defer c.consuming.Store(false)
+
+	relay := c.endpoints.Current()
+	subscribeURL, err := c.subscribeURL(relay)
+	if err != nil {
+		return fmt.Errorf("invalid relay address %q: %w", relay, err)
+	}
+
+	c.logger.Info("connecting to firehose", "url", subscribeURL)
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, subscribeURL, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		c.failed(relay, 0)
+		return fmt.Errorf("dial firehose %s: %w", relay, err)
+	}
+
+	callbacks := &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			if err := c.handleCommit(ctx, evt); err != nil {
+				return err
+			}
+			// commits are handled one at a time, so every commit before this one has been too
+			c.seq.Store(evt.Seq)
+			return nil
+		},
+		Error: func(evt *events.ErrorFrame) error {
+			return fmt.Errorf("error frame from relay: %s: %s", evt.Error, evt.Message)
+		},
+	}
+	scheduler := sequential.NewScheduler("statusphere-firehose", callbacks.EventHandler)
+
+	connectedAt := time.Now()
+	err = events.HandleRepoStream(ctx, con, scheduler, c.logger)
+	if err != nil && ctx.Err() == nil {
+		c.failed(relay, time.Since(connectedAt))
+		return fmt.Errorf("handle firehose from %s: %w", relay, err)
+	}
+	c.endpoints.Succeeded()
+
+	c.logger.Info("stopping consume")
+	return nil
+}
+
+// RetryDelay is how long to wait before calling Consume again after it failed.
+func (c *firehoseConsumer) RetryDelay() time.Duration {
+	return c.endpoints.RetryDelay()
+}
+
+// Status returns the relay being consumed from and the sequence number of the last event.
+func (c *firehoseConsumer) Status() ConsumerStatus {
+	return ConsumerStatus{
+		Name:      "firehose",
+		Endpoint:  c.endpoints.Current(),
+		Cursor:    c.seq.Load(),
+		Connected: c.consuming.Load(),
+	}
+}
+
+func (c *firehoseConsumer) failed(relay string, connectedFor time.Duration) {
+	if c.endpoints.Failed(connectedFor) {
+		c.seq.Store(0)
+		c.logger.Warn("rotating relay", "failed", relay, "next", c.endpoints.Current())
+	}
+}
+
+func (c *firehoseConsumer) subscribeURL(relay string) (string, error) {
+	u, err := url.Parse(relay)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	if seq := c.seq.Load(); seq > 0 {
+		u.RawQuery = url.Values{"cursor": []string{fmt.Sprint(seq)}}.Encode()
+	}
+	return u.String(), nil
+}
+
+// handleCommit verifies commits that contain statuses and passes each status operation to
+// the handler. Commits that fail verification are logged and skipped rather than returned
+// as errors, as one bad repo shouldn't stop the whole stream.
+func (c *firehoseConsumer) handleCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	var ops []*comatproto.SyncSubscribeRepos_RepoOp
+	for _, op := range evt.Ops {
+		if strings.HasPrefix(op.Path, statusCollection+"/") {
+			ops = append(ops, op)
+		}
+	}
+	// most commits are for other collections, so avoid the cost of verifying them
+	if len(ops) == 0 {
+		return nil
+	}
+
+	logger := c.logger.With("did", evt.Repo, "seq", evt.Seq, "rev", evt.Rev)
+
+	commitRepo, err := c.verifyCommit(ctx, evt)
+	if err != nil {
+		logger.Warn("skipping commit that failed verification", "error", err)
+		return nil
+	}
+
+	eventTime, err := syntax.ParseDatetime(evt.Time)
+	if err != nil {
+		logger.Warn("invalid commit time, using current time", "error", err)
+		eventTime = syntax.DatetimeNow()
+	}
+
+	for _, op := range ops {
+		event, err := c.eventForOp(ctx, commitRepo, evt, eventTime, op)
+		if err != nil {
+			logger.Warn("skipping operation", "error", err, "path", op.Path)
+			continue
+		}
+
+		if err := c.handler.HandleEvent(ctx, event); err != nil {
+			return fmt.Errorf("handle event: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// verifyCommit loads the repo diff in the commit and checks that the commit is signed by
+// the account's current signing key.
+func (c *firehoseConsumer) verifyCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) (*repo.Repo, error) {
+	did, err := syntax.ParseDID(evt.Repo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo DID: %w", err)
+	}
+
+	commit, commitRepo, err := repo.LoadRepoFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return nil, fmt.Errorf("load commit blocks: %w", err)
+	}
+	if commit.DID != did.String() {
+		return nil, fmt.Errorf("commit DID %q doesn't match repo", commit.DID)
+	}
+	if commit.Rev != evt.Rev {
+		return nil, fmt.Errorf("commit rev %q doesn't match event", commit.Rev)
+	}
+
+	ident, err := c.directory.LookupDID(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("lookup identity: %w", err)
+	}
+	pubKey, err := ident.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("get signing key: %w", err)
+	}
+	if err := commit.VerifySignature(pubKey); err != nil {
+		// the account may have rotated its key since the identity was cached
+		_ = c.directory.Purge(ctx, did.AtIdentifier())
+		return nil, fmt.Errorf("verify signature: %w", err)
+	}
+
+	return commitRepo, nil
+}
+
+// eventForOp converts a verified firehose operation into the Jetstream event the handler
+// expects, with the record decoded from CBOR into JSON.
+func (c *firehoseConsumer) eventForOp(ctx context.Context, commitRepo *repo.Repo, evt *comatproto.SyncSubscribeRepos_Commit, eventTime syntax.Datetime, op *comatproto.SyncSubscribeRepos_RepoOp) (*models.Event, error) {
+	collection, rkey, err := syntax.ParseRepoPath(op.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo path: %w", err)
+	}
+
+	commit := &models.Commit{
+		Rev:        evt.Rev,
+		Operation:  op.Action,
+		Collection: collection.String(),
+		RKey:       rkey.String(),
+	}
+
+	switch op.Action {
+	case models.CommitOperationCreate, models.CommitOperationUpdate:
+		if op.Cid == nil {
+			return nil, fmt.Errorf("missing record CID")
+		}
+		recordBytes, recordCID, err := commitRepo.GetRecordBytes(ctx, collection, rkey)
+		if err != nil {
+			return nil, fmt.Errorf("get record from commit: %w", err)
+		}
+		if !recordCID.Equals(cid.Cid(*op.Cid)) {
+			return nil, fmt.Errorf("record CID doesn't match signed repo tree")
+		}
+		// the tree only proves the CID so make sure the block really has that CID
+		computedCID, err := recordCID.Prefix().Sum(recordBytes)
+		if err != nil {
+			return nil, fmt.Errorf("compute record CID: %w", err)
+		}
+		if !computedCID.Equals(*recordCID) {
+			return nil, fmt.Errorf("record block doesn't match its CID")
+		}
+
+		record, err := data.UnmarshalCBOR(recordBytes)
+		if err != nil {
+			return nil, fmt.Errorf("decode record: %w", err)
+		}
+		commit.Record, err = json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("encode record as JSON: %w", err)
+		}
+		commit.CID = recordCID.String()
+	case models.CommitOperationDelete:
+		// the tree must prove the record is gone, so that a relay can't delete statuses the
+		// account didn't
+		_, err := commitRepo.GetRecordCID(ctx, collection, rkey)
+		if err == nil {
+			return nil, fmt.Errorf("deleted record is still in signed repo tree")
+		}
+		if !errors.Is(err, repo.ErrNotFound) {
+			return nil, fmt.Errorf("prove record was deleted: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q", op.Action)
+	}
+
+	return &models.Event{
+		Did:    evt.Repo,
+		TimeUS: eventTime.Time().UnixMicro(),
+		Kind:   models.EventKindCommit,
+		Commit: commit,
+	}, nil
+}
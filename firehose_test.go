@@ -0,0 +1,361 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/ipfs/go-cid"
+
+	"github.com/willdot/statusphere-go/internal/fakerelay"
+	"github.com/willdot/statusphere-go/internal/testrepo"
+)
+
+const firehoseTestDID = syntax.DID("did:plc:firehoseaccount")
+
+// memoryStore is a HandlerStore that keeps statuses in memory, as the database package
+// can't be imported from inside this package.
+type memoryStore struct {
+	mu       sync.Mutex
+	statuses map[string]Status
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{statuses: make(map[string]Status)}
+}
+
+func (s *memoryStore) CreateStatus(_ context.Context, status Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status.URI] = status
+	return nil
+}
+
+func (s *memoryStore) CreateStatuses(ctx context.Context, statuses []Status) error {
+	for _, status := range statuses {
+		if err := s.CreateStatus(ctx, status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteStatus(_ context.Context, uri string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.statuses, uri)
+	return nil
+}
+
+func (s *memoryStore) IsDIDBlocked(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (s *memoryStore) CreateDeadLetter(context.Context, DeadLetter) error {
+	return nil
+}
+
+func (s *memoryStore) status(uri string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status, ok := s.statuses[uri]
+	return status, ok
+}
+
+// newFirehoseTestConsumer creates a consumer that resolves the signing key of a new repo.
+func newFirehoseTestConsumer(t *testing.T) (*firehoseConsumer, *testrepo.Repo, *memoryStore) {
+	t.Helper()
+
+	repo, err := testrepo.New(firehoseTestDID)
+	if err != nil {
+		t.Fatalf("create repo: %s", err)
+	}
+	ident, err := repo.Identity("firehose.test", "")
+	if err != nil {
+		t.Fatalf("get identity: %s", err)
+	}
+	directory := identity.NewMockDirectory()
+	directory.Insert(ident)
+
+	store := newMemoryStore()
+	consumer, err := NewFirehoseConsumer([]string{"wss://relay.test"}, slog.Default(), store, &directory)
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	return consumer, repo, store
+}
+
+func testStatusRecord(status string) map[string]any {
+	return map[string]any{
+		"$type":     statusCollection,
+		"status":    status,
+		"createdAt": time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// TestFirehoseVerifyCommit checks that a commit is only turned into an event when it's
+// signed by the account and its rev, DID and record CID match the event it came in.
+func TestFirehoseVerifyCommit(t *testing.T) {
+	otherCID, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	if err != nil {
+		t.Fatalf("decode CID: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		forged bool
+		tamper func(evt *comatproto.SyncSubscribeRepos_Commit)
+		// expectedErr is part of the error expected, or empty if the commit is valid
+		expectedErr string
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name:        "signed by another key",
+			forged:      true,
+			expectedErr: "verify signature",
+		},
+		{
+			name: "rev doesn't match commit",
+			tamper: func(evt *comatproto.SyncSubscribeRepos_Commit) {
+				evt.Rev = syntax.NewTIDNow(0).String()
+			},
+			expectedErr: "commit rev",
+		},
+		{
+			name: "DID doesn't match commit",
+			tamper: func(evt *comatproto.SyncSubscribeRepos_Commit) {
+				evt.Repo = "did:plc:someoneelse"
+			},
+			expectedErr: "commit DID",
+		},
+		{
+			name: "record CID doesn't match tree",
+			tamper: func(evt *comatproto.SyncSubscribeRepos_Commit) {
+				link := lexutil.LexLink(otherCID)
+				evt.Ops[0].Cid = &link
+			},
+			expectedErr: "record CID doesn't match",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			consumer, repo, _ := newFirehoseTestConsumer(t)
+
+			var commit *testrepo.Commit
+			var err error
+			if tt.forged {
+				key, keyErr := crypto.GeneratePrivateKeyK256()
+				if keyErr != nil {
+					t.Fatalf("generate key: %s", keyErr)
+				}
+				commit, err = repo.CreateRecordSignedBy(ctx, key, statusCollection, "3lsxb3n2bqs2a", testStatusRecord("👍"))
+			} else {
+				commit, err = repo.CreateRecord(ctx, statusCollection, "3lsxb3n2bqs2a", testStatusRecord("👍"))
+			}
+			if err != nil {
+				t.Fatalf("create record: %s", err)
+			}
+			evt := fakerelay.CommitEvent(commit, 1)
+			if tt.tamper != nil {
+				tt.tamper(evt)
+			}
+
+			commitRepo, err := consumer.verifyCommit(ctx, evt)
+			var event *models.Event
+			if err == nil {
+				event, err = consumer.eventForOp(ctx, commitRepo, evt, syntax.DatetimeNow(), evt.Ops[0])
+			}
+
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected the commit to be accepted, got %s", err)
+			}
+			if event.Did != firehoseTestDID.String() || event.Commit.Operation != models.CommitOperationCreate || event.Commit.RKey != "3lsxb3n2bqs2a" {
+				t.Fatalf("unexpected event %+v", event.Commit)
+			}
+			if event.Commit.CID != commit.Ops[0].CID.String() {
+				t.Fatalf("expected CID %s, got %s", commit.Ops[0].CID, event.Commit.CID)
+			}
+			var record StatusRecord
+			if err := json.Unmarshal(event.Commit.Record, &record); err != nil {
+				t.Fatalf("decode record: %s", err)
+			}
+			if record.Status != "👍" {
+				t.Fatalf("expected the record to be decoded, got %+v", record)
+			}
+		})
+	}
+}
+
+// TestFirehoseHandleCommit checks that verified commits are stored and deleted, and a forged
+// commit is skipped without stopping the stream.
+func TestFirehoseHandleCommit(t *testing.T) {
+	ctx := context.Background()
+	consumer, repo, store := newFirehoseTestConsumer(t)
+	uri := "at://" + firehoseTestDID.String() + "/" + statusCollection + "/"
+
+	commit, err := repo.CreateRecord(ctx, statusCollection, "3lsxb3n2bqs2a", testStatusRecord("👍"))
+	if err != nil {
+		t.Fatalf("create record: %s", err)
+	}
+	if err := consumer.handleCommit(ctx, fakerelay.CommitEvent(commit, 1)); err != nil {
+		t.Fatalf("handle commit: %s", err)
+	}
+	if status, ok := store.status(uri + "3lsxb3n2bqs2a"); !ok || status.Status != "👍" || status.Rev != commit.Rev {
+		t.Fatalf("expected the status to be stored, got %+v", status)
+	}
+
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+	forged, err := repo.CreateRecordSignedBy(ctx, key, statusCollection, "3lsxb3n2bqs2b", testStatusRecord("🤔"))
+	if err != nil {
+		t.Fatalf("create record: %s", err)
+	}
+	if err := consumer.handleCommit(ctx, fakerelay.CommitEvent(forged, 2)); err != nil {
+		t.Fatalf("expected the forged commit to be skipped, got %s", err)
+	}
+	if _, ok := store.status(uri + "3lsxb3n2bqs2b"); ok {
+		t.Fatal("expected the forged status not to be stored")
+	}
+
+	deleted, err := repo.DeleteRecord(ctx, statusCollection, "3lsxb3n2bqs2a")
+	if err != nil {
+		t.Fatalf("delete record: %s", err)
+	}
+	if err := consumer.handleCommit(ctx, fakerelay.CommitEvent(deleted, 3)); err != nil {
+		t.Fatalf("handle commit: %s", err)
+	}
+	if _, ok := store.status(uri + "3lsxb3n2bqs2a"); ok {
+		t.Fatal("expected the status to be deleted")
+	}
+}
+
+// TestFirehoseUnprovenDelete checks that a delete is skipped when the signed repo tree
+// doesn't prove the record is gone.
+func TestFirehoseUnprovenDelete(t *testing.T) {
+	ctx := context.Background()
+	consumer, repo, store := newFirehoseTestConsumer(t)
+	uri := "at://" + firehoseTestDID.String() + "/" + statusCollection + "/3lsxb3n2bqs2a"
+
+	commit, err := repo.CreateRecord(ctx, statusCollection, "3lsxb3n2bqs2a", testStatusRecord("👍"))
+	if err != nil {
+		t.Fatalf("create record: %s", err)
+	}
+	if err := consumer.handleCommit(ctx, fakerelay.CommitEvent(commit, 1)); err != nil {
+		t.Fatalf("handle commit: %s", err)
+	}
+
+	// a later commit signed by the account that says the status was deleted when its tree
+	// still has it
+	unchanged, err := repo.CreateRecord(ctx, statusCollection, "3lsxb3n2bqs2b", testStatusRecord("🤔"))
+	if err != nil {
+		t.Fatalf("create record: %s", err)
+	}
+	evt := fakerelay.CommitEvent(unchanged, 2)
+	evt.Ops = []*comatproto.SyncSubscribeRepos_RepoOp{{Action: models.CommitOperationDelete, Path: statusCollection + "/3lsxb3n2bqs2a"}}
+	if err := consumer.handleCommit(ctx, evt); err != nil {
+		t.Fatalf("expected the delete to be skipped, got %s", err)
+	}
+	if _, ok := store.status(uri); !ok {
+		t.Fatal("expected the status not to be deleted")
+	}
+}
+
+// TestFirehoseResumesFromStartCursor starts a consumer from the sequence number a previous
+// consumer reached on the relay and checks only the commits after it are handled, and that
+// the start cursor is ignored for a relay the consumer doesn't use.
+func TestFirehoseResumesFromStartCursor(t *testing.T) {
+	ctx := context.Background()
+	relay := fakerelay.New()
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	t.Cleanup(relay.Close)
+	if err := relay.CreateAccount(firehoseTestDID, "firehose.test"); err != nil {
+		t.Fatalf("create account: %s", err)
+	}
+	uri := "at://" + firehoseTestDID.String() + "/" + statusCollection + "/"
+	for _, rkey := range []string{"3lsxb3n2bqs2a", "3lsxb3n2bqs2b"} {
+		if err := relay.CreateStatus(ctx, firehoseTestDID, rkey, "👍", time.Now()); err != nil {
+			t.Fatalf("create status: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		startRelay  string
+		startCursor int64
+		// expected are the rkeys of the statuses expected to be stored
+		expected []string
+		// expectedRelay is the relay expected to be consumed from
+		expectedRelay string
+	}{
+		{name: "resumes", startRelay: server.URL, startCursor: 1, expected: []string{"3lsxb3n2bqs2b"}, expectedRelay: server.URL},
+		{name: "another relay", startRelay: "wss://other.test", startCursor: 1, expectedRelay: "wss://unused.test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			consumer, err := NewFirehoseConsumer([]string{"wss://unused.test", server.URL}, slog.Default(), store, relay.Directory(), WithFirehoseStartCursor(tt.startRelay, tt.startCursor))
+			if err != nil {
+				t.Fatalf("create consumer: %s", err)
+			}
+			status := consumer.Status()
+			if status.Endpoint != tt.expectedRelay {
+				t.Fatalf("expected to consume from %s, got %s", tt.expectedRelay, status.Endpoint)
+			}
+			if tt.expected == nil {
+				if status.Cursor != 0 {
+					t.Fatalf("expected the start cursor to be ignored, got %d", status.Cursor)
+				}
+				return
+			}
+
+			ctx, cancel := context.WithCancel(ctx)
+			done := make(chan error)
+			go func() { done <- consumer.Consume(ctx) }()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			deadline := time.Now().Add(time.Second * 5)
+			for consumer.Status().Cursor != 2 {
+				if time.Now().After(deadline) {
+					t.Fatalf("timed out waiting for the consumer to handle the commits, cursor is %d", consumer.Status().Cursor)
+				}
+				time.Sleep(time.Millisecond * 10)
+			}
+			if _, ok := store.status(uri + "3lsxb3n2bqs2a"); ok {
+				t.Fatal("expected the status before the start cursor not to be stored")
+			}
+			for _, rkey := range tt.expected {
+				if _, ok := store.status(uri + rkey); !ok {
+					t.Fatalf("expected status %s to be stored", rkey)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	followCollection = "app.bsky.graph.follow"
+
+	// followsRefreshInterval is how long the accounts a user follows are cached for before
+	// they're fetched from their PDS again, when they next look at the following feed
+	followsRefreshInterval = time.Hour
+	// maxFollows is how many follows are fetched, so that accounts following a huge number
+	// of others don't take too long to load
+	maxFollows = 10000
+	// followsRefreshTimeout is how long fetching and saving a user's follows can take
+	followsRefreshTimeout = time.Minute
+	// followsQueueSize is how many users can be waiting for their follows to be fetched
+	followsQueueSize = 100
+)
+
+// FollowRecord is an app.bsky.graph.follow record, of which only the followed account is
+// needed.
+type FollowRecord struct {
+	Subject string `json:"subject"`
+}
+
+// FollowStore caches the accounts users follow on Bluesky, for the following feed.
+type FollowStore interface {
+	GetFollowsRefreshedAt(ctx context.Context, did string) (int64, error)
+	SaveFollows(ctx context.Context, did string, subjects []string, refreshedAt int64) error
+	GetFollowingStatuses(ctx context.Context, did string, limit int) ([]Status, error)
+}
+
+// followsRefresh is a user whose follows are waiting to be fetched in the background.
+type followsRefresh struct {
+	did       syntax.DID
+	sessionID string
+}
+
+// queueFollowsRefresh queues the accounts the user follows to be fetched in the background
+// if they've not been fetched in the last hour, so that the following feed doesn't wait on
+// the user's PDS. It reports whether they've been fetched before, as until they have the
+// feed only has the user's own statuses.
+func (s *Server) queueFollowsRefresh(ctx context.Context, did syntax.DID, sessionID string) (bool, error) {
+	refreshedAt, err := s.store.GetFollowsRefreshedAt(ctx, did.String())
+	if err != nil && !errors.Is(err, ErrorNotFound) {
+		return false, fmt.Errorf("get follows refreshed at: %w", err)
+	}
+	cached := err == nil
+	if cached && s.now().Sub(time.UnixMilli(refreshedAt)) < followsRefreshInterval {
+		return true, nil
+	}
+
+	s.followsMu.Lock()
+	defer s.followsMu.Unlock()
+	if s.followsQueued[did] {
+		return cached, nil
+	}
+	select {
+	case s.followsQueue <- followsRefresh{did: did, sessionID: sessionID}:
+		s.followsQueued[did] = true
+	default:
+		// the queue is full, so it's queued again the next time they look at the feed
+		slog.Warn("follows refresh queue is full", "did", did)
+	}
+	return cached, nil
+}
+
+// refreshQueuedFollows fetches the follows of queued users one at a time until the context
+// is cancelled. If they can't be fetched, the ones fetched before keep being used.
+func (s *Server) refreshQueuedFollows(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case refresh := <-s.followsQueue:
+			if err := s.refreshFollows(ctx, refresh.did, refresh.sessionID); err != nil {
+				slog.Error("refresh follows", "error", err, "did", refresh.did)
+			}
+			s.followsMu.Lock()
+			delete(s.followsQueued, refresh.did)
+			s.followsMu.Unlock()
+		}
+	}
+}
+
+// refreshFollows fetches the accounts the user follows from their PDS and saves them.
+func (s *Server) refreshFollows(ctx context.Context, did syntax.DID, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, followsRefreshTimeout)
+	defer cancel()
+
+	subjects, err := s.fetchFollows(ctx, did, sessionID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.SaveFollows(ctx, did.String(), subjects, s.now().UnixMilli()); err != nil {
+		return fmt.Errorf("save follows: %w", err)
+	}
+	slog.Info("refreshed follows", "did", did, "follows", len(subjects))
+	return nil
+}
+
+// fetchFollows lists the user's app.bsky.graph.follow records from their PDS, returning the
+// DIDs of the accounts they follow.
+func (s *Server) fetchFollows(ctx context.Context, did syntax.DID, sessionID string) ([]string, error) {
+	oauthSess, err := s.oauthClient.ResumeSession(ctx, did, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("resume session: %w", err)
+	}
+	c := oauthSess.APIClient()
+
+	var subjects []string
+	params := map[string]any{
+		"repo":       did.String(),
+		"collection": followCollection,
+		"limit":      backfillPageSize,
+	}
+	for len(subjects) < maxFollows {
+		var resp listRecordsResp
+		err := c.Get(ctx, "com.atproto.repo.listRecords", params, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("list follow records: %w", err)
+		}
+
+		for _, record := range resp.Records {
+			var follow FollowRecord
+			if err := json.Unmarshal(record.Value, &follow); err != nil {
+				slog.Warn("skipping invalid follow record", "uri", record.URI, "error", err)
+				continue
+			}
+			subject, err := syntax.ParseDID(follow.Subject)
+			if err != nil {
+				slog.Warn("skipping follow record with invalid subject", "uri", record.URI, "error", err)
+				continue
+			}
+			subjects = append(subjects, subject.String())
+		}
+
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Records) == 0 {
+			break
+		}
+		params["cursor"] = *resp.Cursor
+	}
+	return subjects, nil
+}
@@ -0,0 +1,74 @@
+package statusphere_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// TestFollowingFeed stores statuses for two other accounts, follows one of them from the test
+// account's repo and checks the following feed only shows that one until the cached follows
+// are refreshed after following the other.
+func TestFollowingFeed(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+
+	followed := statusphere.UserProfile{Did: "did:plc:followed", Handle: "followed.test"}
+	later := statusphere.UserProfile{Did: "did:plc:followedlater", Handle: "followedlater.test"}
+	for i, profile := range []statusphere.UserProfile{followed, later} {
+		if err := app.DB.CreateProfile(ctx, profile); err != nil {
+			t.Fatalf("store profile: %s", err)
+		}
+		app.CreateStatus(t, testapp.NewStatus(syntax.DID(profile.Did), statusphere.DefaultPalette[i].Status))
+	}
+
+	follow := func(subject string) {
+		t.Helper()
+		_, err := app.PDS.CreateRecord(ctx, testapp.DID, "app.bsky.graph.follow", syntax.NewTIDNow(0).String(), map[string]any{
+			"$type":     "app.bsky.graph.follow",
+			"subject":   subject,
+			"createdAt": time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			t.Fatalf("create follow record: %s", err)
+		}
+	}
+	// follows are fetched in the background, so the feed is loaded again until they have been
+	expectFeed := func(shown, notShown string) {
+		t.Helper()
+		testapp.WaitFor(t, func() error {
+			_, body := app.Get(t, "/?view=following")
+			if !strings.Contains(body, shown) {
+				return fmt.Errorf("expected the following feed to show %s", shown)
+			}
+			if notShown != "" && strings.Contains(body, notShown) {
+				return fmt.Errorf("expected the following feed not to show %s", notShown)
+			}
+			return nil
+		})
+	}
+
+	follow(followed.Did)
+	_, body := app.Get(t, "/?view=following")
+	if !strings.Contains(body, "Getting who you follow") {
+		t.Fatal("expected the following feed to say the follows are being fetched")
+	}
+	expectFeed(followed.Handle, later.Handle)
+
+	// the follows are cached, so following another account doesn't change the feed until
+	// they're refreshed
+	follow(later.Did)
+	expectFeed(followed.Handle, later.Handle)
+	if err := app.DB.SaveFollows(ctx, testapp.DID.String(), nil, time.Now().Add(-2*time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("make follows stale: %s", err)
+	}
+	expectFeed(later.Handle, "")
+	expectFeed(followed.Handle, "")
+}
@@ -0,0 +1,175 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+type HandlerStore interface {
+	CreateStatus(ctx context.Context, status Status) error
+	CreateStatuses(ctx context.Context, statuses []Status) error
+	DeleteStatus(ctx context.Context, uri string) error
+	IsDIDBlocked(ctx context.Context, did string) (bool, error)
+	CreateDeadLetter(ctx context.Context, deadLetter DeadLetter) error
+}
+
+// errInvalidRecord is returned when an event can never be handled, no matter how many
+// times it's retried.
+var errInvalidRecord = errors.New("invalid record")
+
+type handler struct {
+	store HandlerStore
+	// batcher is set when statuses are written in batches rather than one at a time
+	batcher *statusBatcher
+	// cursor is set when the consumer's cursor follows the events that have been handled
+	cursor *cursorTracker
+}
+
+// HandleEvent handles an event from Jetstream. Events that fail to be handled are stored as
+// dead letters rather than returned as errors, so that one bad event doesn't stop the
+// stream and the failure isn't lost. The cursor only moves past the event once it has been
+// stored or dead lettered.
+func (h *handler) HandleEvent(ctx context.Context, event *models.Event) error {
+	err := h.processEvent(ctx, event)
+	if err != nil {
+		h.deadLetter(ctx, event, err)
+	}
+
+	if h.cursor != nil {
+		h.cursor.done(event)
+	}
+	return nil
+}
+
+// processEvent handles an event, returning any error that prevented it being handled.
+func (h *handler) processEvent(ctx context.Context, event *models.Event) error {
+	if event.Commit == nil {
+		return nil
+	}
+
+	switch event.Commit.Operation {
+	case models.CommitOperationCreate, models.CommitOperationUpdate:
+		// an update is a newer copy of the record, which replaces the stored one
+		return h.handleCreateEvent(ctx, event)
+	case models.CommitOperationDelete:
+		return h.handleDeleteEvent(ctx, event)
+	default:
+		return nil
+	}
+}
+
+type StatusRecord struct {
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (h *handler) handleCreateEvent(ctx context.Context, event *models.Event) error {
+	// statuses from blocked accounts aren't stored at all, whereas deletes are still
+	// handled so that unblocking an account doesn't show statuses it has since deleted
+	blocked, err := h.store.IsDIDBlocked(ctx, event.Did)
+	if err != nil {
+		return fmt.Errorf("check blocked DID: %w", err)
+	}
+	if blocked {
+		return nil
+	}
+
+	var statusRecord StatusRecord
+	if err := json.Unmarshal(event.Commit.Record, &statusRecord); err != nil {
+		return fmt.Errorf("%w: unmarshal record: %s", errInvalidRecord, err)
+	}
+
+	uri := fmt.Sprintf("at://%s/%s/%s", event.Did, event.Commit.Collection, event.Commit.RKey)
+
+	status := Status{
+		URI:       uri,
+		Did:       event.Did,
+		Status:    statusRecord.Status,
+		CreatedAt: statusRecord.CreatedAt.UnixMilli(),
+		IndexedAt: time.Now().UnixMilli(),
+		CID:       event.Commit.CID,
+		Rev:       event.Commit.Rev,
+	}
+
+	if h.batcher != nil {
+		h.batched(event)
+		h.batcher.Add(status, event)
+		return nil
+	}
+
+	err = h.store.CreateStatus(ctx, status)
+	if err != nil {
+		return fmt.Errorf("store status: %w", err)
+	}
+
+	return nil
+}
+
+// handleDeleteEvent deletes the status, so that the user's current status goes back to the
+// one they created before it.
+func (h *handler) handleDeleteEvent(ctx context.Context, event *models.Event) error {
+	uri := fmt.Sprintf("at://%s/%s/%s", event.Did, event.Commit.Collection, event.Commit.RKey)
+
+	// the delete has to go through the batcher too, otherwise it could be written before
+	// the status it deletes
+	if h.batcher != nil {
+		h.batched(event)
+		h.batcher.AddDelete(uri, event)
+		return nil
+	}
+
+	err := h.store.DeleteStatus(ctx, uri)
+	if err != nil {
+		return fmt.Errorf("delete status: %w", err)
+	}
+
+	return nil
+}
+
+// deadLetter stores an event that couldn't be handled so that it can be inspected and
+// retried later. Failures to store the status itself are assumed to be transient and are
+// retried in the background, whereas invalid records are not.
+func (h *handler) deadLetter(ctx context.Context, event *models.Event, handleErr error) {
+	logger := slog.With("did", event.Did, "error", handleErr)
+	if event.Commit != nil {
+		logger = logger.With("rkey", event.Commit.RKey)
+	}
+	logger.Error("failed to handle event, storing as dead letter")
+
+	deadLetter, err := newDeadLetter(event, handleErr)
+	if err != nil {
+		logger.Error("failed to create dead letter", "dead letter error", err)
+		return
+	}
+
+	// the event may have failed because the context was cancelled, but the dead letter
+	// should still be stored
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deadLetterStoreTimeout)
+	defer cancel()
+
+	err = h.store.CreateDeadLetter(ctx, deadLetter)
+	if err != nil {
+		logger.Error("failed to store dead letter", "dead letter error", err)
+	}
+}
+
+// batched stops the cursor moving past the event until the batch it's been added to has been
+// written, which calls written.
+func (h *handler) batched(event *models.Event) {
+	if h.cursor != nil {
+		h.cursor.add(event)
+	}
+}
+
+// written is called once a batched event's status has been stored or dead lettered.
+func (h *handler) written(event *models.Event) {
+	if h.cursor != nil {
+		h.cursor.done(event)
+	}
+}
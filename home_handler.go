@@ -1,96 +1,120 @@
 package statusphere
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
-var Availablestatus = []string{
-	"👍",
-	"👎",
-	"💙",
-	"🥹",
-	"😧",
-	"😤",
-	"🙃",
-	"😉",
-	"😎",
-	"🤓",
-	"🤨",
-	"🥳",
-	"😭",
-	"😤",
-	"🤯",
-	"🫡",
-	"💀",
-	"✊",
-	"🤘",
-	"👀",
-	"🧠",
-	"👩‍💻",
-	"🧑‍💻",
-	"🥷",
-	"🧌",
-	"🦋",
-	"🚀",
-}
+// The home feed either shows each user's current status, which is the default, every
+// status that has been posted, or the statuses of the accounts the user follows on Bluesky.
+const (
+	homeViewCurrent   = "current"
+	homeViewAll       = "all"
+	homeViewFollowing = "following"
+)
 
 type HomeData struct {
-	DisplayName     string
-	AvailableStatus []string
-	UsersStatus     []UserStatus
+	DisplayName string
+	Palette     []PaletteCategory
+	UsersStatus []UserStatus
+	// View is which statuses the feed shows, which is current, all or following.
+	View string
+	// Error is why the user's last status couldn't be posted, if it couldn't.
+	Error string
+	// FetchingFollows is set while the accounts the user follows are fetched for the first
+	// time, so the following feed only has their own statuses.
+	FetchingFollows bool
 }
 
 type UserStatus struct {
 	Status    string
 	Handle    string
 	HandleURL string
-	Date      string
-	IsToday   bool
+	StatusTime
+	// BlurredBy are the labels that the status is blurred because of, if there are any.
+	BlurredBy string
 }
 
 func (s *Server) HandleHome(w http.ResponseWriter, r *http.Request) {
+	s.renderHome(w, r, http.StatusOK, "")
+}
+
+// renderHome renders the home page with the status code, showing the error message if
+// there is one.
+func (s *Server) renderHome(w http.ResponseWriter, r *http.Request, statusCode int, errMsg string) {
 	tmpl := s.getTemplate("home.html")
-	data := HomeData{
-		AvailableStatus: Availablestatus,
+	data := HomeData{Error: errMsg}
+
+	switch view := r.URL.Query().Get("view"); view {
+	case "":
+		data.View = homeViewCurrent
+	case homeViewCurrent, homeViewAll, homeViewFollowing:
+		data.View = view
+	default:
+		http.Error(w, "unknown view", http.StatusBadRequest)
+		return
 	}
 
-	did, _ := s.currentSessionDID(r)
+	did, sessionID := s.currentSessionDID(r)
 	if did != nil {
-		profile, err := s.getUserProfileForDid(did.String())
+		profile, err := s.getUserProfileForDid(r.Context(), did.String())
 		if err != nil {
 			slog.Error("getting logged in users profile", "error", err)
 		}
 		data.DisplayName = profile.DisplayName
 	}
 
-	today := time.Now().Format(time.DateOnly)
-
-	results, err := s.store.GetStatuses(10)
+	palette, err := s.store.GetPalette(r.Context())
+	if err != nil {
+		slog.Error("get palette", "error", err)
+	}
+	data.Palette = paletteCategories(palette)
+
+	now := s.now()
+	loc := viewerLocation(r)
+
+	var results []Status
+	switch data.View {
+	case homeViewCurrent:
+		results, err = s.store.GetCurrentStatuses(r.Context(), 10)
+	case homeViewAll:
+		results, err = s.store.GetStatuses(r.Context(), 10)
+	case homeViewFollowing:
+		if did == nil {
+			break
+		}
+		fetched, queueErr := s.queueFollowsRefresh(r.Context(), *did, sessionID)
+		if queueErr != nil {
+			slog.Error("queue follows refresh", "error", queueErr, "did", did)
+		}
+		data.FetchingFollows = !fetched
+		results, err = s.store.GetFollowingStatuses(r.Context(), did.String(), 10)
+	}
 	if err != nil {
 		slog.Error("get status'", "error", err)
 	}
 
 	for _, status := range results {
-		date := time.UnixMilli(status.CreatedAt).Format(time.DateOnly)
-
-		profile, err := s.getUserProfileForDid(status.Did)
+		profile, err := s.getUserProfileForDid(r.Context(), status.Did)
 		if err != nil {
 			slog.Error("getting user profile for status - skipping", "error", err, "did", status.Did)
 			continue
 		}
 
 		data.UsersStatus = append(data.UsersStatus, UserStatus{
-			Status:    status.Status,
-			Handle:    profile.Handle,
-			HandleURL: fmt.Sprintf("https://bsky.app/profile/%s", status.Did),
-			Date:      date,
-			IsToday:   date == today,
+			Status:     status.Status,
+			Handle:     profile.Handle,
+			HandleURL:  fmt.Sprintf("https://bsky.app/profile/%s", status.Did),
+			StatusTime: describeStatusTime(time.UnixMilli(status.EffectiveAt), now, loc),
+			BlurredBy:  strings.Join(labelsWithAction(status.Labels, LabelActionBlur), ", "),
 		})
 	}
 
+	w.WriteHeader(statusCode)
 	tmpl.Execute(w, data)
 }
 
@@ -107,6 +131,10 @@ func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing status", http.StatusBadRequest)
 		return
 	}
+	if err := s.checkStatusAllowed(r.Context(), status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	did, sessionID := s.currentSessionDID(r)
 	if did == nil {
@@ -116,6 +144,26 @@ func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
 
 	slog.Info("session", "did", did.String(), "session id", sessionID)
 
+	blocked, err := s.store.IsDIDBlocked(r.Context(), did.String())
+	if err != nil {
+		slog.Error("check blocked DID", "error", err)
+	}
+	if blocked {
+		slog.Warn("blocked account tried to post a status", "did", did.String())
+		s.renderHome(w, r, http.StatusForbidden, "Your account can't post statuses here.")
+		return
+	}
+
+	allowed, retryAfter := checkRateLimits(r.Context(),
+		rateLimitCheck{limiter: s.limiters.statusByDID, key: did.String()},
+		rateLimitCheck{limiter: s.limiters.statusByIP, key: s.clientIP(r)},
+	)
+	if !allowed {
+		slog.Warn("status posting rate limited", "did", did.String(), "retry after", retryAfter)
+		s.renderHome(w, r, http.StatusTooManyRequests, "You're changing your status too often, "+setRetryAfter(w, retryAfter)+".")
+		return
+	}
+
 	oauthSess, err := s.oauthClient.ResumeSession(r.Context(), *did, sessionID)
 	if err != nil {
 		http.Error(w, "not authenticated", http.StatusUnauthorized)
@@ -123,7 +171,7 @@ func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	c := oauthSess.APIClient()
 
-	createdAt := time.Now()
+	createdAt := s.now()
 
 	bodyReq := map[string]any{
 		"repo":       c.AccountDID.String(),
@@ -146,13 +194,40 @@ func (s *Server) HandleStatus(w http.ResponseWriter, r *http.Request) {
 		Did:       c.AccountDID.String(),
 		Status:    status,
 		CreatedAt: createdAt.UnixMilli(),
-		IndexedAt: time.Now().UnixMilli(),
+		IndexedAt: s.now().UnixMilli(),
+		CID:       result.CID,
+	}
+	if result.Commit != nil {
+		statusToStore.Rev = result.Commit.Rev
 	}
 
-	err = s.store.CreateStatus(statusToStore)
+	err = s.store.CreateStatus(r.Context(), statusToStore)
 	if err != nil {
 		slog.Error("failed to store status that has been created", "error", err)
 	}
 
 	http.Redirect(w, r, "/", http.StatusFound)
 }
+
+// checkStatusAllowed checks that users can post the status from the app, which depends on
+// the status policy.
+func (s *Server) checkStatusAllowed(ctx context.Context, status string) error {
+	if err := ValidateStatus(status); err != nil {
+		return err
+	}
+	if s.statusPolicy == StatusPolicyEmoji {
+		return nil
+	}
+
+	palette, err := s.store.GetPalette(ctx)
+	if err != nil {
+		slog.Error("get palette", "error", err)
+		return fmt.Errorf("failed to check status")
+	}
+	for _, allowed := range palette {
+		if allowed.Status == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("status %s isn't in the palette", status)
+}
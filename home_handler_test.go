@@ -0,0 +1,149 @@
+package statusphere_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+func TestPostStatus(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	status := statusphere.DefaultPalette[0].Status
+
+	resp, body := app.PostForm(t, "/status", url.Values{"status": {status}})
+	testapp.ExpectPath(t, resp, "/")
+	if !strings.Contains(body, status) || !strings.Contains(body, testapp.Handle.String()) {
+		t.Fatal("feed doesn't show the new status")
+	}
+
+	statuses, err := app.DB.GetStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 1 || statuses[0].Did != testapp.DID.String() || statuses[0].Status != status {
+		t.Fatalf("expected the status to be stored, got %+v", statuses)
+	}
+
+	uri, err := syntax.ParseATURI(statuses[0].URI)
+	if err != nil {
+		t.Fatalf("stored status has invalid URI: %s", err)
+	}
+	record, recordCID, err := app.PDS.GetRecord(context.Background(), testapp.DID, uri.Collection().String(), uri.RecordKey().String())
+	if err != nil {
+		t.Fatalf("status wasn't written to the PDS: %s", err)
+	}
+	if record["status"] != status {
+		t.Fatalf("expected record status %q, got %v", status, record["status"])
+	}
+	if statuses[0].CID != recordCID.String() || statuses[0].Rev == "" {
+		t.Fatalf("expected the status to be stored with CID %s and a rev, got %q and %q", recordCID, statuses[0].CID, statuses[0].Rev)
+	}
+}
+
+func TestPostStatusOutsidePalette(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+
+	for _, status := range []string{"hello", "🙂"} {
+		resp, _ := app.PostForm(t, "/status", url.Values{"status": {status}})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected posting %q to be a bad request, got %d", status, resp.StatusCode)
+		}
+	}
+
+	statuses, err := app.DB.GetStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 0 {
+		t.Fatalf("expected no status to be stored, got %d", len(statuses))
+	}
+}
+
+// TestHomeShowsCurrentStatus stores three statuses, the oldest of them last as if it arrived
+// late, and checks that the home feed shows only the newest unless every status is asked for.
+func TestHomeShowsCurrentStatus(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+
+	original := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status)
+	newer := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[5].Status)
+	newer.CreatedAt = original.CreatedAt + 1000
+	older := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[6].Status)
+	older.CreatedAt = original.CreatedAt - 1000
+	for _, status := range []statusphere.Status{original, newer, older} {
+		app.CreateStatus(t, status)
+	}
+
+	expectCurrentStatus(t, app, newer.URI)
+	_, body := app.Get(t, "/")
+	if n := strings.Count(body, `class="status-line"`); n != 1 {
+		t.Fatalf("expected the home feed to show 1 status, got %d", n)
+	}
+	_, body = app.Get(t, "/?view=all")
+	if n := strings.Count(body, `class="status-line"`); n != 3 {
+		t.Fatalf("expected the home feed to show 3 statuses when viewing all, got %d", n)
+	}
+
+	// deleting the current status goes back to the one created before it
+	if err := app.DB.DeleteStatus(ctx, newer.URI); err != nil {
+		t.Fatalf("delete status: %s", err)
+	}
+	expectCurrentStatus(t, app, original.URI)
+}
+
+func expectCurrentStatus(t *testing.T, app *testapp.App, uri string) {
+	t.Helper()
+
+	current, err := app.DB.GetCurrentStatuses(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("get current statuses: %s", err)
+	}
+	if len(current) != 1 || current[0].URI != uri {
+		t.Fatalf("expected the current status to be %s, got %+v", uri, current)
+	}
+}
+
+// TestHomeDatesStatusesInViewersTimezone stores a status for another account created just
+// before midnight in Pago Pago, which is already the next day in Kiritimati, and checks the
+// home feed says it was posted today for a viewer in Kiritimati but not for one in Pago Pago.
+func TestHomeDatesStatusesInViewersTimezone(t *testing.T) {
+	// 00:30 in Pago Pago and 01:30 the next day in Kiritimati
+	now := time.Date(2026, time.March, 10, 11, 30, 0, 0, time.UTC)
+	app := testapp.NewLoggedIn(t, statusphere.WithClock(func() time.Time { return now }))
+
+	other := statusphere.UserProfile{Did: "did:plc:otheraccount", Handle: "bob.test"}
+	if err := app.DB.CreateProfile(context.Background(), other); err != nil {
+		t.Fatalf("store profile: %s", err)
+	}
+	status := testapp.NewStatus(syntax.DID(other.Did), statusphere.DefaultPalette[3].Status)
+	status.CreatedAt = now.Add(-31 * time.Minute).UnixMilli()
+	status.IndexedAt = status.CreatedAt
+	app.CreateStatus(t, status)
+
+	for _, tz := range []struct {
+		name  string
+		today bool
+	}{
+		{"Pacific/Kiritimati", true},
+		{"Pacific/Pago_Pago", false},
+	} {
+		app.SetCookie("tz", tz.name)
+		_, body := app.Get(t, "/")
+		_, line, ok := strings.Cut(body, "@"+other.Handle)
+		if !ok {
+			t.Fatal("home feed doesn't show the other account's status")
+		}
+		line, _, _ = strings.Cut(line, "</div>")
+		if today := strings.Contains(line, "today"); today != tz.today {
+			t.Errorf("expected the status to be today in %s to be %t, got %q", tz.name, tz.today, strings.TrimSpace(line))
+		}
+	}
+}
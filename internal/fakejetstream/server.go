@@ -0,0 +1,275 @@
+// Package fakejetstream runs an in-process Jetstream server so that consuming events can be
+// tested deterministically without connecting to a real Jetstream instance.
+package fakejetstream
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/models"
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/multiformats/go-multihash"
+)
+
+const statusCollection = "xyz.statusphere.status"
+
+// Server is a fake Jetstream server. It implements http.Handler so it can be served with
+// httptest and serves the subscribe endpoint on any path.
+//
+// Like Jetstream, it supports the cursor and wantedCollections query params and zstd
+// compression when asked for with the Socket-Encoding header.
+type Server struct {
+	mu          sync.Mutex
+	history     []*models.Event
+	lastTimeUS  int64
+	subscribers map[*subscriber]struct{}
+	// when false, compression requests are ignored and messages are sent uncompressed
+	supportsCompression bool
+
+	upgrader websocket.Upgrader
+	encoder  *zstd.Encoder
+}
+
+type subscriber struct {
+	events            chan *models.Event
+	wantedCollections []string
+	closed            bool
+}
+
+// New creates a server with no events.
+func New() (*Server, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(models.ZSTDDictionary))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd encoder: %w", err)
+	}
+
+	return &Server{
+		subscribers:         make(map[*subscriber]struct{}),
+		supportsCompression: true,
+		encoder:             encoder,
+	}, nil
+}
+
+// SetCompressionSupported sets whether the server honours requests for compressed messages.
+func (s *Server) SetCompressionSupported(supported bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.supportsCompression = supported
+}
+
+// Publish sends the event to all subscribers and keeps it so that subscribers connecting
+// with an earlier cursor receive it. If the event has no time, it's given one after the
+// previous event.
+func (s *Server) Publish(event *models.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.TimeUS == 0 {
+		event.TimeUS = max(time.Now().UnixMicro(), s.lastTimeUS+1)
+	}
+	s.lastTimeUS = max(s.lastTimeUS, event.TimeUS)
+	s.history = append(s.history, event)
+
+	for sub := range s.subscribers {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// too slow, so disconnect it like Jetstream would
+			s.closeSubscriber(sub)
+		}
+	}
+}
+
+// PublishFile publishes every event in a newline delimited JSON file, such as one written
+// by a consumer recording events.
+func (s *Server) PublishFile(r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	count := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event models.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return count, fmt.Errorf("unmarshal event: %w", err)
+		}
+		s.Publish(&event)
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// CreateStatus publishes a commit event creating a status record.
+func (s *Server) CreateStatus(did, rkey, status string, createdAt time.Time) error {
+	return s.publishStatus(models.CommitOperationCreate, did, rkey, status, createdAt)
+}
+
+// UpdateStatus publishes a commit event updating a status record.
+func (s *Server) UpdateStatus(did, rkey, status string, createdAt time.Time) error {
+	return s.publishStatus(models.CommitOperationUpdate, did, rkey, status, createdAt)
+}
+
+// publishStatus publishes a commit event writing a status record, with a CID made from a
+// hash of the record's JSON so that different records have different CIDs.
+func (s *Server) publishStatus(operation, did, rkey, status string, createdAt time.Time) error {
+	record, err := json.Marshal(map[string]any{
+		"$type":     statusCollection,
+		"status":    status,
+		"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	hash, err := multihash.Sum(record, multihash.SHA2_256, -1)
+	if err != nil {
+		return fmt.Errorf("hash record: %w", err)
+	}
+
+	s.Publish(&models.Event{
+		Did:  did,
+		Kind: models.EventKindCommit,
+		Commit: &models.Commit{
+			Rev:        strconv.FormatInt(time.Now().UnixMicro(), 10),
+			Operation:  operation,
+			Collection: statusCollection,
+			RKey:       rkey,
+			Record:     record,
+			CID:        cid.NewCidV1(cid.DagCBOR, hash).String(),
+		},
+	})
+	return nil
+}
+
+// DeleteStatus publishes a commit event deleting a status record.
+func (s *Server) DeleteStatus(did, rkey string) {
+	s.Publish(&models.Event{
+		Did:  did,
+		Kind: models.EventKindCommit,
+		Commit: &models.Commit{
+			Rev:        strconv.FormatInt(time.Now().UnixMicro(), 10),
+			Operation:  models.CommitOperationDelete,
+			Collection: statusCollection,
+			RKey:       rkey,
+		},
+	})
+}
+
+// Close disconnects all subscribers.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.subscribers {
+		s.closeSubscriber(sub)
+	}
+}
+
+// ServeHTTP upgrades the request to a websocket and streams events to it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var cursor *int64
+	if c := query.Get("cursor"); c != "" {
+		timeUS, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = &timeUS
+	}
+
+	con, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("upgrade websocket", "error", err)
+		return
+	}
+	defer con.Close()
+
+	sub := &subscriber{
+		events:            make(chan *models.Event, 100),
+		wantedCollections: query["wantedCollections"],
+	}
+
+	s.mu.Lock()
+	compress := s.supportsCompression && r.Header.Get("Socket-Encoding") == "zstd"
+	var backfill []*models.Event
+	if cursor != nil {
+		for _, event := range s.history {
+			if event.TimeUS >= *cursor && sub.wants(event) {
+				backfill = append(backfill, event)
+			}
+		}
+	}
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.closeSubscriber(sub)
+		s.mu.Unlock()
+	}()
+
+	for _, event := range backfill {
+		if err := s.write(con, event, compress); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.events:
+			if !ok {
+				_ = con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := s.write(con, event, compress); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) write(con *websocket.Conn, event *models.Event, compress bool) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if compress {
+		return con.WriteMessage(websocket.BinaryMessage, s.encoder.EncodeAll(b, nil))
+	}
+	return con.WriteMessage(websocket.TextMessage, b)
+}
+
+// closeSubscriber must be called with the lock held.
+func (s *Server) closeSubscriber(sub *subscriber) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	delete(s.subscribers, sub)
+}
+
+func (sub *subscriber) wants(event *models.Event) bool {
+	if len(sub.wantedCollections) == 0 || event.Commit == nil {
+		return true
+	}
+	return slices.Contains(sub.wantedCollections, event.Commit.Collection)
+}
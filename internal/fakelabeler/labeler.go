@@ -0,0 +1,232 @@
+// Package fakelabeler runs an in-process labeler serving com.atproto.label.subscribeLabels,
+// so that consuming labels can be tested without the network.
+package fakelabeler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/gorilla/websocket"
+)
+
+// Labeler is a fake labeler. Labels it emits are signed with its key, which is published in
+// its DID document along with its endpoint.
+type Labeler struct {
+	did       syntax.DID
+	key       crypto.PrivateKey
+	directory identity.MockDirectory
+
+	mu          sync.Mutex
+	seq         int64
+	history     []*comatproto.LabelSubscribeLabels_Labels
+	subscribers map[chan *comatproto.LabelSubscribeLabels_Labels]struct{}
+
+	upgrader websocket.Upgrader
+	srv      *httptest.Server
+}
+
+// New starts a labeler with the DID and no labels. Close should be called when it's no
+// longer needed.
+func New(did syntax.DID) (*Labeler, error) {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("get public key: %w", err)
+	}
+
+	l := &Labeler{
+		did:         did,
+		key:         key,
+		directory:   identity.NewMockDirectory(),
+		subscribers: make(map[chan *comatproto.LabelSubscribeLabels_Labels]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /xrpc/com.atproto.label.subscribeLabels", l.handleSubscribeLabels)
+	l.srv = httptest.NewServer(mux)
+
+	l.directory.Insert(identity.Identity{
+		DID: did,
+		Keys: map[string]identity.VerificationMethod{
+			"atproto_label": {
+				Type:               "Multikey",
+				PublicKeyMultibase: pub.Multibase(),
+			},
+		},
+		Services: map[string]identity.ServiceEndpoint{
+			"atproto_labeler": {
+				Type: "AtprotoLabeler",
+				URL:  l.srv.URL,
+			},
+		},
+	})
+	return l, nil
+}
+
+// Directory resolves the labeler's DID to its endpoint and signing key.
+func (l *Labeler) Directory() identity.Directory {
+	return &l.directory
+}
+
+// Close disconnects all subscribers and shuts down the labeler.
+func (l *Labeler) Close() {
+	l.mu.Lock()
+	for sub := range l.subscribers {
+		close(sub)
+		delete(l.subscribers, sub)
+	}
+	l.mu.Unlock()
+	l.srv.Close()
+}
+
+// Label applies a label with the value to the subject, which is a DID or an AT URI, and
+// emits it.
+func (l *Labeler) Label(subject, val string) error {
+	return l.emit(subject, val, false, l.key)
+}
+
+// Negate removes a label that was applied earlier and emits the negation.
+func (l *Labeler) Negate(subject, val string) error {
+	return l.emit(subject, val, true, l.key)
+}
+
+// LabelForged is the same as Label except the label is signed with a key that isn't the
+// labeler's, so consumers should reject it.
+func (l *Labeler) LabelForged(subject, val string) error {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	return l.emit(subject, val, false, key)
+}
+
+func (l *Labeler) emit(subject, val string, neg bool, key crypto.PrivateKey) error {
+	lbl := label.Label{
+		CreatedAt: syntax.DatetimeNow().String(),
+		SourceDID: l.did.String(),
+		URI:       subject,
+		Val:       val,
+		Version:   label.ATPROTO_LABEL_VERSION,
+	}
+	if neg {
+		lbl.Negated = &neg
+	}
+	if err := lbl.Sign(key); err != nil {
+		return fmt.Errorf("sign label: %w", err)
+	}
+	lexLabel := lbl.ToLexicon()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	evt := &comatproto.LabelSubscribeLabels_Labels{
+		Seq:    l.seq,
+		Labels: []*comatproto.LabelDefs_Label{&lexLabel},
+	}
+	l.history = append(l.history, evt)
+
+	for sub := range l.subscribers {
+		select {
+		case sub <- evt:
+		default:
+			// too slow, so disconnect it like a real labeler would
+			delete(l.subscribers, sub)
+			close(sub)
+		}
+	}
+	return nil
+}
+
+// handleSubscribeLabels streams labels. If a cursor is given, labels after it are replayed
+// first, otherwise only new labels are sent.
+func (l *Labeler) handleSubscribeLabels(w http.ResponseWriter, r *http.Request) {
+	cursor := int64(-1)
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		seq, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = seq
+	}
+
+	con, err := l.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("upgrade websocket", "error", err)
+		return
+	}
+	defer con.Close()
+
+	sub := make(chan *comatproto.LabelSubscribeLabels_Labels, 100)
+	l.mu.Lock()
+	var backfill []*comatproto.LabelSubscribeLabels_Labels
+	for _, evt := range l.history {
+		if cursor >= 0 && evt.Seq > cursor {
+			backfill = append(backfill, evt)
+		}
+	}
+	l.subscribers[sub] = struct{}{}
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		if _, ok := l.subscribers[sub]; ok {
+			delete(l.subscribers, sub)
+			close(sub)
+		}
+		l.mu.Unlock()
+	}()
+
+	for _, evt := range backfill {
+		if err := writeLabels(con, evt); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case evt, ok := <-sub:
+			if !ok {
+				_ = con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := writeLabels(con, evt); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeLabels writes a #labels frame. XRPCStreamEvent can't serialize labels, so the
+// header is written directly.
+func writeLabels(con *websocket.Conn, evt *comatproto.LabelSubscribeLabels_Labels) error {
+	wc, err := con.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	header := events.EventHeader{Op: events.EvtKindMessage, MsgType: "#labels"}
+	if err := header.MarshalCBOR(wc); err != nil {
+		return err
+	}
+	if err := evt.MarshalCBOR(wc); err != nil {
+		return err
+	}
+	return wc.Close()
+}
@@ -0,0 +1,287 @@
+// Package fakerelay runs an in-process relay serving com.atproto.sync.subscribeRepos for
+// accounts it hosts, so that ingesting from the firehose can be tested without the network.
+package fakerelay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/gorilla/websocket"
+
+	"github.com/willdot/statusphere-go/internal/testrepo"
+)
+
+const statusCollection = "xyz.statusphere.status"
+
+// Relay is a fake relay. It implements http.Handler so it can be served with httptest.
+type Relay struct {
+	mu          sync.Mutex
+	directory   identity.MockDirectory
+	repos       map[syntax.DID]*testrepo.Repo
+	seq         int64
+	history     []*events.XRPCStreamEvent
+	subscribers map[chan *events.XRPCStreamEvent]struct{}
+
+	upgrader websocket.Upgrader
+}
+
+// New creates a relay with no accounts.
+func New() *Relay {
+	return &Relay{
+		directory:   identity.NewMockDirectory(),
+		repos:       make(map[syntax.DID]*testrepo.Repo),
+		subscribers: make(map[chan *events.XRPCStreamEvent]struct{}),
+	}
+}
+
+// Directory resolves the accounts hosted by the relay, including their signing keys, so
+// it can be used to verify the commits the relay emits.
+func (r *Relay) Directory() identity.Directory {
+	return &r.directory
+}
+
+// CreateAccount creates an empty repo for the DID.
+func (r *Relay) CreateAccount(did syntax.DID, handle syntax.Handle) error {
+	repo, err := testrepo.New(did)
+	if err != nil {
+		return err
+	}
+	ident, err := repo.Identity(handle, "")
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repos[did] = repo
+	r.directory.Insert(ident)
+	return nil
+}
+
+// CreateStatus writes a status record to the account's repo and emits the commit.
+func (r *Relay) CreateStatus(ctx context.Context, did syntax.DID, rkey, status string, createdAt time.Time) error {
+	repo, err := r.repo(did)
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CreateRecord(ctx, statusCollection, rkey, statusRecord(status, createdAt))
+	if err != nil {
+		return fmt.Errorf("create record: %w", err)
+	}
+	r.emit(commit)
+	return nil
+}
+
+// CreateForgedStatus is the same as CreateStatus except the commit is signed with a key
+// that isn't the account's, so consumers should reject it.
+func (r *Relay) CreateForgedStatus(ctx context.Context, did syntax.DID, rkey, status string, createdAt time.Time) error {
+	repo, err := r.repo(did)
+	if err != nil {
+		return err
+	}
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return fmt.Errorf("generate key: %w", err)
+	}
+	commit, err := repo.CreateRecordSignedBy(ctx, key, statusCollection, rkey, statusRecord(status, createdAt))
+	if err != nil {
+		return fmt.Errorf("create record: %w", err)
+	}
+	r.emit(commit)
+	return nil
+}
+
+// DeleteStatus removes a status record from the account's repo and emits the commit.
+func (r *Relay) DeleteStatus(ctx context.Context, did syntax.DID, rkey string) error {
+	repo, err := r.repo(did)
+	if err != nil {
+		return err
+	}
+	commit, err := repo.DeleteRecord(ctx, statusCollection, rkey)
+	if err != nil {
+		return fmt.Errorf("delete record: %w", err)
+	}
+	r.emit(commit)
+	return nil
+}
+
+// Close disconnects all subscribers.
+func (r *Relay) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for sub := range r.subscribers {
+		close(sub)
+		delete(r.subscribers, sub)
+	}
+}
+
+// ServeHTTP serves com.atproto.sync.subscribeRepos. If a cursor is given, events after it
+// are replayed before new events are streamed.
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/xrpc/com.atproto.sync.subscribeRepos" {
+		http.NotFound(w, req)
+		return
+	}
+
+	var cursor *int64
+	if c := req.URL.Query().Get("cursor"); c != "" {
+		seq, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = &seq
+	}
+
+	con, err := r.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		slog.Error("upgrade websocket", "error", err)
+		return
+	}
+	defer con.Close()
+
+	sub := make(chan *events.XRPCStreamEvent, 100)
+	r.mu.Lock()
+	var backfill []*events.XRPCStreamEvent
+	if cursor != nil {
+		for _, evt := range r.history {
+			if evt.RepoCommit.Seq > *cursor {
+				backfill = append(backfill, evt)
+			}
+		}
+	}
+	r.subscribers[sub] = struct{}{}
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		if _, ok := r.subscribers[sub]; ok {
+			delete(r.subscribers, sub)
+			close(sub)
+		}
+		r.mu.Unlock()
+	}()
+
+	for _, evt := range backfill {
+		if err := writeEvent(con, evt); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case evt, ok := <-sub:
+			if !ok {
+				_ = con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+			if err := writeEvent(con, evt); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (r *Relay) repo(did syntax.DID) (*testrepo.Repo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	repo, ok := r.repos[did]
+	if !ok {
+		return nil, fmt.Errorf("no account for %s", did)
+	}
+	return repo, nil
+}
+
+func (r *Relay) emit(commit *testrepo.Commit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	evt := &events.XRPCStreamEvent{
+		RepoCommit: CommitEvent(commit, r.seq),
+	}
+	r.history = append(r.history, evt)
+
+	for sub := range r.subscribers {
+		select {
+		case sub <- evt:
+		default:
+			// too slow, so disconnect it like a real relay would
+			delete(r.subscribers, sub)
+			close(sub)
+		}
+	}
+}
+
+func writeEvent(con *websocket.Conn, evt *events.XRPCStreamEvent) error {
+	wc, err := con.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if err := evt.Serialize(wc); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// CommitEvent converts a commit into the #commit event the relay emits for it with the given
+// sequence number.
+func CommitEvent(commit *testrepo.Commit, seq int64) *comatproto.SyncSubscribeRepos_Commit {
+	ops := make([]*comatproto.SyncSubscribeRepos_RepoOp, 0, len(commit.Ops))
+	for _, op := range commit.Ops {
+		repoOp := &comatproto.SyncSubscribeRepos_RepoOp{
+			Action: op.Action,
+			Path:   op.Path,
+		}
+		if op.CID != nil {
+			link := lexutil.LexLink(*op.CID)
+			repoOp.Cid = &link
+		}
+		if op.Prev != nil {
+			link := lexutil.LexLink(*op.Prev)
+			repoOp.Prev = &link
+		}
+		ops = append(ops, repoOp)
+	}
+
+	evt := &comatproto.SyncSubscribeRepos_Commit{
+		Blobs:  []lexutil.LexLink{},
+		Blocks: commit.Blocks,
+		Commit: lexutil.LexLink(commit.CID),
+		Ops:    ops,
+		Repo:   commit.DID.String(),
+		Rev:    commit.Rev,
+		Seq:    seq,
+		Since:  commit.Since,
+		Time:   syntax.DatetimeNow().String(),
+	}
+	if commit.PrevData != nil {
+		link := lexutil.LexLink(*commit.PrevData)
+		evt.PrevData = &link
+	}
+	return evt
+}
+
+func statusRecord(status string, createdAt time.Time) map[string]any {
+	return map[string]any{
+		"$type":     statusCollection,
+		"status":    status,
+		"createdAt": createdAt.UTC().Format(time.RFC3339Nano),
+	}
+}
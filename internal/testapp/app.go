@@ -0,0 +1,268 @@
+// Package testapp serves the app against an in-process fake PDS for tests, with a browser
+// that can log in to it as a test account. Tests using it must run from the root of the repo
+// so the templates can be found.
+package testapp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+	"github.com/willdot/statusphere-go/internal/testpds"
+)
+
+const (
+	// DID and Handle are the test account, which is hosted by the fake PDS and is an admin
+	DID    = syntax.DID("did:plc:testaccount")
+	Handle = syntax.Handle("alice.test")
+	// DisplayName is the test account's display name, which the home page shows once logged in
+	DisplayName = "Alice"
+	// LabelerDID is the DID the app publishes its moderation decisions as
+	LabelerDID = syntax.DID("did:plc:testapplabeler")
+)
+
+// App is the app served by httptest, the database and fake PDS it uses and a browser with a
+// cookie jar to drive it.
+type App struct {
+	URL        string
+	DB         *database.DB
+	PDS        *testpds.PDS
+	Browser    *http.Client
+	LabelerKey crypto.PrivateKey
+}
+
+// New serves the app with a new database, seeded with the default palette, and a fake PDS
+// hosting the test account. Everything is closed when the test finishes. The browser isn't
+// logged in.
+func New(t testing.TB, opts ...statusphere.ServerOption) *App {
+	t.Helper()
+
+	db, err := database.New(path.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatalf("create database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	pds := testpds.New()
+	t.Cleanup(pds.Close)
+	if err := pds.CreateAccount(DID, Handle, DisplayName); err != nil {
+		t.Fatalf("create account: %s", err)
+	}
+
+	// the app is served before the server is created so that its URL is known for the
+	// OAuth callback
+	var handler http.Handler
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	config := oauth.NewLocalhostConfig(srv.URL+"/oauth-callback", []string{"atproto", "transition:generic"})
+	oauthClient := oauth.NewClientApp(&config, db)
+	oauthClient.Client = pds.Client()
+	oauthClient.Resolver.Client = pds.Client()
+	oauthClient.Dir = pds.Directory()
+
+	t.Setenv("SESSION_KEY", "test-session-key")
+	// the test account is an admin, unless the test has set who is
+	if _, ok := os.LookupEnv("ADMIN_DIDS"); !ok {
+		t.Setenv("ADMIN_DIDS", DID.String())
+	}
+	if err := statusphere.SeedPalette(context.Background(), db, statusphere.DefaultPalette); err != nil {
+		t.Fatalf("seed palette: %s", err)
+	}
+	labelerKey, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatalf("generate labeler key: %s", err)
+	}
+	opts = append([]statusphere.ServerOption{statusphere.WithLabeler(LabelerDID, labelerKey)}, opts...)
+	server, err := statusphere.NewServer(srv.URL, 0, db, oauthClient, pds.Client(), opts...)
+	if err != nil {
+		t.Fatalf("create server: %s", err)
+	}
+	handler = server.Handler()
+	t.Cleanup(func() { _ = server.Stop(context.Background()) })
+
+	app := &App{
+		URL:        srv.URL,
+		DB:         db,
+		PDS:        pds,
+		LabelerKey: labelerKey,
+	}
+	app.Browser = app.NewBrowser(t)
+	return app
+}
+
+// NewLoggedIn is New with the browser logged in as the test account.
+func NewLoggedIn(t testing.TB, opts ...statusphere.ServerOption) *App {
+	t.Helper()
+
+	app := New(t, opts...)
+	app.LogIn(t, app.Browser)
+	return app
+}
+
+// NewBrowser returns a browser with an empty cookie jar, which can reach both the app and the
+// PDS's authorize endpoint.
+func (a *App) NewBrowser(t testing.TB) *http.Client {
+	t.Helper()
+
+	browser := a.PDS.Client()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("create cookie jar: %s", err)
+	}
+	browser.Jar = jar
+	return browser
+}
+
+// LogIn logs the browser in as the test account, failing the test if it doesn't end up on
+// the home page.
+func (a *App) LogIn(t testing.TB, browser *http.Client) {
+	t.Helper()
+
+	resp, body := a.do(t, browser, http.MethodPost, "/login", url.Values{"handle": {Handle.String()}})
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/" {
+		t.Fatalf("log in: expected to end up on / with 200, got %s with %d: %s", resp.Request.URL.Path, resp.StatusCode, body)
+	}
+	if !strings.Contains(body, DisplayName) {
+		t.Fatalf("log in: home page doesn't show the logged in user's display name")
+	}
+}
+
+// Get gets the page with the browser, following redirects, and returns the response and its
+// body.
+func (a *App) Get(t testing.TB, p string) (*http.Response, string) {
+	t.Helper()
+	return a.do(t, a.Browser, http.MethodGet, p, nil)
+}
+
+// PostForm posts the form with the browser, following redirects, and returns the response
+// and its body.
+func (a *App) PostForm(t testing.TB, p string, form url.Values) (*http.Response, string) {
+	t.Helper()
+	return a.do(t, a.Browser, http.MethodPost, p, form)
+}
+
+func (a *App) do(t testing.TB, browser *http.Client, method, p string, form url.Values) (*http.Response, string) {
+	t.Helper()
+
+	var resp *http.Response
+	var err error
+	if method == http.MethodPost {
+		resp, err = browser.PostForm(a.URL+p, form)
+	} else {
+		resp, err = browser.Get(a.URL + p)
+	}
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, p, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: read body: %s", method, p, err)
+	}
+	return resp, string(b)
+}
+
+// APIStatus is a status returned by the statuses API.
+type APIStatus struct {
+	URI    string                 `json:"uri"`
+	Did    string                 `json:"did"`
+	Ref    *statusphere.StrongRef `json:"ref"`
+	Flag   string                 `json:"flag"`
+	Labels []string               `json:"labels"`
+}
+
+// APIStatuses returns the statuses the statuses API returns to the browser.
+func (a *App) APIStatuses(t testing.TB) []APIStatus {
+	t.Helper()
+
+	resp, body := a.Get(t, "/api/statuses")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get statuses from the API: %d: %s", resp.StatusCode, body)
+	}
+	var statuses struct {
+		Statuses []APIStatus `json:"statuses"`
+	}
+	if err := json.Unmarshal([]byte(body), &statuses); err != nil {
+		t.Fatalf("decode statuses: %s", err)
+	}
+	return statuses.Statuses
+}
+
+// SetCookie sets a cookie for the app in the browser, or deletes it if value is empty.
+func (a *App) SetCookie(name, value string) {
+	appURL, _ := url.Parse(a.URL)
+	cookie := &http.Cookie{Name: name, Value: value}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	a.Browser.Jar.SetCookies(appURL, []*http.Cookie{cookie})
+}
+
+// ExpectPath fails the test if the browser didn't end up on the page, after following
+// redirects.
+func ExpectPath(t testing.TB, resp *http.Response, p string) {
+	t.Helper()
+
+	if resp.Request.URL.Path != p {
+		t.Fatalf("expected to end up on %s, got %s (%d)", p, resp.Request.URL, resp.StatusCode)
+	}
+}
+
+// NewStatus returns a status posted now by the account, with a new record key, which hasn't
+// been stored.
+func NewStatus(did syntax.DID, status string) statusphere.Status {
+	now := time.Now()
+	return statusphere.Status{
+		URI:       "at://" + did.String() + "/xyz.statusphere.status/" + syntax.NewTIDNow(0).String(),
+		Did:       did.String(),
+		Status:    status,
+		CreatedAt: now.UnixMilli(),
+		IndexedAt: now.UnixMilli(),
+	}
+}
+
+// CreateStatus stores the status in the app's database, failing the test if it can't.
+func (a *App) CreateStatus(t testing.TB, status statusphere.Status) {
+	t.Helper()
+
+	if err := a.DB.CreateStatus(context.Background(), status); err != nil {
+		t.Fatalf("store status: %s", err)
+	}
+}
+
+// WaitFor calls check until it succeeds or 5 seconds have passed, failing the test with its
+// last error if it never does.
+func WaitFor(t testing.TB, check func() error) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
@@ -0,0 +1,413 @@
+package testpds
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// authRequest is a pushed authorization request and, once it's been approved, the
+// account it was approved for.
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	state         string
+	scope         string
+	codeChallenge string
+	loginHint     string
+	// the DPoP key the request was made with, which every later request must use
+	dpopKey string
+	did     syntax.DID
+}
+
+// grant is what an access or refresh token was issued for.
+type grant struct {
+	did      syntax.DID
+	clientID string
+	scope    string
+	dpopKey  string
+}
+
+var errUseDPoPNonce = errors.New("use_dpop_nonce")
+
+func (p *PDS) handleProtectedResource(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"resource":              p.URL(),
+		"authorization_servers": []string{p.URL()},
+	})
+}
+
+func (p *PDS) handleAuthServerMetadata(w http.ResponseWriter, r *http.Request) {
+	// indigo's metadata doesn't have the revocation endpoint, so it's added alongside
+	type metadata struct {
+		oauth.AuthServerMetadata
+		RevocationEndpoint string `json:"revocation_endpoint"`
+	}
+	writeJSON(w, http.StatusOK, metadata{RevocationEndpoint: p.URL() + "/oauth/revoke", AuthServerMetadata: oauth.AuthServerMetadata{
+		Issuer:                                     p.URL(),
+		AuthorizationEndpoint:                      p.URL() + "/oauth/authorize",
+		TokenEndpoint:                              p.URL() + "/oauth/token",
+		PushedAuthorizationRequestEndpoint:         p.URL() + "/oauth/par",
+		ResponseTypesSupported:                     []string{"code"},
+		GrantTypesSupported:                        []string{"authorization_code", "refresh_token"},
+		CodeChallengeMethodsSupported:              []string{"S256"},
+		TokenEndpointAuthMethodsSupoorted:          []string{"none", "private_key_jwt"},
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"ES256"},
+		ScopesSupported:                            []string{"atproto", "transition:generic"},
+		AuthorizationReponseISSParameterSupported:  true,
+		RequirePushedAuthorizationRequests:         true,
+		DPoPSigningAlgValuesSupported:              []string{"ES256"},
+		ClientIDMetadataDocumentSupported:          true,
+	}})
+}
+
+func (p *PDS) handlePAR(w http.ResponseWriter, r *http.Request) {
+	dpopKey, err := p.verifyDPoP(r, "")
+	if err != nil {
+		p.writeOAuthDPoPError(w, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	req := &authRequest{
+		clientID:      r.PostForm.Get("client_id"),
+		redirectURI:   r.PostForm.Get("redirect_uri"),
+		state:         r.PostForm.Get("state"),
+		scope:         r.PostForm.Get("scope"),
+		codeChallenge: r.PostForm.Get("code_challenge"),
+		loginHint:     r.PostForm.Get("login_hint"),
+		dpopKey:       dpopKey,
+	}
+	switch {
+	case req.clientID == "" || req.redirectURI == "" || req.state == "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "missing client_id, redirect_uri or state")
+		return
+	case r.PostForm.Get("response_type") != "code":
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_response_type", "response_type must be code")
+		return
+	case req.codeChallenge == "" || r.PostForm.Get("code_challenge_method") != "S256":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "S256 code challenge required")
+		return
+	case !strings.Contains(" "+req.scope+" ", " atproto "):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "atproto scope required")
+		return
+	}
+
+	requestURI := requestURIPrefix + randomToken()
+
+	p.mu.Lock()
+	p.requests[requestURI] = req
+	p.mu.Unlock()
+
+	w.Header().Set("DPoP-Nonce", p.nonce())
+	writeJSON(w, http.StatusCreated, oauth.PushedAuthResponse{
+		RequestURI: requestURI,
+		ExpiresIn:  60,
+	})
+}
+
+// handleAuthorize approves the request for the account in the login hint straight away,
+// rather than asking the user to sign in, and redirects back to the client.
+func (p *PDS) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	requestURI := r.URL.Query().Get("request_uri")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req, ok := p.requests[requestURI]
+	if !ok || req.clientID != r.URL.Query().Get("client_id") {
+		http.Error(w, "unknown request_uri", http.StatusBadRequest)
+		return
+	}
+	delete(p.requests, requestURI)
+
+	redirect, err := url.Parse(req.redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("state", req.state)
+	params.Set("iss", p.URL())
+
+	did, err := p.loginHintDID(req.loginHint)
+	if err != nil {
+		params.Set("error", "access_denied")
+		params.Set("error_description", err.Error())
+	} else {
+		req.did = did
+		code := randomToken()
+		p.codes[code] = req
+		params.Set("code", code)
+	}
+
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// loginHintDID must be called with the lock held.
+func (p *PDS) loginHintDID(loginHint string) (syntax.DID, error) {
+	atid, err := syntax.ParseAtIdentifier(loginHint)
+	if err != nil {
+		return "", fmt.Errorf("no account to sign in as")
+	}
+	var did syntax.DID
+	if atid.IsDID() {
+		did, _ = atid.AsDID()
+	} else {
+		handle, _ := atid.AsHandle()
+		did = p.handles[handle.Normalize()]
+	}
+	if _, ok := p.accounts[did]; !ok {
+		return "", fmt.Errorf("no account for %s", loginHint)
+	}
+	return did, nil
+}
+
+func (p *PDS) handleToken(w http.ResponseWriter, r *http.Request) {
+	dpopKey, err := p.verifyDPoP(r, "")
+	if err != nil {
+		p.writeOAuthDPoPError(w, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	var g *grant
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		g, err = p.exchangeCode(r.PostForm, dpopKey)
+	case "refresh_token":
+		g, err = p.refresh(r.PostForm, dpopKey)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "unsupported grant_type")
+		return
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+
+	accessToken := randomToken()
+	refreshToken := randomToken()
+
+	p.mu.Lock()
+	p.accessTokens[accessToken] = g
+	p.refreshTokens[refreshToken] = g
+	p.mu.Unlock()
+
+	w.Header().Set("DPoP-Nonce", p.nonce())
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "DPoP",
+		"expires_in":    3600,
+		"refresh_token": refreshToken,
+		"scope":         g.scope,
+		"sub":           g.did.String(),
+	})
+}
+
+func (p *PDS) exchangeCode(form url.Values, dpopKey string) (*grant, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	code := form.Get("code")
+	req, ok := p.codes[code]
+	if !ok {
+		return nil, fmt.Errorf("unknown code")
+	}
+	delete(p.codes, code)
+
+	switch {
+	case req.clientID != form.Get("client_id"):
+		return nil, fmt.Errorf("client_id doesn't match")
+	case req.redirectURI != form.Get("redirect_uri"):
+		return nil, fmt.Errorf("redirect_uri doesn't match")
+	case req.dpopKey != dpopKey:
+		return nil, fmt.Errorf("DPoP key doesn't match")
+	case oauth.S256CodeChallenge(form.Get("code_verifier")) != req.codeChallenge:
+		return nil, fmt.Errorf("code_verifier doesn't match")
+	}
+
+	return &grant{did: req.did, clientID: req.clientID, scope: req.scope, dpopKey: dpopKey}, nil
+}
+
+func (p *PDS) refresh(form url.Values, dpopKey string) (*grant, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refreshToken := form.Get("refresh_token")
+	g, ok := p.refreshTokens[refreshToken]
+	if !ok {
+		return nil, fmt.Errorf("unknown refresh_token")
+	}
+	if g.clientID != form.Get("client_id") || g.dpopKey != dpopKey {
+		return nil, fmt.Errorf("refresh_token wasn't issued to this client")
+	}
+	delete(p.refreshTokens, refreshToken)
+	return g, nil
+}
+
+// handleRevoke revokes the grant that an access or refresh token was issued for, so that
+// none of its tokens can be used. Unknown tokens are ignored, as RFC 7009 requires.
+func (p *PDS) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	dpopKey, err := p.verifyDPoP(r, "")
+	if err != nil {
+		p.writeOAuthDPoPError(w, err)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	if err := p.revoke(r.PostForm, dpopKey); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", err.Error())
+		return
+	}
+
+	w.Header().Set("DPoP-Nonce", p.nonce())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *PDS) revoke(form url.Values, dpopKey string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token := form.Get("token")
+	g, ok := p.refreshTokens[token]
+	if !ok {
+		g, ok = p.accessTokens[token]
+	}
+	if !ok {
+		return nil
+	}
+	if g.clientID != form.Get("client_id") || g.dpopKey != dpopKey {
+		return fmt.Errorf("token wasn't issued to this client")
+	}
+
+	for t, tg := range p.accessTokens {
+		if tg == g {
+			delete(p.accessTokens, t)
+		}
+	}
+	for t, tg := range p.refreshTokens {
+		if tg == g {
+			delete(p.refreshTokens, t)
+		}
+	}
+	return nil
+}
+
+// authorize checks the DPoP bound access token of a request to the PDS.
+func (p *PDS) authorize(w http.ResponseWriter, r *http.Request) (*grant, bool) {
+	accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "DPoP ")
+	if !ok {
+		writeXRPCError(w, http.StatusUnauthorized, "AuthMissing", "DPoP access token required")
+		return nil, false
+	}
+
+	dpopKey, err := p.verifyDPoP(r, accessToken)
+	if errors.Is(err, errUseDPoPNonce) {
+		w.Header().Set("DPoP-Nonce", p.nonce())
+		w.Header().Set("WWW-Authenticate", `DPoP error="use_dpop_nonce"`)
+		writeXRPCError(w, http.StatusUnauthorized, "use_dpop_nonce", "DPoP nonce required")
+		return nil, false
+	}
+	if err != nil {
+		writeXRPCError(w, http.StatusUnauthorized, "InvalidToken", err.Error())
+		return nil, false
+	}
+
+	p.mu.Lock()
+	g, ok := p.accessTokens[accessToken]
+	p.mu.Unlock()
+	if !ok || g.dpopKey != dpopKey {
+		w.Header().Set("WWW-Authenticate", `DPoP error="invalid_token"`)
+		writeXRPCError(w, http.StatusUnauthorized, "InvalidToken", "invalid access token")
+		return nil, false
+	}
+	return g, true
+}
+
+// verifyDPoP checks the request's DPoP proof and returns the public key it was signed
+// with. If accessToken isn't empty, the proof must be bound to it.
+func (p *PDS) verifyDPoP(r *http.Request, accessToken string) (string, error) {
+	proof := r.Header.Get("DPoP")
+	if proof == "" {
+		return "", fmt.Errorf("missing DPoP proof")
+	}
+
+	var key crypto.PublicKey
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (any, error) {
+		if token.Header["typ"] != "dpop+jwt" {
+			return nil, fmt.Errorf("DPoP proof has wrong typ")
+		}
+		jwk, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		key, err = crypto.ParsePublicJWKBytes(jwk)
+		return key, err
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP proof: %w", err)
+	}
+
+	if claims["htm"] != r.Method || claims["htu"] != p.URL()+r.URL.Path {
+		return "", fmt.Errorf("DPoP proof is for a different request")
+	}
+	if accessToken != "" && claims["ath"] != oauth.S256CodeChallenge(accessToken) {
+		return "", fmt.Errorf("DPoP proof is for a different access token")
+	}
+	if claims["nonce"] != p.nonce() {
+		return "", errUseDPoPNonce
+	}
+
+	return key.Multibase(), nil
+}
+
+func (p *PDS) nonce() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dpopNonce
+}
+
+// writeOAuthDPoPError tells the client to retry with the current nonce if that's what was
+// wrong with the proof.
+func (p *PDS) writeOAuthDPoPError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUseDPoPNonce) {
+		w.Header().Set("DPoP-Nonce", p.nonce())
+		writeOAuthError(w, http.StatusBadRequest, "use_dpop_nonce", "DPoP nonce required")
+		return
+	}
+	writeOAuthError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
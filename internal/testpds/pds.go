@@ -0,0 +1,274 @@
+// Package testpds runs an in-process PDS that is also its own OAuth authorization server,
+// so that logging in and writing records can be exercised end to end without the network.
+//
+// It implements just enough of atproto OAuth for indigo's client: the protected resource and
+// authorization server metadata, PAR, an authorize endpoint that approves every request,
+// and the token endpoint, all with DPoP. As a PDS it serves com.atproto.repo.createRecord,
+// com.atproto.repo.listRecords, com.atproto.sync.getRepo and app.bsky.actor.getProfile, and
+// resolves handles for the accounts it hosts.
+package testpds
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/ipfs/go-cid"
+
+	"github.com/willdot/statusphere-go/internal/testrepo"
+)
+
+// Hostname is the host the PDS is served on. It's only reachable using the client returned
+// by Client.
+const Hostname = "pds.test"
+
+type account struct {
+	repo        *testrepo.Repo
+	handle      syntax.Handle
+	displayName string
+}
+
+// PDS is a fake PDS and OAuth authorization server.
+type PDS struct {
+	mu        sync.Mutex
+	directory identity.MockDirectory
+	accounts  map[syntax.DID]*account
+	handles   map[syntax.Handle]syntax.DID
+
+	// OAuth state, keyed by request URI, authorization code and token
+	requests      map[string]*authRequest
+	codes         map[string]*authRequest
+	accessTokens  map[string]*grant
+	refreshTokens map[string]*grant
+	dpopNonce     string
+
+	srv *httptest.Server
+}
+
+// New starts a PDS with no accounts. Close should be called when it's no longer needed.
+func New() *PDS {
+	p := &PDS{
+		directory:     identity.NewMockDirectory(),
+		accounts:      make(map[syntax.DID]*account),
+		handles:       make(map[syntax.Handle]syntax.DID),
+		requests:      make(map[string]*authRequest),
+		codes:         make(map[string]*authRequest),
+		accessTokens:  make(map[string]*grant),
+		refreshTokens: make(map[string]*grant),
+		dpopNonce:     randomToken(),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/atproto-did", p.handleResolveHandle)
+	mux.HandleFunc("GET /.well-known/oauth-protected-resource", p.handleProtectedResource)
+	mux.HandleFunc("GET /.well-known/oauth-authorization-server", p.handleAuthServerMetadata)
+	mux.HandleFunc("POST /oauth/par", p.handlePAR)
+	mux.HandleFunc("GET /oauth/authorize", p.handleAuthorize)
+	mux.HandleFunc("POST /oauth/token", p.handleToken)
+	mux.HandleFunc("POST /oauth/revoke", p.handleRevoke)
+	mux.HandleFunc("POST /xrpc/com.atproto.repo.createRecord", p.handleCreateRecord)
+	mux.HandleFunc("GET /xrpc/com.atproto.repo.listRecords", p.handleListRecords)
+	mux.HandleFunc("GET /xrpc/com.atproto.sync.getRepo", p.handleGetRepo)
+	mux.HandleFunc("GET /xrpc/app.bsky.actor.getProfile", p.handleGetProfile)
+
+	p.srv = httptest.NewTLSServer(mux)
+	return p
+}
+
+// URL is the base URL of the PDS, which is also the issuer of the authorization server.
+func (p *PDS) URL() string {
+	return "https://" + Hostname
+}
+
+// Close shuts down the PDS.
+func (p *PDS) Close() {
+	p.srv.Close()
+}
+
+// Client returns an HTTP client that sends requests for any hostname to the PDS, so that
+// the PDS also stands in for other services such as the AppView. Requests to IP addresses,
+// such as an httptest server for the app under test, are sent as normal.
+func (p *PDS) Client() *http.Client {
+	pdsAddr := p.srv.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				host, _, err := net.SplitHostPort(addr)
+				if err == nil && net.ParseIP(host) == nil {
+					addr = pdsAddr
+				}
+				return dialer.DialContext(ctx, network, addr)
+			},
+			// the certificate is httptest's, which isn't for the PDS hostname
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// Directory resolves the accounts hosted by the PDS.
+func (p *PDS) Directory() identity.Directory {
+	return &p.directory
+}
+
+// CreateRecord writes a record to an account's repo directly, as if it was created by
+// another app, and returns its URI.
+func (p *PDS) CreateRecord(ctx context.Context, did syntax.DID, collection, rkey string, record map[string]any) (syntax.ATURI, error) {
+	acc, err := p.account(did)
+	if err != nil {
+		return "", err
+	}
+	if _, err := acc.repo.CreateRecord(ctx, collection, rkey, record); err != nil {
+		return "", err
+	}
+	return syntax.ATURI(fmt.Sprintf("at://%s/%s/%s", did, collection, rkey)), nil
+}
+
+// CreateAccount creates an account with an empty repo.
+func (p *PDS) CreateAccount(did syntax.DID, handle syntax.Handle, displayName string) error {
+	repo, err := testrepo.New(did)
+	if err != nil {
+		return err
+	}
+	ident, err := repo.Identity(handle, p.URL())
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.accounts[did] = &account{repo: repo, handle: handle, displayName: displayName}
+	p.handles[handle.Normalize()] = did
+	p.directory.Insert(ident)
+	return nil
+}
+
+// GetRecord returns a record from an account's repo along with its CID.
+func (p *PDS) GetRecord(ctx context.Context, did syntax.DID, collection, rkey string) (map[string]any, cid.Cid, error) {
+	acc, err := p.account(did)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	return acc.repo.GetRecord(ctx, collection, rkey)
+}
+
+// RevokeSessions invalidates all tokens issued for the account, as if the user had signed
+// out of the app from their PDS.
+func (p *PDS) RevokeSessions(did syntax.DID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for token, g := range p.accessTokens {
+		if g.did == did {
+			delete(p.accessTokens, token)
+		}
+	}
+	for token, g := range p.refreshTokens {
+		if g.did == did {
+			delete(p.refreshTokens, token)
+		}
+	}
+}
+
+// ActiveTokens returns how many access and refresh tokens issued for the account haven't
+// been revoked or used up.
+func (p *PDS) ActiveTokens(did syntax.DID) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	active := 0
+	for _, g := range p.accessTokens {
+		if g.did == did {
+			active++
+		}
+	}
+	for _, g := range p.refreshTokens {
+		if g.did == did {
+			active++
+		}
+	}
+	return active
+}
+
+func (p *PDS) account(did syntax.DID) (*account, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	acc, ok := p.accounts[did]
+	if !ok {
+		return nil, fmt.Errorf("no account for %s", did)
+	}
+	return acc, nil
+}
+
+// handleResolveHandle serves the HTTPS well-known method of handle resolution. The handle
+// is the hostname the request was made to.
+func (p *PDS) handleResolveHandle(w http.ResponseWriter, r *http.Request) {
+	handle, err := syntax.ParseHandle(r.Host)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.mu.Lock()
+	did, ok := p.handles[handle.Normalize()]
+	p.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, did.String())
+}
+
+func (p *PDS) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	actor, err := syntax.ParseAtIdentifier(r.URL.Query().Get("actor"))
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid actor")
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var did syntax.DID
+	if actor.IsDID() {
+		did, _ = actor.AsDID()
+	} else {
+		handle, _ := actor.AsHandle()
+		did = p.handles[handle.Normalize()]
+	}
+	acc, ok := p.accounts[did]
+	if !ok {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "profile not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"did":         did.String(),
+		"handle":      acc.handle.String(),
+		"displayName": acc.displayName,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("write response", "error", err)
+	}
+}
+
+func writeXRPCError(w http.ResponseWriter, status int, name, message string) {
+	writeJSON(w, status, map[string]string{"error": name, "message": message})
+}
@@ -0,0 +1,164 @@
+package testpds
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+type createRecordRequest struct {
+	Repo       string          `json:"repo"`
+	Collection string          `json:"collection"`
+	RKey       string          `json:"rkey"`
+	Record     json.RawMessage `json:"record"`
+}
+
+func (p *PDS) handleCreateRecord(w http.ResponseWriter, r *http.Request) {
+	g, ok := p.authorize(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "read body")
+		return
+	}
+	var req createRecordRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid body")
+		return
+	}
+
+	repoID, err := syntax.ParseAtIdentifier(req.Repo)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid repo")
+		return
+	}
+	if repoDID, _ := repoID.AsDID(); repoDID != g.did {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "can only write to the authenticated account's repo")
+		return
+	}
+	if _, err := syntax.ParseNSID(req.Collection); err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid collection")
+		return
+	}
+	rkey := req.RKey
+	if rkey == "" {
+		rkey = syntax.NewTIDNow(0).String()
+	}
+
+	record, err := data.UnmarshalJSON(req.Record)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("invalid record: %s", err))
+		return
+	}
+	// like a real PDS, the type is filled in from the collection if it's missing
+	if _, ok := record["$type"]; !ok {
+		record["$type"] = req.Collection
+	}
+
+	acc, err := p.account(g.did)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", err.Error())
+		return
+	}
+	commit, err := acc.repo.CreateRecord(r.Context(), req.Collection, rkey, record)
+	if err != nil {
+		writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"uri": fmt.Sprintf("at://%s/%s/%s", g.did, req.Collection, rkey),
+		"cid": commit.Ops[0].CID.String(),
+		"commit": map[string]string{
+			"cid": commit.CID.String(),
+			"rev": commit.Rev,
+		},
+	})
+}
+
+// handleListRecords serves com.atproto.repo.listRecords in ascending rkey order, using the
+// last rkey of a page as the cursor.
+func (p *PDS) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	did, err := syntax.ParseDID(query.Get("repo"))
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid repo")
+		return
+	}
+	collection := query.Get("collection")
+	limit := 50
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= 100 {
+		limit = l
+	}
+	cursor := query.Get("cursor")
+
+	acc, err := p.account(did)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", err.Error())
+		return
+	}
+	rkeys, err := acc.repo.ListRecordKeys(collection)
+	if err != nil {
+		writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+
+	records := []map[string]any{}
+	var nextCursor string
+	for _, rkey := range rkeys {
+		if rkey <= cursor {
+			continue
+		}
+		if len(records) == limit {
+			break
+		}
+		record, recordCID, err := acc.repo.GetRecord(r.Context(), collection, rkey)
+		if err != nil {
+			writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+			return
+		}
+		records = append(records, map[string]any{
+			"uri":   fmt.Sprintf("at://%s/%s/%s", did, collection, rkey),
+			"cid":   recordCID.String(),
+			"value": record,
+		})
+		nextCursor = rkey
+	}
+
+	resp := map[string]any{"records": records}
+	if len(records) == limit {
+		resp["cursor"] = nextCursor
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetRepo serves com.atproto.sync.getRepo, exporting the whole repo as a CAR file.
+func (p *PDS) handleGetRepo(w http.ResponseWriter, r *http.Request) {
+	did, err := syntax.ParseDID(r.URL.Query().Get("did"))
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid did")
+		return
+	}
+	acc, err := p.account(did)
+	if err != nil {
+		writeXRPCError(w, http.StatusBadRequest, "RepoNotFound", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := acc.repo.WriteCAR(&buf); err != nil {
+		writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.ipld.car")
+	_, _ = w.Write(buf.Bytes())
+}
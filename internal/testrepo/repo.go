@@ -0,0 +1,407 @@
+// Package testrepo provides an in-memory atproto repository that signs its own commits, for
+// use by the fake relay and PDS servers that ingestion and login are tested against.
+package testrepo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+var cidBuilder = cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}
+
+// Op is a single record operation in a commit, matching the firehose repo op.
+type Op struct {
+	Action string
+	Path   string
+	CID    *cid.Cid
+	Prev   *cid.Cid
+}
+
+// Commit is the result of writing to the repo, containing everything needed to emit a
+// firehose #commit event.
+type Commit struct {
+	DID      syntax.DID
+	Rev      string
+	Since    *string
+	CID      cid.Cid
+	PrevData *cid.Cid
+	Ops      []Op
+	// Blocks is a CAR file containing the commit and the blocks that changed in it.
+	Blocks []byte
+}
+
+// Repo is an in-memory repository for a single account.
+type Repo struct {
+	mu sync.Mutex
+
+	did   syntax.DID
+	key   crypto.PrivateKey
+	clock syntax.TIDClock
+	tree  mst.Tree
+	rev   string
+	head  cid.Cid
+	// the root of the tree as of the last commit
+	data *cid.Cid
+	// every block that has been written, so the whole repo can be exported
+	blocks *blockBuffer
+}
+
+// New creates an empty repo for the DID with a freshly generated signing key.
+func New(did syntax.DID) (*Repo, error) {
+	key, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		return nil, fmt.Errorf("generate signing key: %w", err)
+	}
+
+	return &Repo{
+		did:    did,
+		key:    key,
+		clock:  syntax.NewTIDClock(0),
+		tree:   mst.NewEmptyTree(),
+		blocks: newBlockBuffer(),
+	}, nil
+}
+
+// DID returns the DID of the account the repo belongs to.
+func (r *Repo) DID() syntax.DID {
+	return r.did
+}
+
+// Identity returns an identity for the account whose DID document declares the repo's
+// signing key and, if pdsURL isn't empty, the PDS that hosts it.
+func (r *Repo) Identity(handle syntax.Handle, pdsURL string) (identity.Identity, error) {
+	pub, err := r.key.PublicKey()
+	if err != nil {
+		return identity.Identity{}, fmt.Errorf("get public key: %w", err)
+	}
+
+	ident := identity.Identity{
+		DID:         r.did,
+		Handle:      handle,
+		AlsoKnownAs: []string{"at://" + handle.String()},
+		Keys: map[string]identity.VerificationMethod{
+			"atproto": {
+				Type:               "Multikey",
+				PublicKeyMultibase: pub.Multibase(),
+			},
+		},
+	}
+	if pdsURL != "" {
+		ident.Services = map[string]identity.ServiceEndpoint{
+			"atproto_pds": {
+				Type: "AtprotoPersonalDataServer",
+				URL:  pdsURL,
+			},
+		}
+	}
+	return ident, nil
+}
+
+// CreateRecord adds the record to the repo and signs a new commit.
+func (r *Repo) CreateRecord(ctx context.Context, collection, rkey string, record map[string]any) (*Commit, error) {
+	return r.CreateRecordSignedBy(ctx, r.key, collection, rkey, record)
+}
+
+// CreateRecordSignedBy is the same as CreateRecord but signs the commit with the given key
+// instead of the repo's own key, to simulate a forged commit.
+func (r *Repo) CreateRecordSignedBy(ctx context.Context, key crypto.PrivateKey, collection, rkey string, record map[string]any) (*Commit, error) {
+	recordBytes, err := data.MarshalCBOR(record)
+	if err != nil {
+		return nil, fmt.Errorf("encode record: %w", err)
+	}
+	recordCID, err := cidBuilder.Sum(recordBytes)
+	if err != nil {
+		return nil, fmt.Errorf("compute record CID: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	diff := newBlockBuffer()
+	if err := diff.Put(ctx, mustBlock(recordBytes, recordCID)); err != nil {
+		return nil, err
+	}
+
+	path := collection + "/" + rkey
+	prev, err := r.tree.Insert([]byte(path), recordCID)
+	if err != nil {
+		return nil, fmt.Errorf("insert record into tree: %w", err)
+	}
+
+	op := Op{Action: "create", Path: path, CID: &recordCID}
+	if prev != nil {
+		op.Action = "update"
+		op.Prev = prev
+	}
+
+	return r.commit(ctx, key, diff, op)
+}
+
+// DeleteRecord removes the record from the repo and signs a new commit.
+func (r *Repo) DeleteRecord(ctx context.Context, collection, rkey string) (*Commit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := collection + "/" + rkey
+	prev, err := r.tree.Remove([]byte(path))
+	if err != nil {
+		return nil, fmt.Errorf("remove record from tree: %w", err)
+	}
+	if prev == nil {
+		return nil, repo.ErrNotFound
+	}
+
+	return r.commit(ctx, r.key, newBlockBuffer(), Op{Action: "delete", Path: path, Prev: prev})
+}
+
+// GetRecord returns the record stored at the path along with its CID.
+func (r *Repo) GetRecord(ctx context.Context, collection, rkey string) (map[string]any, cid.Cid, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recordCID, err := r.tree.Get([]byte(collection + "/" + rkey))
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	if recordCID == nil {
+		return nil, cid.Undef, repo.ErrNotFound
+	}
+	blk, err := r.blocks.Get(ctx, *recordCID)
+	if err != nil {
+		return nil, cid.Undef, err
+	}
+	record, err := data.UnmarshalCBOR(blk.RawData())
+	if err != nil {
+		return nil, cid.Undef, fmt.Errorf("decode record: %w", err)
+	}
+	return record, *recordCID, nil
+}
+
+// ListRecordKeys returns the record keys of every record in the collection, in order.
+func (r *Repo) ListRecordKeys(collection string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prefix := collection + "/"
+	var rkeys []string
+	err := r.tree.Walk(func(key []byte, _ cid.Cid) error {
+		if rkey, ok := strings.CutPrefix(string(key), prefix); ok {
+			rkeys = append(rkeys, rkey)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk tree: %w", err)
+	}
+	return rkeys, nil
+}
+
+// WriteCAR writes the whole repo as a CAR file, as returned by com.atproto.sync.getRepo.
+func (r *Repo) WriteCAR(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.head.Defined() {
+		return fmt.Errorf("repo has no commits")
+	}
+	return r.blocks.WriteCAR(w, r.head)
+}
+
+func (r *Repo) commit(ctx context.Context, key crypto.PrivateKey, diff *blockBuffer, op Op) (*Commit, error) {
+	prevData := r.data
+
+	root, err := r.tree.WriteDiffBlocks(ctx, diff)
+	if err != nil {
+		return nil, fmt.Errorf("write tree blocks: %w", err)
+	}
+
+	commit := repo.Commit{
+		DID:     r.did.String(),
+		Version: repo.ATPROTO_REPO_VERSION,
+		Data:    *root,
+		Rev:     r.clock.Next().String(),
+	}
+	if err := commit.Sign(key); err != nil {
+		return nil, fmt.Errorf("sign commit: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := commit.MarshalCBOR(buf); err != nil {
+		return nil, fmt.Errorf("encode commit: %w", err)
+	}
+	commitCID, err := cidBuilder.Sum(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("compute commit CID: %w", err)
+	}
+	if err := diff.Put(ctx, mustBlock(buf.Bytes(), commitCID)); err != nil {
+		return nil, err
+	}
+
+	carBuf := new(bytes.Buffer)
+	if err := diff.WriteCAR(carBuf, commitCID); err != nil {
+		return nil, fmt.Errorf("write commit CAR: %w", err)
+	}
+
+	if err := r.blocks.PutMany(ctx, diff.All()); err != nil {
+		return nil, err
+	}
+
+	var since *string
+	if r.rev != "" {
+		prevRev := r.rev
+		since = &prevRev
+	}
+
+	r.rev = commit.Rev
+	r.head = commitCID
+	r.data = root
+
+	return &Commit{
+		DID:      r.did,
+		Rev:      commit.Rev,
+		Since:    since,
+		CID:      commitCID,
+		PrevData: prevData,
+		Ops:      []Op{op},
+		Blocks:   carBuf.Bytes(),
+	}, nil
+}
+
+func mustBlock(b []byte, c cid.Cid) blocks.Block {
+	blk, err := blocks.NewBlockWithCid(b, c)
+	if err != nil {
+		// only happens if the CID doesn't match the data, which would be a bug here
+		panic(err)
+	}
+	return blk
+}
+
+// blockBuffer is a minimal in-memory blockstore that remembers the order blocks were added
+// in, so they can be written out as a CAR file.
+type blockBuffer struct {
+	mu     sync.Mutex
+	order  []cid.Cid
+	blocks map[cid.Cid]blocks.Block
+}
+
+func newBlockBuffer() *blockBuffer {
+	return &blockBuffer{
+		blocks: make(map[cid.Cid]blocks.Block),
+	}
+}
+
+func (b *blockBuffer) Put(_ context.Context, blk blocks.Block) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.blocks[blk.Cid()]; ok {
+		return nil
+	}
+	b.order = append(b.order, blk.Cid())
+	b.blocks[blk.Cid()] = blk
+	return nil
+}
+
+func (b *blockBuffer) PutMany(ctx context.Context, blks []blocks.Block) error {
+	for _, blk := range blks {
+		if err := b.Put(ctx, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *blockBuffer) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blk, ok := b.blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return blk, nil
+}
+
+func (b *blockBuffer) Has(_ context.Context, c cid.Cid) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.blocks[c]
+	return ok, nil
+}
+
+func (b *blockBuffer) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	blk, err := b.Get(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	return len(blk.RawData()), nil
+}
+
+func (b *blockBuffer) DeleteBlock(_ context.Context, c cid.Cid) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.blocks, c)
+	return nil
+}
+
+func (b *blockBuffer) AllKeysChan(_ context.Context) (<-chan cid.Cid, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan cid.Cid, len(b.order))
+	for _, c := range b.order {
+		if _, ok := b.blocks[c]; ok {
+			ch <- c
+		}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (b *blockBuffer) HashOnRead(bool) {}
+
+// All returns every block in the order it was added.
+func (b *blockBuffer) All() []blocks.Block {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	all := make([]blocks.Block, 0, len(b.order))
+	for _, c := range b.order {
+		if blk, ok := b.blocks[c]; ok {
+			all = append(all, blk)
+		}
+	}
+	return all
+}
+
+// WriteCAR writes every block as a CAR file with the given root.
+func (b *blockBuffer) WriteCAR(w io.Writer, root cid.Cid) error {
+	err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{root}, Version: 1}, w)
+	if err != nil {
+		return fmt.Errorf("write CAR header: %w", err)
+	}
+	for _, blk := range b.All() {
+		if err := carutil.LdWrite(w, blk.Cid().Bytes(), blk.RawData()); err != nil {
+			return fmt.Errorf("write CAR block: %w", err)
+		}
+	}
+	return nil
+}
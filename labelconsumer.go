@@ -0,0 +1,241 @@
+package statusphere
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/gorilla/websocket"
+)
+
+// labelCursorSaveInterval is how often the cursor is saved while the labeler only sends
+// labels the app doesn't store. Labels are idempotent, so after a restart the labels since
+// the cursor was last saved are applied again.
+const labelCursorSaveInterval = time.Second * 10
+
+// labelConsumer consumes a labeler's com.atproto.label.subscribeLabels stream, storing the
+// labels it applies to accounts and statuses. Each label is checked against the signing
+// key in the labeler's DID document.
+type labelConsumer struct {
+	labeler   syntax.DID
+	directory identity.Directory
+	store     LabelStore
+	logger    *slog.Logger
+	// endpoints only ever has the labeler's endpoint in it, but it backs off retries
+	// the same way as for Jetstream and relays
+	endpoints *endpointPool
+
+	cursor      atomic.Int64
+	cursorSaved time.Time
+	// endpoint is the labeler's endpoint, once it's been resolved
+	endpoint atomic.Pointer[string]
+	// consuming is set while Consume is running
+	consuming atomic.Bool
+}
+
+// NewLabelConsumer creates a consumer of the labels from the labeler with the DID, which is
+// resolved using the directory to find the labeler's endpoint and signing key.
+func NewLabelConsumer(labeler syntax.DID, logger *slog.Logger, store LabelStore, directory identity.Directory) *labelConsumer {
+	return &labelConsumer{
+		labeler:   labeler,
+		directory: directory,
+		store:     store,
+		logger:    logger.With("component", "label-consumer", "labeler", labeler),
+		endpoints: newEndpointPool([]string{labeler.String()}),
+	}
+}
+
+// Consume connects to the labeler and stores labels until the context is cancelled or the
+// connection fails, resuming from the last saved cursor. If it fails, callers should retry
+// after waiting for RetryDelay.
+func (c *labelConsumer) Consume(ctx context.Context) error {
+	c.consuming.Store(true)
+	defer c.consuming.Store(false)
+
+	ident, err := c.directory.LookupDID(ctx, c.labeler)
+	if err != nil {
+		c.endpoints.Failed(0)
+		return fmt.Errorf("resolve labeler: %w", err)
+	}
+	endpoint := ident.GetServiceEndpoint("atproto_labeler")
+	if endpoint == "" {
+		c.endpoints.Failed(0)
+		return fmt.Errorf("labeler's DID document has no labeler endpoint")
+	}
+	c.endpoint.Store(&endpoint)
+	key, err := ident.GetPublicKey("atproto_label")
+	if err != nil {
+		c.endpoints.Failed(0)
+		return fmt.Errorf("get labeler's signing key: %w", err)
+	}
+
+	cursor, err := c.store.GetLabelerCursor(ctx, c.labeler.String())
+	if err != nil {
+		return fmt.Errorf("get labeler cursor: %w", err)
+	}
+	c.cursor.Store(cursor)
+	c.cursorSaved = time.Now()
+
+	subscribeURL, err := labelSubscribeURL(endpoint, cursor)
+	if err != nil {
+		c.endpoints.Failed(0)
+		return fmt.Errorf("invalid labeler endpoint %q: %w", endpoint, err)
+	}
+
+	c.logger.Info("connecting to labeler", "url", subscribeURL)
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, subscribeURL, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		c.endpoints.Failed(0)
+		return fmt.Errorf("dial labeler: %w", err)
+	}
+
+	callbacks := &events.RepoStreamCallbacks{
+		LabelLabels: func(evt *comatproto.LabelSubscribeLabels_Labels) error {
+			return c.handleLabels(ctx, evt, key)
+		},
+		// info frames from labelers are parsed as repo info as they have the same fields
+		RepoInfo: func(evt *comatproto.SyncSubscribeRepos_Info) error {
+			message := ""
+			if evt.Message != nil {
+				message = *evt.Message
+			}
+			c.logger.Info("info from labeler", "name", evt.Name, "message", message)
+			return nil
+		},
+		Error: func(evt *events.ErrorFrame) error {
+			return fmt.Errorf("error frame from labeler: %s: %s", evt.Error, evt.Message)
+		},
+	}
+	scheduler := sequential.NewScheduler("statusphere-labels", callbacks.EventHandler)
+
+	connectedAt := time.Now()
+	err = events.HandleRepoStream(ctx, con, scheduler, c.logger)
+	if err != nil && ctx.Err() == nil {
+		c.endpoints.Failed(time.Since(connectedAt))
+		return fmt.Errorf("handle labels from %s: %w", c.labeler, err)
+	}
+	c.endpoints.Succeeded()
+
+	c.logger.Info("stopping consume")
+	return nil
+}
+
+// RetryDelay is how long to wait before calling Consume again after it failed.
+func (c *labelConsumer) RetryDelay() time.Duration {
+	return c.endpoints.RetryDelay()
+}
+
+// Status returns the labeler's endpoint and the sequence number of the last label received.
+func (c *labelConsumer) Status() ConsumerStatus {
+	status := ConsumerStatus{
+		Name:      "labeler " + c.labeler.String(),
+		Cursor:    c.cursor.Load(),
+		Connected: c.consuming.Load(),
+	}
+	if endpoint := c.endpoint.Load(); endpoint != nil {
+		status.Endpoint = *endpoint
+	}
+	return status
+}
+
+// handleLabels stores the labels for accounts and statuses in the event. Labels that fail
+// verification are logged and skipped, so that one bad label doesn't stop the stream.
+func (c *labelConsumer) handleLabels(ctx context.Context, evt *comatproto.LabelSubscribeLabels_Labels, key crypto.PublicKey) error {
+	var labels []Label
+	for _, l := range evt.Labels {
+		if !isStatusLabelSubject(l.Uri) {
+			continue
+		}
+		stored, err := c.verifyLabel(ctx, l, key)
+		if err != nil {
+			c.logger.Warn("skipping label that failed verification", "seq", evt.Seq, "uri", l.Uri, "val", l.Val, "error", err)
+			continue
+		}
+		labels = append(labels, stored)
+	}
+
+	c.cursor.Store(evt.Seq)
+	if len(labels) == 0 && time.Since(c.cursorSaved) < labelCursorSaveInterval {
+		return nil
+	}
+	if err := c.store.ApplyLabels(ctx, c.labeler.String(), evt.Seq, labels); err != nil {
+		return fmt.Errorf("store labels: %w", err)
+	}
+	c.cursorSaved = time.Now()
+	for _, l := range labels {
+		c.logger.Info("label applied", "uri", l.URI, "val", l.Val, "neg", l.Neg)
+	}
+	return nil
+}
+
+// verifyLabel checks that the label is from the labeler and signed with its key.
+func (c *labelConsumer) verifyLabel(ctx context.Context, l *comatproto.LabelDefs_Label, key crypto.PublicKey) (Label, error) {
+	lbl := label.FromLexicon(l)
+	if err := lbl.VerifySyntax(); err != nil {
+		return Label{}, err
+	}
+	if lbl.SourceDID != c.labeler.String() {
+		return Label{}, fmt.Errorf("label is from %s rather than the labeler", lbl.SourceDID)
+	}
+	if err := lbl.VerifySignature(key); err != nil {
+		// the labeler may have rotated its key since its identity was cached, in which
+		// case the new key is used after reconnecting
+		_ = c.directory.Purge(ctx, c.labeler.AtIdentifier())
+		return Label{}, fmt.Errorf("verify signature: %w", err)
+	}
+
+	stored := Label{
+		Src: lbl.SourceDID,
+		URI: lbl.URI,
+		Val: lbl.Val,
+		Neg: lbl.Negated != nil && *lbl.Negated,
+	}
+	if lbl.CID != nil {
+		stored.CID = *lbl.CID
+	}
+	createdAt, err := syntax.ParseDatetimeLenient(lbl.CreatedAt)
+	if err != nil {
+		return Label{}, fmt.Errorf("invalid cts: %w", err)
+	}
+	stored.CreatedAt = createdAt.Time().UnixMilli()
+	if lbl.ExpiresAt != nil {
+		expiresAt, err := syntax.ParseDatetimeLenient(*lbl.ExpiresAt)
+		if err != nil {
+			return Label{}, fmt.Errorf("invalid exp: %w", err)
+		}
+		stored.ExpiresAt = expiresAt.Time().UnixMilli()
+	}
+	return stored, nil
+}
+
+func labelSubscribeURL(endpoint string, cursor int64) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/xrpc/com.atproto.label.subscribeLabels"
+	// the cursor is always sent, as without one labelers only send new labels, whereas a
+	// cursor of 0 gets every label from the start
+	u.RawQuery = url.Values{"cursor": []string{fmt.Sprint(cursor)}}.Encode()
+	return u.String(), nil
+}
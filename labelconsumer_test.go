@@ -0,0 +1,110 @@
+package statusphere_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/fakelabeler"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// apiStatusLabels returns the labels of the status in the statuses API, or nil if it isn't
+// there.
+func apiStatusLabels(t *testing.T, app *testapp.App, uri string) []string {
+	t.Helper()
+
+	for _, status := range app.APIStatuses(t) {
+		if status.URI == uri {
+			return append([]string{}, status.Labels...)
+		}
+	}
+	return nil
+}
+
+// consumeLabels runs the consumer until the test finishes.
+func consumeLabels(t *testing.T, consumer interface{ Consume(context.Context) error }) {
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- consumer.Consume(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-consumed
+	})
+}
+
+// TestLabelConsumer consumes labels from a fake labeler for a status and checks that the
+// status is blurred or hidden, that a label with a forged signature is ignored and that
+// negating the labels shows the status again.
+func TestLabelConsumer(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+
+	labelerDID := syntax.DID("did:plc:testlabeler")
+	labeler, err := fakelabeler.New(labelerDID)
+	if err != nil {
+		t.Fatalf("start labeler: %s", err)
+	}
+	defer labeler.Close()
+
+	status := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[9].Status)
+	app.CreateStatus(t, status)
+	// an older status, which is still shown while the other is hidden
+	other := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status)
+	other.CreatedAt -= 1000
+	app.CreateStatus(t, other)
+
+	consumeLabels(t, statusphere.NewLabelConsumer(labelerDID, slog.Default(), app.DB, labeler.Directory()))
+
+	if err := labeler.Label(status.URI, "porn"); err != nil {
+		t.Fatalf("label status: %s", err)
+	}
+	testapp.WaitFor(t, func() error {
+		if labels := apiStatusLabels(t, app, status.URI); !slices.Equal(labels, []string{"porn"}) {
+			return fmt.Errorf("expected the status to have the porn label, got %v", labels)
+		}
+		return nil
+	})
+	_, body := app.Get(t, "/")
+	if !strings.Contains(body, "blurred") || !strings.Contains(body, "Labelled porn") {
+		t.Fatal("expected the labelled status to be blurred on the home page")
+	}
+
+	// the forged label is sent first, so once the genuine label after it has been applied
+	// the forged one must have been skipped
+	if err := labeler.LabelForged(testapp.DID.String(), "!hide"); err != nil {
+		t.Fatalf("forge label: %s", err)
+	}
+	if err := labeler.Label(status.URI, "spam"); err != nil {
+		t.Fatalf("label status: %s", err)
+	}
+	testapp.WaitFor(t, func() error {
+		if n := countAPIStatuses(t, app, status.URI); n != 0 {
+			return fmt.Errorf("expected the status labelled spam not to be shown, got %d", n)
+		}
+		return nil
+	})
+	if n := countAPIStatuses(t, app, other.URI); n != 1 {
+		t.Fatalf("expected the forged label on the account to be ignored, got %d of its other statuses", n)
+	}
+
+	if err := labeler.Negate(status.URI, "spam"); err != nil {
+		t.Fatalf("negate label: %s", err)
+	}
+	if err := labeler.Negate(status.URI, "porn"); err != nil {
+		t.Fatalf("negate label: %s", err)
+	}
+	testapp.WaitFor(t, func() error {
+		if labels := apiStatusLabels(t, app, status.URI); labels == nil || len(labels) != 0 {
+			return fmt.Errorf("expected the status to be shown without labels, got %v", labels)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,285 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/label"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultQueryLabelsLimit = 50
+	maxQueryLabelsLimit     = 250
+
+	// labelerPollInterval is how often subscribers' streams check for new moderation
+	// actions. They're polled for, rather than sent when they're made, as the moderation
+	// command makes them from another process.
+	labelerPollInterval = time.Second
+	// labelerBatchSize is how many moderation actions are read at a time when streaming
+	labelerBatchSize = 100
+	// labelerWriteTimeout is how long a subscriber has to receive a frame before it's
+	// disconnected
+	labelerWriteTimeout = time.Second * 10
+)
+
+// moderationLabel is the label that's published for a moderation action.
+type moderationLabel struct {
+	val string
+	neg bool
+}
+
+// moderationLabels are the labels published for each moderation action. Unblocking and
+// unhiding negate the label applied by blocking and hiding.
+var moderationLabels = map[string]moderationLabel{
+	ModerationActionBlock:   {val: "!takedown"},
+	ModerationActionUnblock: {val: "!takedown", neg: true},
+	ModerationActionHide:    {val: "!hide"},
+	ModerationActionUnhide:  {val: "!hide", neg: true},
+}
+
+// LabelerStore reads the moderation audit log, which is what the app's labels are made
+// from. The ID of each action is the label's sequence number in the stream.
+type LabelerStore interface {
+	GetModerationActionsAfter(ctx context.Context, after int64, limit int) ([]ModerationAction, error)
+	GetActiveModerationActions(ctx context.Context, patterns []string, after int64, limit int) ([]ModerationAction, error)
+}
+
+// WithLabeler makes the server a labeler, publishing the app's moderation decisions as
+// labels signed with the key, so that other apps can moderate with them. The labeler's DID
+// document should have the server as its atproto_labeler service and the key's public key
+// as its atproto_label verification method.
+func WithLabeler(did syntax.DID, key crypto.PrivateKey) ServerOption {
+	return func(s *Server) {
+		s.labelerDID = did
+		s.labelerKey = key
+	}
+}
+
+// HandleQueryLabels implements com.atproto.label.queryLabels, returning the labels that
+// currently apply to the subjects matching the uriPatterns query parameters.
+func (s *Server) HandleQueryLabels(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	patterns := query["uriPatterns"]
+	if len(patterns) == 0 {
+		writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "uriPatterns is required")
+		return
+	}
+
+	limit := defaultQueryLabelsLimit
+	if l := query.Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > maxQueryLabelsLimit {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "limit must be between 1 and 250")
+			return
+		}
+		limit = parsed
+	}
+
+	var cursor int64
+	if c := query.Get("cursor"); c != "" {
+		parsed, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || parsed < 0 {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		cursor = parsed
+	}
+
+	resp := comatproto.LabelQueryLabels_Output{Labels: []*comatproto.LabelDefs_Label{}}
+	sources := query["sources"]
+	if len(sources) == 0 || slices.Contains(sources, s.labelerDID.String()) {
+		actions, err := s.store.GetActiveModerationActions(r.Context(), patterns, cursor, limit)
+		if err != nil {
+			slog.Error("get active moderation actions", "error", err)
+			writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", "failed to get labels")
+			return
+		}
+		for _, action := range actions {
+			lbl, err := s.signModerationLabel(action)
+			if err != nil {
+				slog.Error("sign label", "error", err, "id", action.ID)
+				writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", "failed to sign labels")
+				return
+			}
+			resp.Labels = append(resp.Labels, lbl)
+		}
+		if len(actions) == limit {
+			next := strconv.FormatInt(actions[len(actions)-1].ID, 10)
+			resp.Cursor = &next
+		}
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		slog.Error("marshal labels", "error", err)
+		writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", "failed to marshal labels")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// HandleSubscribeLabels implements com.atproto.label.subscribeLabels. Without a cursor only
+// new labels are streamed, otherwise the labels after the cursor are sent first.
+func (s *Server) HandleSubscribeLabels(w http.ResponseWriter, r *http.Request) {
+	var cursor *int64
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		parsed, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || parsed < 0 {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest", "invalid cursor")
+			return
+		}
+		cursor = &parsed
+	}
+
+	var latest int64
+	actions, err := s.store.GetModerationActions(r.Context(), 1)
+	if err != nil {
+		slog.Error("get latest moderation action", "error", err)
+		writeXRPCError(w, http.StatusInternalServerError, "InternalServerError", "failed to get labels")
+		return
+	}
+	if len(actions) > 0 {
+		latest = actions[0].ID
+	}
+
+	// the stream is public and isn't authenticated, so it can be subscribed to from anywhere
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	con, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("upgrade label subscription", "error", err)
+		return
+	}
+	defer con.Close()
+
+	if cursor != nil && *cursor > latest {
+		_ = con.SetWriteDeadline(time.Now().Add(labelerWriteTimeout))
+		_ = writeErrorFrame(con, "FutureCursor", "cursor is ahead of the stream")
+		return
+	}
+	after := latest
+	if cursor != nil {
+		after = *cursor
+	}
+
+	// subscribers don't send anything, but reading is how a closed connection is noticed
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := con.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(labelerPollInterval)
+	defer ticker.Stop()
+	for {
+		actions, err := s.store.GetModerationActionsAfter(ctx, after, labelerBatchSize)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("get moderation actions for label subscription", "error", err)
+		}
+		for _, action := range actions {
+			lbl, err := s.signModerationLabel(action)
+			if err != nil {
+				slog.Error("sign label", "error", err, "id", action.ID)
+				return
+			}
+			evt := &comatproto.LabelSubscribeLabels_Labels{
+				Seq:    action.ID,
+				Labels: []*comatproto.LabelDefs_Label{lbl},
+			}
+			_ = con.SetWriteDeadline(time.Now().Add(labelerWriteTimeout))
+			if err := writeLabelsFrame(con, evt); err != nil {
+				slog.Info("label subscriber disconnected", "error", err)
+				return
+			}
+			after = action.ID
+		}
+		// carry straight on while backfilling
+		if len(actions) == labelerBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// signModerationLabel creates the label for the moderation action and signs it with the
+// labeler's key.
+func (s *Server) signModerationLabel(action ModerationAction) (*comatproto.LabelDefs_Label, error) {
+	ml, ok := moderationLabels[action.Action]
+	if !ok {
+		return nil, fmt.Errorf("no label for moderation action %q", action.Action)
+	}
+	lbl := label.Label{
+		CreatedAt: time.UnixMilli(action.CreatedAt).UTC().Format(syntax.AtprotoDatetimeLayout),
+		SourceDID: s.labelerDID.String(),
+		URI:       action.Subject,
+		Val:       ml.val,
+		Version:   label.ATPROTO_LABEL_VERSION,
+	}
+	if ml.neg {
+		lbl.Negated = &ml.neg
+	}
+	if err := lbl.Sign(s.labelerKey); err != nil {
+		return nil, fmt.Errorf("sign label: %w", err)
+	}
+	lexLabel := lbl.ToLexicon()
+	return &lexLabel, nil
+}
+
+// writeLabelsFrame writes a #labels frame. XRPCStreamEvent can't serialize labels, so the
+// header is written directly.
+func writeLabelsFrame(con *websocket.Conn, evt *comatproto.LabelSubscribeLabels_Labels) error {
+	wc, err := con.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	header := events.EventHeader{Op: events.EvtKindMessage, MsgType: "#labels"}
+	if err := header.MarshalCBOR(wc); err != nil {
+		return err
+	}
+	if err := evt.MarshalCBOR(wc); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// writeErrorFrame writes an error frame, after which the subscriber is disconnected.
+func writeErrorFrame(con *websocket.Conn, name, message string) error {
+	wc, err := con.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	evt := &events.XRPCStreamEvent{Error: &events.ErrorFrame{Error: name, Message: message}}
+	if err := evt.Serialize(wc); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// writeXRPCError writes an error in the format XRPC clients expect.
+func writeXRPCError(w http.ResponseWriter, statusCode int, name, message string) {
+	b, _ := json.Marshal(map[string]string{"error": name, "message": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,97 @@
+package statusphere_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/label"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// TestPublishedLabels hides a status and checks that the app's labeler serves a signed label
+// for it, then subscribes to the labeler from a second database, as another statusphere app
+// would, and checks that hiding and unhiding the status there follows.
+func TestPublishedLabels(t *testing.T) {
+	app := testapp.New(t)
+	ctx := context.Background()
+
+	subscriber, err := database.New(path.Join(t.TempDir(), "subscriber.db"))
+	if err != nil {
+		t.Fatalf("create subscriber database: %s", err)
+	}
+	defer subscriber.Close()
+
+	status := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[10].Status)
+	for _, db := range []*database.DB{app.DB, subscriber} {
+		if err := db.CreateStatus(ctx, status); err != nil {
+			t.Fatalf("store status: %s", err)
+		}
+	}
+	if err := app.DB.HideStatus(ctx, status.URI, "test", "test"); err != nil {
+		t.Fatalf("hide status: %s", err)
+	}
+
+	pub, err := app.LabelerKey.PublicKey()
+	if err != nil {
+		t.Fatalf("get labeler public key: %s", err)
+	}
+	_, body := app.Get(t, "/xrpc/com.atproto.label.queryLabels?uriPatterns="+url.QueryEscape(status.URI))
+	var resp struct {
+		Labels []json.RawMessage `json:"labels"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decode response: %s", err)
+	}
+	if len(resp.Labels) != 1 {
+		t.Fatalf("expected one label for the hidden status, got %s", body)
+	}
+	var lbl label.Label
+	if err := json.Unmarshal(resp.Labels[0], &lbl); err != nil {
+		t.Fatalf("decode label: %s", err)
+	}
+	if lbl.Val != "!hide" || lbl.SourceDID != testapp.LabelerDID.String() {
+		t.Fatalf("expected a !hide label from the app's labeler, got %s", resp.Labels[0])
+	}
+	if err := lbl.VerifySignature(pub); err != nil {
+		t.Fatalf("verify label signature: %s", err)
+	}
+
+	directory := identity.NewMockDirectory()
+	directory.Insert(identity.Identity{
+		DID: testapp.LabelerDID,
+		Keys: map[string]identity.VerificationMethod{
+			"atproto_label": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+		Services: map[string]identity.ServiceEndpoint{
+			"atproto_labeler": {Type: "AtprotoLabeler", URL: app.URL},
+		},
+	})
+	consumeLabels(t, statusphere.NewLabelConsumer(testapp.LabelerDID, slog.Default(), subscriber, &directory))
+
+	shown := func(want bool) func() error {
+		return func() error {
+			statuses, err := subscriber.GetStatuses(ctx, 10)
+			if err != nil {
+				return fmt.Errorf("get subscriber's statuses: %w", err)
+			}
+			if got := len(statuses) == 1; got != want {
+				return fmt.Errorf("expected the status to be shown by the subscriber to be %t", want)
+			}
+			return nil
+		}
+	}
+	testapp.WaitFor(t, shown(false))
+	if err := app.DB.UnhideStatus(ctx, status.URI, "test"); err != nil {
+		t.Fatalf("unhide status: %s", err)
+	}
+	testapp.WaitFor(t, shown(true))
+}
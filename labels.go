@@ -0,0 +1,143 @@
+package statusphere
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// labelExpiryInterval is how often expired labels are deleted.
+const labelExpiryInterval = time.Minute
+
+// What the app does with statuses that have a label, or that are from an account that has
+// one.
+const (
+	// LabelActionHide stops the status being shown.
+	LabelActionHide = "hide"
+	// LabelActionBlur shows the status behind a warning that has to be clicked through.
+	LabelActionBlur = "blur"
+)
+
+// labelActions are the actions for the label values that have an effect. Other labels are
+// stored but ignored. They follow the defaults the Bluesky app uses for the global label
+// values and those of the Bluesky moderation service.
+var labelActions = map[string]string{
+	"!takedown":     LabelActionHide,
+	"!suspend":      LabelActionHide,
+	"!hide":         LabelActionHide,
+	"spam":          LabelActionHide,
+	"!warn":         LabelActionBlur,
+	"porn":          LabelActionBlur,
+	"sexual":        LabelActionBlur,
+	"nudity":        LabelActionBlur,
+	"graphic-media": LabelActionBlur,
+	"gore":          LabelActionBlur,
+	"rude":          LabelActionBlur,
+	"intolerant":    LabelActionBlur,
+	"threat":        LabelActionBlur,
+}
+
+// labelsWithAction returns the label values that have the action.
+func labelsWithAction(vals []string, action string) []string {
+	var matched []string
+	for _, val := range vals {
+		if labelActions[val] == action {
+			matched = append(matched, val)
+		}
+	}
+	return matched
+}
+
+// HiddenLabelValues returns the label values that hide statuses, in order.
+func HiddenLabelValues() []string {
+	var vals []string
+	for val, action := range labelActions {
+		if action == LabelActionHide {
+			vals = append(vals, val)
+		}
+	}
+	slices.Sort(vals)
+	return vals
+}
+
+// Label is a moderation label applied by a labeler to an account, when URI is a DID, or to
+// a record.
+type Label struct {
+	Src string
+	URI string
+	Val string
+	// CID is the version of the record the label applies to, if it's only for one version.
+	CID string
+	// Neg is set for labels that remove an earlier label with the same value.
+	Neg       bool
+	CreatedAt int64
+	// ExpiresAt is when the label stops applying, or 0 if it doesn't expire.
+	ExpiresAt int64
+}
+
+// ExpiredLabelStore deletes labels that have expired.
+type ExpiredLabelStore interface {
+	DeleteExpiredLabels(ctx context.Context) (int64, error)
+}
+
+// LabelExpirer periodically deletes labels that have expired, so that statuses they were
+// hiding can become their users' current status again.
+type LabelExpirer struct {
+	store  ExpiredLabelStore
+	logger *slog.Logger
+}
+
+func NewLabelExpirer(store ExpiredLabelStore, logger *slog.Logger) *LabelExpirer {
+	return &LabelExpirer{
+		store:  store,
+		logger: logger.With("component", "label-expirer"),
+	}
+}
+
+// Run deletes expired labels every minute until the context is cancelled.
+func (e *LabelExpirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(labelExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := e.store.DeleteExpiredLabels(ctx)
+			if err != nil {
+				e.logger.Error("delete expired labels", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				e.logger.Info("deleted expired labels", "count", deleted)
+			}
+		}
+	}
+}
+
+// LabelStore stores the labels received from labelers along with how far through each
+// labeler's stream the app has got.
+type LabelStore interface {
+	// ApplyLabels stores labels, removing earlier labels that are negated, and saves the
+	// labeler's cursor in the same transaction.
+	ApplyLabels(ctx context.Context, labeler string, cursor int64, labels []Label) error
+	GetLabelerCursor(ctx context.Context, labeler string) (int64, error)
+}
+
+// isStatusLabelSubject reports whether a label applies to an account or to a status, which
+// are the only labels the app stores. Labelers such as Bluesky's label far more records
+// than just statuses. Labels for accounts are only stored if the account has statuses,
+// which the store checks.
+func isStatusLabelSubject(uri string) bool {
+	if strings.HasPrefix(uri, "did:") {
+		_, err := syntax.ParseDID(uri)
+		return err == nil
+	}
+	_, err := ParseStatusURI(uri)
+	return err == nil
+}
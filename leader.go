@@ -0,0 +1,115 @@
+package statusphere
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Lease is held by a process to show it's the one doing something, such as consuming.
+type Lease struct {
+	Name   string
+	Holder string
+	// ExpiresAt is when the lease expires unless it's renewed, in unix milliseconds.
+	ExpiresAt int64
+}
+
+// LeaseStore stores leases that are used to make sure only one process does something at a
+// time, even when several processes share the same database.
+type LeaseStore interface {
+	AcquireLease(ctx context.Context, name, holder string, now, expiresAt int64) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// Leader runs a function in only one of the processes that share a lease store. The other
+// processes stand by, and one of them takes over if the leader stops renewing its lease
+// because it has exited or can no longer reach the store.
+type Leader struct {
+	store  LeaseStore
+	name   string
+	holder string
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+// NewLeader creates a leader for the named lease. The holder identifies this process and
+// must be unique to it. A lease lasts for the ttl and is renewed well before it expires, so
+// if the leader dies a standby takes over within the ttl.
+func NewLeader(store LeaseStore, name, holder string, ttl time.Duration, logger *slog.Logger) *Leader {
+	return &Leader{
+		store:  store,
+		name:   name,
+		holder: holder,
+		ttl:    ttl,
+		logger: logger.With("component", "leader", "lease", name, "holder", holder),
+	}
+}
+
+// Run calls fn whenever this process holds the lease, until the context is cancelled. The
+// context passed to fn is cancelled when the lease is lost, and fn is waited on before
+// trying to take the lease again, so fn should return promptly once its context is done.
+func (l *Leader) Run(ctx context.Context, fn func(ctx context.Context)) {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	// stopLeading is set while this process is the leader
+	var stopLeading func()
+	var renewedAt time.Time
+
+	for {
+		now := time.Now()
+		acquired, err := l.store.AcquireLease(ctx, l.name, l.holder, now.UnixMilli(), now.Add(l.ttl).UnixMilli())
+		if err != nil && ctx.Err() == nil {
+			l.logger.Error("acquire lease", "error", err)
+		}
+
+		switch {
+		case acquired && stopLeading == nil:
+			l.logger.Info("became leader")
+			renewedAt = now
+			stopLeading = l.lead(ctx, fn)
+		case acquired:
+			renewedAt = now
+		case stopLeading != nil && (err == nil || now.Sub(renewedAt) >= l.ttl):
+			// either another process has the lease, or it couldn't be renewed before it
+			// expired so another process may take it at any moment
+			l.logger.Warn("lost leadership")
+			stopLeading()
+			stopLeading = nil
+		case stopLeading == nil && err == nil:
+			l.logger.Debug("standing by")
+		}
+
+		select {
+		case <-ctx.Done():
+			if stopLeading != nil {
+				stopLeading()
+				// the context is already cancelled so a fresh one is needed to release
+				releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				if err := l.store.ReleaseLease(releaseCtx, l.name, l.holder); err != nil {
+					l.logger.Error("release lease", "error", err)
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs fn in the background and returns a function that stops it and waits for it to
+// return.
+func (l *Leader) lead(ctx context.Context, fn func(ctx context.Context)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn(ctx)
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
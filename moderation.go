@@ -0,0 +1,71 @@
+package statusphere
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// The moderation actions that are recorded in the audit log.
+const (
+	ModerationActionBlock   = "block"
+	ModerationActionUnblock = "unblock"
+	ModerationActionHide    = "hide"
+	ModerationActionUnhide  = "unhide"
+)
+
+// BlockedDID is an account whose statuses aren't stored or shown.
+type BlockedDID struct {
+	Did       string
+	Reason    string
+	CreatedAt int64
+}
+
+// HiddenStatus is a status that isn't shown, although it's still stored.
+type HiddenStatus struct {
+	URI       string
+	Reason    string
+	CreatedAt int64
+}
+
+// ModerationAction is an entry in the audit log of moderation actions. The subject is the
+// DID that was blocked or unblocked or the URI of the status that was hidden or unhidden,
+// and the actor is who did it.
+type ModerationAction struct {
+	ID        int64
+	Action    string
+	Subject   string
+	Reason    string
+	Actor     string
+	CreatedAt int64
+}
+
+// ModerationStore stores the blocklist and hidden statuses, recording each change in the
+// audit log.
+type ModerationStore interface {
+	BlockDID(ctx context.Context, did, reason, actor string) error
+	UnblockDID(ctx context.Context, did, actor string) error
+	HideStatus(ctx context.Context, uri, reason, actor string) error
+	UnhideStatus(ctx context.Context, uri, actor string) error
+	IsDIDBlocked(ctx context.Context, did string) (bool, error)
+	GetBlockedDIDs(ctx context.Context) ([]BlockedDID, error)
+	GetHiddenStatuses(ctx context.Context) ([]HiddenStatus, error)
+	GetModerationActions(ctx context.Context, limit int) ([]ModerationAction, error)
+}
+
+// ParseStatusURI checks that uri is the AT URI of a status, with the DID of the account
+// that created it rather than their handle, and returns it normalized.
+func ParseStatusURI(uri string) (string, error) {
+	parsed, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if !parsed.Authority().IsDID() {
+		return "", fmt.Errorf("URI must have a DID rather than a handle")
+	}
+	if parsed.Collection().String() != statusCollection || parsed.RecordKey() == "" {
+		return "", fmt.Errorf("URI isn't a %s record", statusCollection)
+	}
+	return parsed.Normalize().String(), nil
+}
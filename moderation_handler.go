@@ -0,0 +1,163 @@
+package statusphere
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// moderationLogLimit is how many of the most recent moderation actions the admin page shows.
+const moderationLogLimit = 50
+
+// AdminModerationData is what the admin moderation page is rendered with.
+type AdminModerationData struct {
+	Blocked []ModerationEntry
+	Hidden  []ModerationEntry
+	Actions []ModerationEntry
+	// Error is why the last change couldn't be made, if it couldn't.
+	Error string
+}
+
+// ModerationEntry is a blocked account, hidden status or logged action described for the
+// admin page.
+type ModerationEntry struct {
+	Action  string
+	Subject string
+	Reason  string
+	Actor   string
+	Time    string
+}
+
+// HandleAdminModeration renders the page for blocking accounts and hiding statuses.
+func (s *Server) HandleAdminModeration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data := AdminModerationData{Error: r.URL.Query().Get("error")}
+
+	blocked, err := s.store.GetBlockedDIDs(ctx)
+	if err != nil {
+		slog.Error("get blocked DIDs", "error", err)
+		http.Error(w, "failed to get blocked accounts", http.StatusInternalServerError)
+		return
+	}
+	for _, b := range blocked {
+		data.Blocked = append(data.Blocked, ModerationEntry{Subject: b.Did, Reason: b.Reason, Time: formatModerationTime(b.CreatedAt)})
+	}
+
+	hidden, err := s.store.GetHiddenStatuses(ctx)
+	if err != nil {
+		slog.Error("get hidden statuses", "error", err)
+		http.Error(w, "failed to get hidden statuses", http.StatusInternalServerError)
+		return
+	}
+	for _, h := range hidden {
+		data.Hidden = append(data.Hidden, ModerationEntry{Subject: h.URI, Reason: h.Reason, Time: formatModerationTime(h.CreatedAt)})
+	}
+
+	actions, err := s.store.GetModerationActions(ctx, moderationLogLimit)
+	if err != nil {
+		slog.Error("get moderation actions", "error", err)
+		http.Error(w, "failed to get moderation log", http.StatusInternalServerError)
+		return
+	}
+	for _, a := range actions {
+		data.Actions = append(data.Actions, ModerationEntry{Action: a.Action, Subject: a.Subject, Reason: a.Reason, Actor: a.Actor, Time: formatModerationTime(a.CreatedAt)})
+	}
+
+	tmpl := s.getTemplate("admin_moderation.html")
+	tmpl.Execute(w, data)
+}
+
+// HandleBlockDID blocks an account.
+func (s *Server) HandleBlockDID(w http.ResponseWriter, r *http.Request) {
+	did, err := syntax.ParseDID(strings.TrimSpace(r.FormValue("did")))
+	if err != nil {
+		redirectToAdminModeration(w, r, "invalid DID: "+err.Error())
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		redirectToAdminModeration(w, r, "a reason is needed")
+		return
+	}
+
+	if err := s.store.BlockDID(r.Context(), did.String(), reason, adminDID(r).String()); err != nil {
+		slog.Error("block DID", "error", err)
+		redirectToAdminModeration(w, r, "failed to block account")
+		return
+	}
+	slog.Info("account blocked", "did", did, "reason", reason)
+	redirectToAdminModeration(w, r, "")
+}
+
+// HandleUnblockDID unblocks an account.
+func (s *Server) HandleUnblockDID(w http.ResponseWriter, r *http.Request) {
+	did := r.FormValue("did")
+	err := s.store.UnblockDID(r.Context(), did, adminDID(r).String())
+	if errors.Is(err, ErrorNotFound) {
+		redirectToAdminModeration(w, r, "account isn't blocked")
+		return
+	}
+	if err != nil {
+		slog.Error("unblock DID", "error", err)
+		redirectToAdminModeration(w, r, "failed to unblock account")
+		return
+	}
+	slog.Info("account unblocked", "did", did)
+	redirectToAdminModeration(w, r, "")
+}
+
+// HandleHideStatus hides a status.
+func (s *Server) HandleHideStatus(w http.ResponseWriter, r *http.Request) {
+	uri, err := ParseStatusURI(strings.TrimSpace(r.FormValue("uri")))
+	if err != nil {
+		redirectToAdminModeration(w, r, "invalid status URI: "+err.Error())
+		return
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		redirectToAdminModeration(w, r, "a reason is needed")
+		return
+	}
+
+	if err := s.store.HideStatus(r.Context(), uri, reason, adminDID(r).String()); err != nil {
+		slog.Error("hide status", "error", err)
+		redirectToAdminModeration(w, r, "failed to hide status")
+		return
+	}
+	slog.Info("status hidden", "uri", uri, "reason", reason)
+	redirectToAdminModeration(w, r, "")
+}
+
+// HandleUnhideStatus shows a hidden status again.
+func (s *Server) HandleUnhideStatus(w http.ResponseWriter, r *http.Request) {
+	uri := r.FormValue("uri")
+	err := s.store.UnhideStatus(r.Context(), uri, adminDID(r).String())
+	if errors.Is(err, ErrorNotFound) {
+		redirectToAdminModeration(w, r, "status isn't hidden")
+		return
+	}
+	if err != nil {
+		slog.Error("unhide status", "error", err)
+		redirectToAdminModeration(w, r, "failed to unhide status")
+		return
+	}
+	slog.Info("status unhidden", "uri", uri)
+	redirectToAdminModeration(w, r, "")
+}
+
+func formatModerationTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04 MST")
+}
+
+func redirectToAdminModeration(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/admin/moderation"
+	if errMsg != "" {
+		target += "?" + url.Values{"error": []string{errMsg}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,126 @@
+package statusphere_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/jetstream/pkg/models"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+// countAPIStatuses returns how many statuses in the statuses API contain s in their URI.
+func countAPIStatuses(t *testing.T, app *testapp.App, s string) int {
+	t.Helper()
+
+	n := 0
+	for _, status := range app.APIStatuses(t) {
+		if strings.Contains(status.URI, s) {
+			n++
+		}
+	}
+	return n
+}
+
+// TestHideStatus hides a status and checks it's no longer shown or anyone's current status
+// until it's unhidden.
+func TestHideStatus(t *testing.T) {
+	app := testapp.New(t)
+	ctx := context.Background()
+
+	previous := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status)
+	previous.CreatedAt -= 1000
+	app.CreateStatus(t, previous)
+	status := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[8].Status)
+	app.CreateStatus(t, status)
+
+	if err := app.DB.HideStatus(ctx, status.URI, "test", "test"); err != nil {
+		t.Fatalf("hide status: %s", err)
+	}
+	if n := countAPIStatuses(t, app, status.URI); n != 0 {
+		t.Fatalf("expected the hidden status not to be shown, got %d", n)
+	}
+	expectCurrentStatus(t, app, previous.URI)
+
+	if err := app.DB.UnhideStatus(ctx, status.URI, "test"); err != nil {
+		t.Fatalf("unhide status: %s", err)
+	}
+	if n := countAPIStatuses(t, app, status.URI); n != 1 {
+		t.Fatalf("expected the unhidden status to be shown, got %d", n)
+	}
+	expectCurrentStatus(t, app, status.URI)
+}
+
+// TestBlockDID blocks the logged in account and checks its statuses aren't shown, it can't
+// post and the consumer doesn't store its statuses, then unblocks it.
+func TestBlockDID(t *testing.T) {
+	app := testapp.NewLoggedIn(t)
+	ctx := context.Background()
+
+	status := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status)
+	app.CreateStatus(t, status)
+
+	if err := app.DB.BlockDID(ctx, testapp.DID.String(), "test", "test"); err != nil {
+		t.Fatalf("block DID: %s", err)
+	}
+	if n := countAPIStatuses(t, app, testapp.DID.String()); n != 0 {
+		t.Fatalf("expected the blocked account's statuses not to be shown, got %d", n)
+	}
+	resp, body := app.PostForm(t, "/status", url.Values{"status": {statusphere.DefaultPalette[0].Status}})
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(html.UnescapeString(body), "can't post statuses") {
+		t.Fatalf("expected the blocked account not to be able to post, got %d", resp.StatusCode)
+	}
+
+	// an event from the blocked account isn't stored when it's consumed
+	event, err := json.Marshal(models.Event{
+		Did:    testapp.DID.String(),
+		TimeUS: time.Now().UnixMicro(),
+		Kind:   models.EventKindCommit,
+		Commit: &models.Commit{
+			Operation:  models.CommitOperationCreate,
+			Collection: "xyz.statusphere.status",
+			RKey:       syntax.NewTIDNow(0).String(),
+			Record:     json.RawMessage(fmt.Sprintf(`{"status": %q, "createdAt": %q}`, statusphere.DefaultPalette[0].Status, time.Now().Format(time.RFC3339))),
+		},
+	})
+	if err != nil {
+		t.Fatalf("marshal event: %s", err)
+	}
+	consumer, err := statusphere.NewConsumer(nil, slog.Default(), app.DB)
+	if err != nil {
+		t.Fatalf("create consumer: %s", err)
+	}
+	if _, err := consumer.Replay(ctx, bytes.NewReader(event), 0); err != nil {
+		t.Fatalf("replay event: %s", err)
+	}
+
+	if err := app.DB.UnblockDID(ctx, testapp.DID.String(), "test"); err != nil {
+		t.Fatalf("unblock DID: %s", err)
+	}
+	statuses, err := app.DB.GetStatuses(ctx, 10)
+	if err != nil {
+		t.Fatalf("get statuses: %s", err)
+	}
+	if len(statuses) != 1 || statuses[0].URI != status.URI {
+		t.Fatalf("expected the unblocked account's status to be shown and the consumed one not to be stored, got %+v", statuses)
+	}
+
+	actions, err := app.DB.GetModerationActions(ctx, 10)
+	if err != nil {
+		t.Fatalf("get moderation actions: %s", err)
+	}
+	if len(actions) != 2 || actions[0].Action != statusphere.ModerationActionUnblock {
+		t.Fatalf("expected the block and unblock to be logged, got %+v", actions)
+	}
+}
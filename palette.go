@@ -0,0 +1,240 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// The policies for which statuses users can post from the app. Statuses created by other
+// apps are stored whatever they are.
+const (
+	// StatusPolicyPalette only allows statuses in the palette.
+	StatusPolicyPalette = "palette"
+	// StatusPolicyEmoji allows any single emoji.
+	StatusPolicyEmoji = "emoji"
+)
+
+// maxStatusLength is the most bytes a status can be, as in the xyz.statusphere.status
+// lexicon.
+const maxStatusLength = 32
+
+// PaletteStatus is a status that users can pick from in the app.
+type PaletteStatus struct {
+	Status   string `json:"status"`
+	Label    string `json:"label"`
+	Category string `json:"category"`
+}
+
+// PaletteCategory is the statuses in a category, in the order they're shown.
+type PaletteCategory struct {
+	Name     string
+	Statuses []PaletteStatus
+}
+
+type PaletteStore interface {
+	GetPalette(ctx context.Context) ([]PaletteStatus, error)
+	SavePaletteStatus(ctx context.Context, status PaletteStatus) error
+	DeletePaletteStatus(ctx context.Context, status string) error
+	ReplacePalette(ctx context.Context, palette []PaletteStatus) error
+}
+
+// DefaultPalette is the palette that's used if none has been configured.
+var DefaultPalette = []PaletteStatus{
+	{Status: "👍", Label: "Thumbs up", Category: "Reactions"},
+	{Status: "👎", Label: "Thumbs down", Category: "Reactions"},
+	{Status: "💙", Label: "Blue heart", Category: "Reactions"},
+	{Status: "👀", Label: "Eyes", Category: "Reactions"},
+	{Status: "🫡", Label: "Salute", Category: "Reactions"},
+	{Status: "✊", Label: "Raised fist", Category: "Reactions"},
+	{Status: "🤘", Label: "Rock on", Category: "Reactions"},
+	{Status: "💀", Label: "Dead", Category: "Reactions"},
+	{Status: "🥹", Label: "Holding back tears", Category: "Feelings"},
+	{Status: "😧", Label: "Anguished", Category: "Feelings"},
+	{Status: "😤", Label: "Huffing", Category: "Feelings"},
+	{Status: "🙃", Label: "Upside down", Category: "Feelings"},
+	{Status: "😉", Label: "Winking", Category: "Feelings"},
+	{Status: "😎", Label: "Cool", Category: "Feelings"},
+	{Status: "🤓", Label: "Nerdy", Category: "Feelings"},
+	{Status: "🤨", Label: "Skeptical", Category: "Feelings"},
+	{Status: "🥳", Label: "Partying", Category: "Feelings"},
+	{Status: "😭", Label: "Crying", Category: "Feelings"},
+	{Status: "🤯", Label: "Mind blown", Category: "Feelings"},
+	{Status: "🧠", Label: "Thinking", Category: "Doing"},
+	{Status: "👩‍💻", Label: "Coding", Category: "Doing"},
+	{Status: "🧑‍💻", Label: "Working", Category: "Doing"},
+	{Status: "🥷", Label: "Sneaking", Category: "Doing"},
+	{Status: "🧌", Label: "Trolling", Category: "Doing"},
+	{Status: "🦋", Label: "On Bluesky", Category: "Doing"},
+	{Status: "🚀", Label: "Shipping", Category: "Doing"},
+}
+
+// LoadPalette reads a palette from a JSON array of statuses, each with a status, label and
+// category, checking that every status is a single emoji and none are repeated.
+func LoadPalette(r io.Reader) ([]PaletteStatus, error) {
+	var palette []PaletteStatus
+	if err := json.NewDecoder(r).Decode(&palette); err != nil {
+		return nil, fmt.Errorf("decode palette: %w", err)
+	}
+
+	seen := make(map[string]bool, len(palette))
+	for i, status := range palette {
+		if err := ValidateStatus(status.Status); err != nil {
+			return nil, fmt.Errorf("status %d: %w", i+1, err)
+		}
+		if seen[status.Status] {
+			return nil, fmt.Errorf("status %d: %s is repeated", i+1, status.Status)
+		}
+		seen[status.Status] = true
+	}
+	return palette, nil
+}
+
+// SeedPalette stores the palette if the store doesn't have one yet, so that changes made to
+// the stored palette aren't overwritten each time the app starts.
+func SeedPalette(ctx context.Context, store PaletteStore, palette []PaletteStatus) error {
+	stored, err := store.GetPalette(ctx)
+	if err != nil {
+		return fmt.Errorf("get palette: %w", err)
+	}
+	if len(stored) > 0 {
+		return nil
+	}
+	if err := store.ReplacePalette(ctx, palette); err != nil {
+		return fmt.Errorf("store palette: %w", err)
+	}
+	return nil
+}
+
+// paletteCategories groups the palette by category, in the order each category first
+// appears.
+func paletteCategories(palette []PaletteStatus) []PaletteCategory {
+	var categories []PaletteCategory
+	index := make(map[string]int)
+	for _, status := range palette {
+		i, ok := index[status.Category]
+		if !ok {
+			i = len(categories)
+			index[status.Category] = i
+			categories = append(categories, PaletteCategory{Name: status.Category})
+		}
+		categories[i].Statuses = append(categories[i].Statuses, status)
+	}
+	return categories
+}
+
+// ValidateStatus checks that a status is a single emoji, which can be a sequence such as a
+// flag, a keycap or emoji joined with zero width joiners.
+func ValidateStatus(status string) error {
+	if status == "" {
+		return fmt.Errorf("status is empty")
+	}
+	if len(status) > maxStatusLength {
+		return fmt.Errorf("status is longer than %d bytes", maxStatusLength)
+	}
+	if !isSingleEmoji(status) {
+		return fmt.Errorf("status %q isn't a single emoji", status)
+	}
+	return nil
+}
+
+const (
+	zeroWidthJoiner    = 0x200D
+	variationSelector  = 0xFE0F
+	combiningKeycap    = 0x20E3
+	blackFlag          = 0x1F3F4
+	cancelTag          = 0xE007F
+	regionalIndicatorA = 0x1F1E6
+	regionalIndicatorZ = 0x1F1FF
+)
+
+// isSingleEmoji reports whether s is one emoji grapheme. It follows the shapes of emoji
+// sequences in Unicode Technical Standard #51 rather than the full grapheme cluster rules,
+// which is enough to tell an emoji from text.
+func isSingleEmoji(s string) bool {
+	runes := []rune(s)
+	if !utf8.ValidString(s) || len(runes) == 0 {
+		return false
+	}
+
+	// flags are a pair of regional indicators
+	if isRegionalIndicator(runes[0]) {
+		return len(runes) == 2 && isRegionalIndicator(runes[1])
+	}
+	// keycaps are a digit, # or * followed by the combining keycap
+	if runes[0] == '#' || runes[0] == '*' || (runes[0] >= '0' && runes[0] <= '9') {
+		rest := runes[1:]
+		if len(rest) > 0 && rest[0] == variationSelector {
+			rest = rest[1:]
+		}
+		return len(rest) == 1 && rest[0] == combiningKeycap
+	}
+	// subdivision flags are a black flag followed by tags and a cancel tag
+	if runes[0] == blackFlag && len(runes) > 2 && runes[len(runes)-1] == cancelTag {
+		for _, r := range runes[1 : len(runes)-1] {
+			if r < 0xE0020 || r > 0xE007E {
+				return false
+			}
+		}
+		return true
+	}
+
+	// otherwise it's emoji joined by zero width joiners, each of which can be followed by a
+	// variation selector and a skin tone
+	i := 0
+	for {
+		if i >= len(runes) || !isPictographic(runes[i]) {
+			return false
+		}
+		i++
+		if i < len(runes) && runes[i] == variationSelector {
+			i++
+		}
+		if i < len(runes) && isSkinTone(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != zeroWidthJoiner {
+			return false
+		}
+		i++
+	}
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= regionalIndicatorA && r <= regionalIndicatorZ
+}
+
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+// isPictographic reports whether r is in the blocks that emoji are taken from.
+func isPictographic(r rune) bool {
+	switch {
+	case isRegionalIndicator(r), isSkinTone(r):
+		return false
+	case r == 0x00A9, r == 0x00AE, r == 0x203C, r == 0x2049, r == 0x2122, r == 0x2139:
+		return true
+	case r >= 0x2194 && r <= 0x21AA:
+		return true
+	case r >= 0x231A && r <= 0x23FF:
+		return true
+	case r == 0x24C2, r == 0x25AA, r == 0x25AB, r == 0x25B6, r == 0x25C0:
+		return true
+	case r >= 0x25FB && r <= 0x25FE, r >= 0x2600 && r <= 0x27BF:
+		return true
+	case r >= 0x2934 && r <= 0x2935, r >= 0x2B05 && r <= 0x2B55:
+		return true
+	case r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+		return true
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,79 @@
+package statusphere
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AdminPaletteData is what the admin palette page is rendered with.
+type AdminPaletteData struct {
+	Palette []PaletteStatus
+	Policy  string
+	// Error is why the last change couldn't be made, if it couldn't.
+	Error string
+}
+
+// HandleAdminPalette renders the page for managing the palette.
+func (s *Server) HandleAdminPalette(w http.ResponseWriter, r *http.Request) {
+	palette, err := s.store.GetPalette(r.Context())
+	if err != nil {
+		slog.Error("get palette", "error", err)
+		http.Error(w, "failed to get palette", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl := s.getTemplate("admin_palette.html")
+	tmpl.Execute(w, AdminPaletteData{
+		Palette: palette,
+		Policy:  s.statusPolicy,
+		Error:   r.URL.Query().Get("error"),
+	})
+}
+
+// HandleSavePaletteStatus adds a status to the palette, or updates its label and category.
+func (s *Server) HandleSavePaletteStatus(w http.ResponseWriter, r *http.Request) {
+	status := PaletteStatus{
+		Status:   strings.TrimSpace(r.FormValue("status")),
+		Label:    strings.TrimSpace(r.FormValue("label")),
+		Category: strings.TrimSpace(r.FormValue("category")),
+	}
+	if err := ValidateStatus(status.Status); err != nil {
+		redirectToAdminPalette(w, r, err.Error())
+		return
+	}
+	if status.Label == "" || status.Category == "" {
+		redirectToAdminPalette(w, r, "a label and category are needed")
+		return
+	}
+
+	if err := s.store.SavePaletteStatus(r.Context(), status); err != nil {
+		slog.Error("save palette status", "error", err)
+		redirectToAdminPalette(w, r, "failed to save status")
+		return
+	}
+	slog.Info("palette status saved", "status", status.Status, "label", status.Label, "category", status.Category)
+	redirectToAdminPalette(w, r, "")
+}
+
+// HandleDeletePaletteStatus removes a status from the palette. Statuses that have already
+// been posted aren't affected.
+func (s *Server) HandleDeletePaletteStatus(w http.ResponseWriter, r *http.Request) {
+	status := r.FormValue("status")
+	if err := s.store.DeletePaletteStatus(r.Context(), status); err != nil {
+		slog.Error("delete palette status", "error", err)
+		redirectToAdminPalette(w, r, "failed to delete status")
+		return
+	}
+	slog.Info("palette status deleted", "status", status)
+	redirectToAdminPalette(w, r, "")
+}
+
+func redirectToAdminPalette(w http.ResponseWriter, r *http.Request, errMsg string) {
+	target := "/admin/palette"
+	if errMsg != "" {
+		target += "?" + url.Values{"error": []string{errMsg}}.Encode()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,65 @@
+package statusphere
+
+import "testing"
+
+func TestIsSingleEmoji(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		expected bool
+	}{
+		{name: "emoji", s: "👍", expected: true},
+		{name: "emoji with variation selector", s: "❤️", expected: true},
+		{name: "skin tone modifier", s: "👍🏽", expected: true},
+		{name: "zero width joined family", s: "👨‍👩‍👧‍👦", expected: true},
+		{name: "zero width joined with skin tone", s: "🧑🏿‍💻", expected: true},
+		{name: "flag", s: "🇬🇧", expected: true},
+		{name: "subdivision flag", s: "🏴󠁧󠁢󠁳󠁣󠁴󠁿", expected: true},
+		{name: "keycap", s: "1️⃣", expected: true},
+		{name: "keycap without variation selector", s: "#⃣", expected: true},
+		{name: "empty", s: "", expected: false},
+		{name: "two emoji", s: "👍👍", expected: false},
+		{name: "text", s: "hi", expected: false},
+		{name: "text and emoji", s: "hi👍", expected: false},
+		{name: "emoji and text", s: "👍hi", expected: false},
+		{name: "lone skin tone modifier", s: "🏽", expected: false},
+		{name: "lone regional indicator", s: "🇬", expected: false},
+		{name: "three regional indicators", s: "🇬🇧🇬", expected: false},
+		{name: "trailing zero width joiner", s: "👍‍", expected: false},
+		{name: "digit without keycap", s: "1", expected: false},
+		{name: "invalid UTF-8", s: "\xff", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSingleEmoji(tt.s); got != tt.expected {
+				t.Errorf("isSingleEmoji(%q) = %t, expected %t", tt.s, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsPictographic(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        rune
+		expected bool
+	}{
+		{name: "emoji", r: '👍', expected: true},
+		{name: "dingbat", r: '✊', expected: true},
+		{name: "copyright sign", r: '©', expected: true},
+		{name: "letter", r: 'a', expected: false},
+		{name: "digit", r: '1', expected: false},
+		{name: "skin tone modifier", r: '🏽', expected: false},
+		{name: "regional indicator", r: '🇬', expected: false},
+		{name: "zero width joiner", r: '‍', expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPictographic(tt.r); got != tt.expected {
+				t.Errorf("isPictographic(%U) = %t, expected %t", tt.r, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package statusphere
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The limits on how often users can do things that make the app call out to other
+// services, as a rate that tokens are added to a bucket and the size of the bucket.
+var (
+	// statusDIDRateLimit limits each account to bursts of 5 statuses and then one every 10
+	// seconds, as each status is a createRecord call to their PDS.
+	statusDIDRateLimit = rateLimit{limit: rate.Every(time.Second * 10), burst: 5}
+	// statusIPRateLimit is looser than the limit for each account, as several people can
+	// share an IP address.
+	statusIPRateLimit = rateLimit{limit: rate.Every(time.Second * 2), burst: 20}
+	// loginIPRateLimit limits logins, each of which resolves a handle and starts an OAuth
+	// flow with the account's authorization server.
+	loginIPRateLimit = rateLimit{limit: rate.Every(time.Second * 6), burst: 10}
+)
+
+type rateLimit struct {
+	limit rate.Limit
+	burst int
+}
+
+// RateLimiter limits how often something can be done for each key, such as a DID or an IP
+// address, with a token bucket for each key.
+type RateLimiter interface {
+	// Reserve takes a token from the key's bucket, which can be put back by cancelling the
+	// reservation. If the bucket is empty no token is taken.
+	Reserve(ctx context.Context, key string) (RateLimitReservation, error)
+}
+
+// RateLimitReservation is the result of taking a token from a bucket.
+type RateLimitReservation struct {
+	// OK is set if a token was taken. Otherwise the bucket was empty and RetryAfter is how
+	// long until there's a token.
+	OK         bool
+	RetryAfter time.Duration
+
+	cancel func(ctx context.Context) error
+}
+
+// Cancel puts the token back in the bucket, if one was taken.
+func (r RateLimitReservation) Cancel(ctx context.Context) error {
+	if !r.OK || r.cancel == nil {
+		return nil
+	}
+	return r.cancel(ctx)
+}
+
+// memoryRateLimiterPurgeInterval is how often buckets that have refilled are forgotten, as
+// a full bucket is the same as a new one.
+const memoryRateLimiterPurgeInterval = time.Minute
+
+type memoryBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+type memoryRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu         sync.Mutex
+	buckets    map[string]*memoryBucket
+	lastPurged time.Time
+}
+
+// NewMemoryRateLimiter creates a rate limiter that keeps its buckets in memory, so each
+// process limits separately.
+func NewMemoryRateLimiter(limit rate.Limit, burst int) RateLimiter {
+	return &memoryRateLimiter{
+		limit:   limit,
+		burst:   burst,
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (m *memoryRateLimiter) Reserve(_ context.Context, key string) (RateLimitReservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.purge(now)
+
+	bucket, ok := m.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{limiter: rate.NewLimiter(m.limit, m.burst)}
+		m.buckets[key] = bucket
+	}
+	bucket.lastUsed = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return RateLimitReservation{RetryAfter: delay}, nil
+	}
+	return RateLimitReservation{
+		OK: true,
+		cancel: func(context.Context) error {
+			// cancelling as of when the token was taken, as a reservation can't be
+			// cancelled once it's been acted on
+			reservation.CancelAt(now)
+			return nil
+		},
+	}, nil
+}
+
+// purge forgets the buckets that have had time to refill since they were last used.
+func (m *memoryRateLimiter) purge(now time.Time) {
+	if now.Sub(m.lastPurged) < memoryRateLimiterPurgeInterval {
+		return
+	}
+	m.lastPurged = now
+
+	refill := time.Duration(float64(m.burst) / float64(m.limit) * float64(time.Second))
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.lastUsed) > refill {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// RateLimitStore stores token buckets so that processes sharing the store share limits.
+type RateLimitStore interface {
+	TakeRateLimitToken(ctx context.Context, key string, now time.Time, limit rate.Limit, burst int) (bool, time.Duration, error)
+	ReturnRateLimitToken(ctx context.Context, key string, burst int) error
+}
+
+type storeRateLimiter struct {
+	store RateLimitStore
+	name  string
+	limit rate.Limit
+	burst int
+}
+
+// NewStoreRateLimiter creates a rate limiter that keeps its buckets in a store, so that
+// every process using the store shares the limit. The name keeps the buckets of different
+// limits apart.
+func NewStoreRateLimiter(store RateLimitStore, name string, limit rate.Limit, burst int) RateLimiter {
+	return &storeRateLimiter{
+		store: store,
+		name:  name,
+		limit: limit,
+		burst: burst,
+	}
+}
+
+func (s *storeRateLimiter) Reserve(ctx context.Context, key string) (RateLimitReservation, error) {
+	key = s.name + ":" + key
+	allowed, retryAfter, err := s.store.TakeRateLimitToken(ctx, key, time.Now(), s.limit, s.burst)
+	if err != nil {
+		return RateLimitReservation{}, err
+	}
+	if !allowed {
+		return RateLimitReservation{RetryAfter: retryAfter}, nil
+	}
+	return RateLimitReservation{
+		OK: true,
+		cancel: func(ctx context.Context) error {
+			return s.store.ReturnRateLimitToken(ctx, key, s.burst)
+		},
+	}, nil
+}
+
+// rateLimiters are the limits the server applies.
+type rateLimiters struct {
+	statusByDID RateLimiter
+	statusByIP  RateLimiter
+	loginByIP   RateLimiter
+}
+
+func newMemoryRateLimiters() rateLimiters {
+	return rateLimiters{
+		statusByDID: NewMemoryRateLimiter(statusDIDRateLimit.limit, statusDIDRateLimit.burst),
+		statusByIP:  NewMemoryRateLimiter(statusIPRateLimit.limit, statusIPRateLimit.burst),
+		loginByIP:   NewMemoryRateLimiter(loginIPRateLimit.limit, loginIPRateLimit.burst),
+	}
+}
+
+func newStoreRateLimiters(store RateLimitStore) rateLimiters {
+	return rateLimiters{
+		statusByDID: NewStoreRateLimiter(store, "status-did", statusDIDRateLimit.limit, statusDIDRateLimit.burst),
+		statusByIP:  NewStoreRateLimiter(store, "status-ip", statusIPRateLimit.limit, statusIPRateLimit.burst),
+		loginByIP:   NewStoreRateLimiter(store, "login-ip", loginIPRateLimit.limit, loginIPRateLimit.burst),
+	}
+}
+
+type rateLimitCheck struct {
+	limiter RateLimiter
+	key     string
+}
+
+// checkRateLimits takes a token for each key from its limiter, returning how long to wait
+// if one of them is limited. The tokens are only kept if every limiter allows the request,
+// so that a request that's turned away doesn't use up the other limits. If a limiter fails
+// the request is let through rather than locking everyone out.
+func checkRateLimits(ctx context.Context, checks ...rateLimitCheck) (bool, time.Duration) {
+	reservations := make([]RateLimitReservation, 0, len(checks))
+	for _, check := range checks {
+		reservation, err := check.limiter.Reserve(ctx, check.key)
+		if err != nil {
+			slog.Error("check rate limit", "key", check.key, "error", err)
+			continue
+		}
+		if !reservation.OK {
+			for _, taken := range reservations {
+				if err := taken.Cancel(ctx); err != nil {
+					slog.Error("cancel rate limit reservation", "error", err)
+				}
+			}
+			return false, reservation.RetryAfter
+		}
+		reservations = append(reservations, reservation)
+	}
+	return true, 0
+}
+
+// clientIP returns the IP address of the client that made the request. If the server is
+// behind a proxy, the header the proxy puts the client's address in can be configured, and
+// otherwise forwarding headers are ignored as any client can set them. For X-Forwarded-For
+// the last address is used, as that's the one the proxy added. A header that doesn't hold
+// an IP address is ignored.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.clientIPHeader != "" {
+		values := strings.Split(r.Header.Get(s.clientIPHeader), ",")
+		if ip := net.ParseIP(strings.TrimSpace(values[len(values)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// setRetryAfter sets the Retry-After header for a rate limited response and returns a
+// message saying when to try again.
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) string {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	if seconds == 1 {
+		return "try again in a second"
+	}
+	return fmt.Sprintf("try again in %d seconds", seconds)
+}
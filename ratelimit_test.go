@@ -0,0 +1,94 @@
+package statusphere
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// TestCheckRateLimitsCancelsReservations checks that when one limiter turns a request away,
+// the tokens taken from the other limiters are put back.
+func TestCheckRateLimitsCancelsReservations(t *testing.T) {
+	ctx := context.Background()
+	byIP := NewMemoryRateLimiter(rate.Every(time.Hour), 2)
+	byDID := NewMemoryRateLimiter(rate.Every(time.Hour), 1)
+	checks := []rateLimitCheck{
+		{limiter: byIP, key: "192.0.2.1"},
+		{limiter: byDID, key: "did:plc:alice"},
+	}
+
+	if allowed, _ := checkRateLimits(ctx, checks...); !allowed {
+		t.Fatal("expected the first request to be allowed")
+	}
+	allowed, retryAfter := checkRateLimits(ctx, checks...)
+	if allowed || retryAfter <= 0 {
+		t.Fatalf("expected the second request to be limited by DID, got %t and %s", allowed, retryAfter)
+	}
+
+	// the IP's second token was put back, so another account can use it
+	if allowed, _ := checkRateLimits(ctx, rateLimitCheck{limiter: byIP, key: "192.0.2.1"}); !allowed {
+		t.Fatal("expected the IP's token to have been put back")
+	}
+	if allowed, _ := checkRateLimits(ctx, rateLimitCheck{limiter: byIP, key: "192.0.2.1"}); allowed {
+		t.Fatal("expected the IP to be limited once its tokens are used")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "no header configured",
+			expected: "192.0.2.1",
+		},
+		{
+			name:     "forwarding headers ignored when not configured",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.7", "Fly-Client-IP": "198.51.100.7"},
+			expected: "192.0.2.1",
+		},
+		{
+			name:     "configured header",
+			header:   "Fly-Client-IP",
+			headers:  map[string]string{"Fly-Client-IP": "198.51.100.7"},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "last X-Forwarded-For address",
+			header:   "X-Forwarded-For",
+			headers:  map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7"},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "configured header missing",
+			header:   "Fly-Client-IP",
+			expected: "192.0.2.1",
+		},
+		{
+			name:     "configured header not an IP address",
+			header:   "Fly-Client-IP",
+			headers:  map[string]string{"Fly-Client-IP": "not an ip"},
+			expected: "192.0.2.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{clientIPHeader: tt.header}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := s.clientIP(r); got != tt.expected {
+				t.Errorf("expected client IP %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
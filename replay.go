@@ -0,0 +1,121 @@
+package statusphere
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/jetstream/pkg/client"
+	"github.com/bluesky-social/jetstream/pkg/models"
+)
+
+const maxReplayLineSize = 1024 * 1024
+
+// eventRecorder writes events as newline delimited JSON.
+type eventRecorder struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *eventRecorder) Record(event *models.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	b = append(b, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.w.Write(b)
+	return err
+}
+
+// recordingScheduler records each event before passing it on to be handled.
+type recordingScheduler struct {
+	client.Scheduler
+	recorder *eventRecorder
+}
+
+func (s *recordingScheduler) AddWork(ctx context.Context, repo string, evt *models.Event) error {
+	if err := s.recorder.Record(evt); err != nil {
+		// losing a recorded event shouldn't stop the event being handled
+		slog.Error("failed to record event", "error", err, "did", evt.Did)
+	}
+	return s.Scheduler.AddWork(ctx, repo, evt)
+}
+
+// Replay reads newline delimited jetstream events from r, such as those written by a
+// consumer using WithRecorder, and handles them using the same scheduler and handler as
+// Consume. It returns the number of events replayed.
+//
+// speed controls how quickly events are replayed relative to when they were originally
+// received, using the gaps between their times. A speed of 1 replays in real time, 2 at
+// twice the speed and so on. A speed of 0 replays events as fast as possible.
+func (c *consumer) Replay(ctx context.Context, r io.Reader, speed float64) (int, error) {
+	scheduler, shutdown := c.newScheduler()
+	defer shutdown()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxReplayLineSize)
+
+	var start time.Time
+	var firstEventUS int64
+
+	count := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var event models.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return count, fmt.Errorf("unmarshal event on line %d: %w", line, err)
+		}
+
+		if speed > 0 {
+			if count == 0 {
+				start = time.Now()
+				firstEventUS = event.TimeUS
+			}
+			offset := time.Duration(float64(event.TimeUS-firstEventUS)/speed) * time.Microsecond
+			if err := sleepUntil(ctx, start.Add(offset)); err != nil {
+				return count, err
+			}
+		}
+
+		if err := scheduler.AddWork(ctx, event.Did, &event); err != nil {
+			return count, fmt.Errorf("add work to scheduler: %w", err)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("read events: %w", err)
+	}
+
+	return count, nil
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package statusphere_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/database"
+)
+
+var eventsFile = flag.String("events", "testdata/events.jsonl", "newline delimited Jetstream events to benchmark handling, such as those recorded with JS_RECORD_FILE")
+
+// BenchmarkHandleEvents replays events through the consumer using each scheduler and
+// batching configuration, reporting how many events per second each one could store.
+func BenchmarkHandleEvents(b *testing.B) {
+	events, err := os.ReadFile(*eventsFile)
+	if err != nil {
+		b.Fatalf("read events: %s", err)
+	}
+
+	// the handler logs every failure so keep the output to the results
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	configs := []struct {
+		name string
+		opts []statusphere.ConsumerOption
+	}{
+		{
+			name: "sequential",
+			opts: []statusphere.ConsumerOption{statusphere.WithScheduler(statusphere.SchedulerSequential, 0)},
+		},
+		{
+			name: "sequential+batched",
+			opts: []statusphere.ConsumerOption{
+				statusphere.WithScheduler(statusphere.SchedulerSequential, 0),
+				statusphere.WithBatchedWrites(100, 0),
+			},
+		},
+		{
+			name: "parallel",
+			opts: []statusphere.ConsumerOption{statusphere.WithScheduler(statusphere.SchedulerParallel, 10)},
+		},
+		{
+			name: "parallel+batched",
+			opts: []statusphere.ConsumerOption{
+				statusphere.WithScheduler(statusphere.SchedulerParallel, 10),
+				statusphere.WithBatchedWrites(100, 0),
+			},
+		},
+	}
+
+	for _, cfg := range configs {
+		b.Run(cfg.name, func(b *testing.B) {
+			total := 0
+			for i := 0; i < b.N; i++ {
+				// every run starts with an empty database so that each one stores the same
+				b.StopTimer()
+				db, err := database.New(path.Join(b.TempDir(), "database.db"))
+				if err != nil {
+					b.Fatalf("create database: %s", err)
+				}
+				consumer, err := statusphere.NewConsumer(nil, logger, db, cfg.opts...)
+				if err != nil {
+					b.Fatalf("create consumer: %s", err)
+				}
+				b.StartTimer()
+
+				count, err := consumer.Replay(context.Background(), bytes.NewReader(events), 0)
+				if err != nil {
+					b.Fatalf("replay: %s", err)
+				}
+				total += count
+
+				b.StopTimer()
+				db.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(total)/b.Elapsed().Seconds(), "events/s")
+		})
+	}
+}
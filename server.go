@@ -6,16 +6,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"text/template"
+	"sync"
+	"time"
 
 	"github.com/gorilla/sessions"
 
 	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 )
 
 var ErrorNotFound = fmt.Errorf("not found")
@@ -27,10 +31,19 @@ type UserProfile struct {
 }
 
 type Store interface {
-	GetHandleAndDisplayNameForDid(did string) (UserProfile, error)
-	CreateProfile(profile UserProfile) error
-	GetStatuses(limit int) ([]Status, error)
-	CreateStatus(status Status) error
+	GetHandleAndDisplayNameForDid(ctx context.Context, did string) (UserProfile, error)
+	CreateProfile(ctx context.Context, profile UserProfile) error
+	GetStatuses(ctx context.Context, limit int) ([]Status, error)
+	GetCurrentStatuses(ctx context.Context, limit int) ([]Status, error)
+	CreateStatus(ctx context.Context, status Status) error
+	ExportStore
+	StatsStore
+	PaletteStore
+	ModerationStore
+	LabelerStore
+	AdminStore
+	SessionStore
+	FollowStore
 }
 
 type Server struct {
@@ -42,9 +55,58 @@ type Server struct {
 	oauthClient *oauth.ClientApp
 	store       Store
 	httpClient  *http.Client
+
+	// adminToken is the bearer token that can be used to download exports without logging in
+	adminToken string
+	// adminDIDs are the accounts that can use the admin pages when they're logged in
+	adminDIDs []string
+
+	// statusPolicy is which statuses users can post, either StatusPolicyPalette or
+	// StatusPolicyEmoji
+	statusPolicy string
+
+	limiters rateLimiters
+	// clientIPHeader is the header a proxy in front of the server puts the client's IP
+	// address in, if there is one
+	clientIPHeader string
+
+	// labelerDID and labelerKey are the DID and signing key of the labeler that publishes
+	// the app's moderation decisions. The labeler is disabled if the key is nil.
+	labelerDID syntax.DID
+	labelerKey crypto.PrivateKey
+
+	// now is the server's clock, which is used for anything that depends on the time
+	now func() time.Time
+
+	// followsQueue has the users whose follows are waiting to be fetched in the background,
+	// and followsQueued the ones in it, so that each user is only queued once
+	followsQueue  chan followsRefresh
+	followsMu     sync.Mutex
+	followsQueued map[syntax.DID]bool
+	// stopBackground stops the work the server does in the background
+	stopBackground context.CancelFunc
+}
+
+// ServerOption configures optional behaviour of the server.
+type ServerOption func(s *Server)
+
+// WithClock makes the server use now as its clock instead of time.Now, so that pages that
+// depend on the time, such as statuses posted today, can be checked at a fixed time.
+func WithClock(now func() time.Time) ServerOption {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithSharedRateLimits makes the server keep its rate limits in the store instead of in
+// memory, so that every server sharing the store shares the limits.
+func WithSharedRateLimits(store RateLimitStore) ServerOption {
+	return func(s *Server) {
+		s.limiters = newStoreRateLimiters(store)
+	}
 }
 
-func NewServer(host string, port int, store Store, oauthClient *oauth.ClientApp, httpClient *http.Client) (*Server, error) {
+func NewServer(host string, port int, store Store, oauthClient *oauth.ClientApp, httpClient *http.Client, opts ...ServerOption) (*Server, error) {
 	sessionStore := sessions.NewCookieStore([]byte(os.Getenv("SESSION_KEY")))
 
 	homeTemplate, err := template.ParseFiles("./html/home.html")
@@ -56,20 +118,70 @@ func NewServer(host string, port int, store Store, oauthClient *oauth.ClientApp,
 		return nil, fmt.Errorf("parsing login template: %w", err)
 	}
 
+	statsTemplate, err := template.ParseFiles("./html/stats.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing stats template: %w", err)
+	}
+	adminPaletteTemplate, err := template.ParseFiles("./html/admin_palette.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing admin palette template: %w", err)
+	}
+	adminModerationTemplate, err := template.ParseFiles("./html/admin_moderation.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing admin moderation template: %w", err)
+	}
+	adminTemplate, err := template.ParseFiles("./html/admin.html")
+	if err != nil {
+		return nil, fmt.Errorf("parsing admin template: %w", err)
+	}
+
+	statusPolicy := os.Getenv("STATUS_POLICY")
+	switch statusPolicy {
+	case "":
+		statusPolicy = StatusPolicyPalette
+	case StatusPolicyPalette, StatusPolicyEmoji:
+	default:
+		return nil, fmt.Errorf("unknown STATUS_POLICY %q", statusPolicy)
+	}
+
+	adminDIDs, err := parseAdminDIDs(os.Getenv("ADMIN_DIDS"))
+	if err != nil {
+		return nil, err
+	}
+
 	templates := []*template.Template{
 		homeTemplate,
 		loginTemplate,
+		statsTemplate,
+		adminPaletteTemplate,
+		adminModerationTemplate,
+		adminTemplate,
 	}
 
 	srv := &Server{
-		host:         host,
-		oauthClient:  oauthClient,
-		sessionStore: sessionStore,
-		templates:    templates,
-		store:        store,
-		httpClient:   httpClient,
+		host:           host,
+		oauthClient:    oauthClient,
+		sessionStore:   sessionStore,
+		templates:      templates,
+		store:          store,
+		httpClient:     httpClient,
+		adminToken:     os.Getenv("ADMIN_TOKEN"),
+		adminDIDs:      adminDIDs,
+		statusPolicy:   statusPolicy,
+		limiters:       newMemoryRateLimiters(),
+		clientIPHeader: os.Getenv("CLIENT_IP_HEADER"),
+		now:            time.Now,
+		followsQueue:   make(chan followsRefresh, followsQueueSize),
+		followsQueued:  make(map[syntax.DID]bool),
+	}
+	for _, opt := range opts {
+		opt(srv)
 	}
 
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	srv.stopBackground = stopBackground
+	go srv.refreshQueuedFollows(backgroundCtx)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", srv.authMiddleware(srv.HandleHome))
 	mux.HandleFunc("POST /status", srv.authMiddleware(srv.HandleStatus))
@@ -77,6 +189,28 @@ func NewServer(host string, port int, store Store, oauthClient *oauth.ClientApp,
 	mux.HandleFunc("GET /login", srv.HandleLogin)
 	mux.HandleFunc("POST /login", srv.HandlePostLogin)
 	mux.HandleFunc("POST /logout", srv.HandleLogOut)
+	mux.HandleFunc("POST /logout/everywhere", srv.authMiddleware(srv.HandleLogOutEverywhere))
+
+	mux.HandleFunc("GET /stats", srv.HandleStats)
+	mux.HandleFunc("GET /api/statuses", srv.HandleAPIStatuses)
+	mux.HandleFunc("GET /api/stats", srv.HandleAPIStats)
+
+	mux.HandleFunc("GET /admin", srv.adminMiddleware(srv.HandleAdminDashboard))
+	mux.HandleFunc("POST /admin/sessions/revoke", srv.adminMiddleware(srv.HandleRevokeSession))
+	mux.HandleFunc("GET /admin/export", srv.exportMiddleware(srv.HandleExport))
+	mux.HandleFunc("GET /admin/palette", srv.adminMiddleware(srv.HandleAdminPalette))
+	mux.HandleFunc("POST /admin/palette", srv.adminMiddleware(srv.HandleSavePaletteStatus))
+	mux.HandleFunc("POST /admin/palette/delete", srv.adminMiddleware(srv.HandleDeletePaletteStatus))
+	mux.HandleFunc("GET /admin/moderation", srv.adminMiddleware(srv.HandleAdminModeration))
+	mux.HandleFunc("POST /admin/moderation/block", srv.adminMiddleware(srv.HandleBlockDID))
+	mux.HandleFunc("POST /admin/moderation/unblock", srv.adminMiddleware(srv.HandleUnblockDID))
+	mux.HandleFunc("POST /admin/moderation/hide", srv.adminMiddleware(srv.HandleHideStatus))
+	mux.HandleFunc("POST /admin/moderation/unhide", srv.adminMiddleware(srv.HandleUnhideStatus))
+
+	if srv.labelerKey != nil {
+		mux.HandleFunc("GET /xrpc/com.atproto.label.queryLabels", srv.HandleQueryLabels)
+		mux.HandleFunc("GET /xrpc/com.atproto.label.subscribeLabels", srv.HandleSubscribeLabels)
+	}
 
 	mux.HandleFunc("/public/app.css", serveCSS)
 	mux.HandleFunc("/jwks.json", srv.serveJwks)
@@ -100,9 +234,16 @@ func (s *Server) Run() {
 }
 
 func (s *Server) Stop(ctx context.Context) error {
+	s.stopBackground()
 	return s.httpserver.Shutdown(ctx)
 }
 
+// Handler returns the handler for all of the server's routes, so that it can be served by
+// something other than Run, such as httptest.
+func (s *Server) Handler() http.Handler {
+	return s.httpserver.Handler
+}
+
 func (s *Server) getTemplate(name string) *template.Template {
 	for _, template := range s.templates {
 		if template.Name() == name {
@@ -140,7 +281,7 @@ func (s *Server) serveClientMetadata(w http.ResponseWriter, r *http.Request) {
 	metadata.ClientName = &clientName
 	metadata.ClientURI = &s.host
 	if s.oauthClient.Config.IsConfidential() {
-		jwksURI := fmt.Sprintf("%s/jwks.json", r.Host)
+		jwksURI := fmt.Sprintf("%s/jwks.json", s.host)
 		metadata.JWKSURI = &jwksURI
 	}
 
@@ -154,8 +295,8 @@ func (s *Server) serveClientMetadata(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(b)
 }
 
-func (s *Server) getUserProfileForDid(did string) (UserProfile, error) {
-	profile, err := s.store.GetHandleAndDisplayNameForDid(did)
+func (s *Server) getUserProfileForDid(ctx context.Context, did string) (UserProfile, error) {
+	profile, err := s.store.GetHandleAndDisplayNameForDid(ctx, did)
 	if err == nil {
 		return UserProfile{
 			Did:         did,
@@ -168,11 +309,11 @@ func (s *Server) getUserProfileForDid(did string) (UserProfile, error) {
 		slog.Error("getting profile from database", "error", err)
 	}
 
-	profile, err = s.lookupUserProfile(did)
+	profile, err = s.lookupUserProfile(ctx, did)
 	if err != nil {
 		return UserProfile{}, fmt.Errorf("looking up profile: %w", err)
 	}
-	err = s.store.CreateProfile(profile)
+	err = s.store.CreateProfile(ctx, profile)
 	if err != nil {
 		slog.Error("store profile", "error", err)
 	}
@@ -180,13 +321,18 @@ func (s *Server) getUserProfileForDid(did string) (UserProfile, error) {
 	return profile, nil
 }
 
-func (s *Server) lookupUserProfile(did string) (UserProfile, error) {
+func (s *Server) lookupUserProfile(ctx context.Context, did string) (UserProfile, error) {
 	params := url.Values{
 		"actor": []string{did},
 	}
 	reqUrl := "https://public.api.bsky.app/xrpc/app.bsky.actor.getProfile?" + params.Encode()
 
-	resp, err := s.httpClient.Get(reqUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqUrl, nil)
+	if err != nil {
+		return UserProfile{}, fmt.Errorf("create http request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
 	if err != nil {
 		return UserProfile{}, fmt.Errorf("make http request: %w", err)
 	}
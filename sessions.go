@@ -0,0 +1,157 @@
+package statusphere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/auth/oauth"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// ErrNoRevocationEndpoint is returned when a session's authorization server doesn't say
+// where tokens can be revoked.
+var ErrNoRevocationEndpoint = errors.New("authorization server has no revocation endpoint")
+
+// OAuthSession is a summary of a logged in user's OAuth session, without its tokens.
+type OAuthSession struct {
+	Did       string
+	SessionID string
+	HostURL   string
+	Scopes    []string
+	// CreatedAt is when the user logged in, in unix milliseconds. It's 0 for sessions created
+	// before it was recorded.
+	CreatedAt int64
+}
+
+// SessionStore lists the OAuth sessions of logged in users.
+type SessionStore interface {
+	GetSessions(ctx context.Context) ([]OAuthSession, error)
+	GetAccountSessions(ctx context.Context, did syntax.DID) ([]OAuthSession, error)
+}
+
+// RevokeSession logs a user out of one of their sessions. Its tokens are revoked with the
+// authorization server, so that they can't be used even if they've been leaked, and then
+// the session is deleted. The session is deleted even if the tokens can't be revoked, in
+// which case the error says so.
+func RevokeSession(ctx context.Context, app *oauth.ClientApp, did syntax.DID, sessionID string) error {
+	sess, err := app.Store.GetSession(ctx, did, sessionID)
+	if err != nil {
+		return fmt.Errorf("get session: %w", err)
+	}
+
+	revokeErr := revokeTokens(ctx, app, sess)
+
+	if err := app.Store.DeleteSession(ctx, did, sessionID); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	if revokeErr != nil {
+		return fmt.Errorf("session deleted but its tokens weren't revoked: %w", revokeErr)
+	}
+	return nil
+}
+
+// revokeTokens revokes the session's refresh token, which also revokes the access tokens
+// issued with it, at the authorization server's revocation endpoint (RFC 7009).
+func revokeTokens(ctx context.Context, app *oauth.ClientApp, sess *oauth.ClientSessionData) error {
+	endpoint, err := revocationEndpoint(ctx, app.Client, sess.AuthServerURL)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{
+		"client_id":       {app.Config.ClientID},
+		"token":           {sess.RefreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+	if sess.RefreshToken == "" {
+		form.Set("token", sess.AccessToken)
+		form.Set("token_type_hint", "access_token")
+	}
+	if app.Config.IsConfidential() {
+		assertion, err := app.Config.NewClientAssertion(sess.AuthServerURL)
+		if err != nil {
+			return fmt.Errorf("create client assertion: %w", err)
+		}
+		form.Set("client_assertion_type", oauth.ClientAssertionJWTBearer)
+		form.Set("client_assertion", assertion)
+	}
+
+	dpopKey, err := crypto.ParsePrivateMultibase(sess.DPoPPrivateKeyMultibase)
+	if err != nil {
+		return fmt.Errorf("parse DPoP key: %w", err)
+	}
+
+	// the authorization server may want a newer DPoP nonce than the one saved with the
+	// session, in which case it sends one to retry with
+	nonce := sess.DPoPAuthServerNonce
+	for attempt := 0; ; attempt++ {
+		proof, err := oauth.NewAuthDPoP(http.MethodPost, endpoint, nonce, dpopKey)
+		if err != nil {
+			return fmt.Errorf("create DPoP proof: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return fmt.Errorf("create revocation request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("DPoP", proof)
+
+		resp, err := app.Client.Do(req)
+		if err != nil {
+			return fmt.Errorf("send revocation request: %w", err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			return nil
+		}
+
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&oauthErr)
+		resp.Body.Close()
+
+		newNonce := resp.Header.Get("DPoP-Nonce")
+		if attempt == 0 && oauthErr.Error == "use_dpop_nonce" && newNonce != "" {
+			nonce = newNonce
+			continue
+		}
+		return fmt.Errorf("revocation request failed (HTTP %d): %s %s", resp.StatusCode, oauthErr.Error, oauthErr.Description)
+	}
+}
+
+// revocationEndpoint gets the revocation endpoint from the authorization server's metadata,
+// which indigo's AuthServerMetadata doesn't include.
+func revocationEndpoint(ctx context.Context, client *http.Client, authServerURL string) (string, error) {
+	metadataURL := strings.TrimSuffix(authServerURL, "/") + "/.well-known/oauth-authorization-server"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("create metadata request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get authorization server metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get authorization server metadata: HTTP %d", resp.StatusCode)
+	}
+
+	var metadata struct {
+		RevocationEndpoint string `json:"revocation_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return "", fmt.Errorf("decode authorization server metadata: %w", err)
+	}
+	if metadata.RevocationEndpoint == "" {
+		return "", ErrNoRevocationEndpoint
+	}
+	return metadata.RevocationEndpoint, nil
+}
@@ -0,0 +1,31 @@
+package statusphere
+
+import "context"
+
+// StatusCount is how many times a status was posted.
+type StatusCount struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// DailyStatusCount is how many times a status was posted on a UTC day, formatted as
+// YYYY-MM-DD.
+type DailyStatusCount struct {
+	Day    string `json:"day"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// DailyUsers is how many different users posted a status on a UTC day.
+type DailyUsers struct {
+	Day   string `json:"day"`
+	Users int    `json:"users"`
+}
+
+// StatsStore queries the stats rollups, which are kept up to date as statuses are stored.
+// Days are UTC and formatted as YYYY-MM-DD.
+type StatsStore interface {
+	GetTopStatuses(ctx context.Context, day string, limit int) ([]StatusCount, error)
+	GetDailyStatusCounts(ctx context.Context, from, to string) ([]DailyStatusCount, error)
+	GetDailyUsers(ctx context.Context, from, to string) ([]DailyUsers, error)
+}
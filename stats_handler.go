@@ -0,0 +1,209 @@
+package statusphere
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultStatsDays = 14
+	maxStatsDays     = 90
+
+	// how many statuses are shown in the most popular today chart and charted over time
+	statsTopStatuses = 10
+	statsTrending    = 5
+)
+
+// Stats are the stats for a range of UTC days, which is what the JSON endpoint returns.
+type Stats struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// TopToday are the most posted statuses on the last day.
+	TopToday    []StatusCount      `json:"topToday"`
+	DailyCounts []DailyStatusCount `json:"dailyCounts"`
+	DailyUsers  []DailyUsers       `json:"dailyUsers"`
+}
+
+// StatsData is what the stats page is rendered with. Bars have a percentage of the largest
+// value in their chart so they can be drawn with CSS.
+type StatsData struct {
+	Days       int
+	TopToday   []StatsBar
+	DailyUsers []StatsBar
+	Trending   []TrendingStatus
+}
+
+type StatsBar struct {
+	Label   string
+	Count   int
+	Percent int
+}
+
+// TrendingStatus is a status that was one of the most posted over the range of days, with
+// how many times it was posted each day.
+type TrendingStatus struct {
+	Status string
+	Total  int
+	Days   []StatsBar
+}
+
+// HandleStats renders the stats page for the last days, which can be set with the days query
+// parameter.
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := s.getStats(w, r)
+	if !ok {
+		return
+	}
+
+	data := StatsData{
+		Days:     len(statsDays(stats.From, stats.To)),
+		TopToday: topTodayBars(stats.TopToday),
+	}
+
+	usersByDay := make(map[string]int, len(stats.DailyUsers))
+	for _, day := range stats.DailyUsers {
+		usersByDay[day.Day] = day.Users
+	}
+	data.DailyUsers = dayBars(stats.From, stats.To, usersByDay)
+
+	// the statuses posted the most over the whole range, in the order they were first seen
+	// in the counts, which are ordered by day then count
+	countsByStatus := make(map[string]map[string]int)
+	totals := make(map[string]int)
+	var order []string
+	for _, count := range stats.DailyCounts {
+		if _, ok := countsByStatus[count.Status]; !ok {
+			countsByStatus[count.Status] = make(map[string]int)
+			order = append(order, count.Status)
+		}
+		countsByStatus[count.Status][count.Day] = count.Count
+		totals[count.Status] += count.Count
+	}
+	for _, status := range topByTotal(order, totals, statsTrending) {
+		data.Trending = append(data.Trending, TrendingStatus{
+			Status: status,
+			Total:  totals[status],
+			Days:   dayBars(stats.From, stats.To, countsByStatus[status]),
+		})
+	}
+
+	tmpl := s.getTemplate("stats.html")
+	tmpl.Execute(w, data)
+}
+
+// HandleAPIStats returns the stats for the last days as JSON, which can be set with the days
+// query parameter.
+func (s *Server) HandleAPIStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := s.getStats(w, r)
+	if !ok {
+		return
+	}
+
+	b, err := json.Marshal(stats)
+	if err != nil {
+		slog.Error("failed to marshal stats", "error", err)
+		http.Error(w, "marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) (Stats, bool) {
+	days := defaultStatsDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		parsed, err := strconv.Atoi(d)
+		if err != nil || parsed < 1 || parsed > maxStatsDays {
+			http.Error(w, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+			return Stats{}, false
+		}
+		days = parsed
+	}
+
+	now := s.now().UTC()
+	stats := Stats{
+		From: now.AddDate(0, 0, -(days - 1)).Format(time.DateOnly),
+		To:   now.Format(time.DateOnly),
+	}
+
+	var err error
+	stats.TopToday, err = s.store.GetTopStatuses(r.Context(), stats.To, statsTopStatuses)
+	if err == nil {
+		stats.DailyCounts, err = s.store.GetDailyStatusCounts(r.Context(), stats.From, stats.To)
+	}
+	if err == nil {
+		stats.DailyUsers, err = s.store.GetDailyUsers(r.Context(), stats.From, stats.To)
+	}
+	if err != nil {
+		slog.Error("get stats", "error", err)
+		http.Error(w, "failed to get stats", http.StatusInternalServerError)
+		return Stats{}, false
+	}
+
+	return stats, true
+}
+
+func topTodayBars(counts []StatusCount) []StatsBar {
+	bars := make([]StatsBar, 0, len(counts))
+	for _, count := range counts {
+		bars = append(bars, StatsBar{Label: count.Status, Count: count.Count})
+	}
+	return withPercents(bars)
+}
+
+// dayBars has a bar for every day from the first day to the last, including days without a
+// count.
+func dayBars(from, to string, counts map[string]int) []StatsBar {
+	days := statsDays(from, to)
+	bars := make([]StatsBar, 0, len(days))
+	for _, day := range days {
+		bars = append(bars, StatsBar{Label: day, Count: counts[day]})
+	}
+	return withPercents(bars)
+}
+
+func withPercents(bars []StatsBar) []StatsBar {
+	largest := 0
+	for _, bar := range bars {
+		largest = max(largest, bar.Count)
+	}
+	if largest == 0 {
+		return bars
+	}
+	for i := range bars {
+		bars[i].Percent = bars[i].Count * 100 / largest
+	}
+	return bars
+}
+
+func statsDays(from, to string) []string {
+	start, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return nil
+	}
+	end, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		return nil
+	}
+
+	var days []string
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format(time.DateOnly))
+	}
+	return days
+}
+
+// topByTotal returns up to n of the statuses with the largest totals, keeping the order of
+// statuses with the same total.
+func topByTotal(statuses []string, totals map[string]int, n int) []string {
+	sorted := slices.Clone(statuses)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return totals[b] - totals[a]
+	})
+	return sorted[:min(n, len(sorted))]
+}
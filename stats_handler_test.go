@@ -0,0 +1,67 @@
+package statusphere_test
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/willdot/statusphere-go"
+	"github.com/willdot/statusphere-go/internal/testapp"
+)
+
+func getStats(t *testing.T, app *testapp.App) statusphere.Stats {
+	t.Helper()
+
+	resp, body := app.Get(t, "/api/stats?days=1")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get stats: %d: %s", resp.StatusCode, body)
+	}
+	var stats statusphere.Stats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatalf("decode stats: %s", err)
+	}
+	return stats
+}
+
+// TestAPIStatsCountStatusOnce checks that a status is counted once, even though a diverged
+// copy replaced it and was then replaced by it.
+func TestAPIStatsCountStatusOnce(t *testing.T) {
+	app := testapp.New(t)
+
+	original := testapp.NewStatus(testapp.DID, statusphere.DefaultPalette[0].Status)
+	original.CID = "bafyreib2rxk3rybk3aobmv5cjuql3bm2twh4jo5uxgf5ak3oltptnoc2vi"
+	diverged := original
+	diverged.Status = statusphere.DefaultPalette[2].Status
+	diverged.CID = "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	for _, status := range []statusphere.Status{original, diverged, original} {
+		app.CreateStatus(t, status)
+	}
+
+	stats := getStats(t, app)
+	expected := []statusphere.StatusCount{{Status: original.Status, Count: 1}}
+	if !slices.Equal(stats.TopToday, expected) {
+		t.Fatalf("expected today's top statuses to be %+v, got %+v", expected, stats.TopToday)
+	}
+	if len(stats.DailyUsers) != 1 || stats.DailyUsers[0].Users != 1 {
+		t.Fatalf("expected one user today, got %+v", stats.DailyUsers)
+	}
+}
+
+// TestStatsPageEscapesStatuses stores a status that isn't in the palette, as statuses from
+// other apps can be anything, and checks the stats page escapes it.
+func TestStatsPageEscapesStatuses(t *testing.T) {
+	app := testapp.New(t)
+	script := `<script>alert("hi")</script>`
+	app.CreateStatus(t, testapp.NewStatus(testapp.DID, script))
+
+	_, body := app.Get(t, "/stats")
+	if strings.Contains(body, script) {
+		t.Fatal("expected the status to be escaped on the stats page")
+	}
+	if !strings.Contains(body, html.EscapeString(script)) {
+		t.Fatal("expected the stats page to show the status")
+	}
+}
@@ -1,15 +1,42 @@
 package statusphere
 
 type Status struct {
-	URI       string
-	Did       string
-	Status    string
-	CreatedAt int64
-	IndexedAt int64
+	URI       string `json:"uri"`
+	Did       string `json:"did"`
+	Status    string `json:"status"`
+	CreatedAt int64  `json:"createdAt"`
+	IndexedAt int64  `json:"indexedAt"`
+	// CID is the CID of the record, if it's known.
+	CID string `json:"cid,omitempty"`
+	// Rev is the revision of the repo the record was seen at, if it's known.
+	Rev string `json:"rev,omitempty"`
+	// DivergedCID is the CID of a different copy of the record that was stored before this
+	// one, such as the copy created by the app when it differs from the one on the network.
+	// It's empty if the copies never differed.
+	DivergedCID string `json:"divergedCid,omitempty"`
+	// EffectiveAt is when the status is treated as having been created, which is CreatedAt
+	// unless that isn't plausible, in which case Flag says why. Both are worked out from
+	// CreatedAt and IndexedAt when the status is stored.
+	EffectiveAt int64  `json:"-"`
+	Flag        string `json:"-"`
+	// Labels are the values of the labels that apply to the status or its account. They're
+	// only set for statuses being shown.
+	Labels []string `json:"-"`
+}
+
+// StrongRef is a reference to an exact version of a record, as in com.atproto.repo.strongRef.
+type StrongRef struct {
+	URI string `json:"uri"`
+	CID string `json:"cid"`
 }
 
 type CreateRecordResp struct {
-	URI     string `json:"uri"`
+	URI    string `json:"uri"`
+	CID    string `json:"cid"`
+	Commit *struct {
+		CID string `json:"cid"`
+		Rev string `json:"rev"`
+	} `json:"commit"`
 	ErrStr  string `json:"error"`
 	Message string `json:"message"`
 }
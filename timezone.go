@@ -0,0 +1,80 @@
+package statusphere
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	// viewers' time zones are loaded by name, which needs the time zone database even on
+	// hosts that don't have one installed
+	_ "time/tzdata"
+)
+
+// timezoneCookie is set by the script on the home page to the viewer's IANA time zone, such
+// as Asia/Tokyo, so that statuses can be dated in the viewer's time zone.
+const timezoneCookie = "tz"
+
+// StatusTime is when a status was created, described for someone viewing it.
+type StatusTime struct {
+	// Date is the day the status was created in the viewer's time zone.
+	Date    string
+	IsToday bool
+	// Ago is how long ago the status was created, such as "5 minutes ago".
+	Ago string
+	// Timestamp is when the status was created in RFC 3339, so that the page's script can
+	// format it in the viewer's locale.
+	Timestamp string
+}
+
+// viewerLocation returns the time zone in the viewer's cookie, or UTC if they don't have one
+// or it isn't a time zone.
+func viewerLocation(r *http.Request) *time.Location {
+	cookie, err := r.Cookie(timezoneCookie)
+	if err != nil || cookie.Value == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(cookie.Value)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// describeStatusTime describes when a status was created for a viewer in the time zone, as
+// of now.
+func describeStatusTime(createdAt, now time.Time, loc *time.Location) StatusTime {
+	date := createdAt.In(loc).Format(time.DateOnly)
+	return StatusTime{
+		Date:      date,
+		IsToday:   date == now.In(loc).Format(time.DateOnly),
+		Ago:       timeAgo(createdAt, now),
+		Timestamp: createdAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// timeAgo describes how long before now t was, in the largest whole unit.
+func timeAgo(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		// including times slightly in the future, from clocks that are a little ahead
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute") + " ago"
+	case d < time.Hour*24:
+		return plural(int(d/time.Hour), "hour") + " ago"
+	case d < time.Hour*24*30:
+		return plural(int(d/(time.Hour*24)), "day") + " ago"
+	case d < time.Hour*24*365:
+		return plural(int(d/(time.Hour*24*30)), "month") + " ago"
+	default:
+		return plural(int(d/(time.Hour*24*365)), "year") + " ago"
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,142 @@
+package statusphere
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load location %s: %s", name, err)
+	}
+	return loc
+}
+
+func TestDescribeStatusTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		now       time.Time
+		location  string
+		expected  StatusTime
+	}{
+		{
+			name:      "yesterday in UTC",
+			createdAt: time.Date(2026, time.March, 10, 23, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 11, 0, 10, 0, 0, time.UTC),
+			location:  "UTC",
+			expected:  StatusTime{Date: "2026-03-10", IsToday: false, Ago: "40 minutes ago", Timestamp: "2026-03-10T23:30:00Z"},
+		},
+		{
+			name:      "today ahead of UTC",
+			createdAt: time.Date(2026, time.March, 10, 23, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 11, 0, 10, 0, 0, time.UTC),
+			location:  "Asia/Tokyo",
+			expected:  StatusTime{Date: "2026-03-11", IsToday: true, Ago: "40 minutes ago", Timestamp: "2026-03-10T23:30:00Z"},
+		},
+		{
+			name:      "today behind UTC",
+			createdAt: time.Date(2026, time.March, 10, 23, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 11, 0, 10, 0, 0, time.UTC),
+			location:  "America/Los_Angeles",
+			expected:  StatusTime{Date: "2026-03-10", IsToday: true, Ago: "40 minutes ago", Timestamp: "2026-03-10T23:30:00Z"},
+		},
+		{
+			name:      "yesterday behind UTC",
+			createdAt: time.Date(2026, time.March, 11, 6, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 11, 7, 30, 0, 0, time.UTC),
+			location:  "America/Los_Angeles",
+			expected:  StatusTime{Date: "2026-03-10", IsToday: false, Ago: "1 hour ago", Timestamp: "2026-03-11T06:30:00Z"},
+		},
+		{
+			// clocks go forward at 2am, so 1:30am to 3:30am is only an hour
+			name:      "across clocks going forward",
+			createdAt: time.Date(2026, time.March, 8, 6, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 8, 7, 30, 0, 0, time.UTC),
+			location:  "America/New_York",
+			expected:  StatusTime{Date: "2026-03-08", IsToday: true, Ago: "1 hour ago", Timestamp: "2026-03-08T06:30:00Z"},
+		},
+		{
+			// clocks go back at 2am, so both times are 1:30am
+			name:      "across clocks going back",
+			createdAt: time.Date(2026, time.October, 25, 0, 30, 0, 0, time.UTC),
+			now:       time.Date(2026, time.October, 25, 1, 30, 0, 0, time.UTC),
+			location:  "Europe/London",
+			expected:  StatusTime{Date: "2026-10-25", IsToday: true, Ago: "1 hour ago", Timestamp: "2026-10-25T00:30:00Z"},
+		},
+		{
+			name:      "just before midnight as clocks go forward",
+			createdAt: time.Date(2026, time.March, 28, 23, 59, 0, 0, time.UTC),
+			now:       time.Date(2026, time.March, 29, 0, 1, 0, 0, time.UTC),
+			location:  "Europe/London",
+			expected:  StatusTime{Date: "2026-03-28", IsToday: false, Ago: "2 minutes ago", Timestamp: "2026-03-28T23:59:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeStatusTime(tt.createdAt, tt.now, mustLoadLocation(t, tt.location))
+			if got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTimeAgo(t *testing.T) {
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		before   time.Duration
+		expected string
+	}{
+		{before: -time.Second * 30, expected: "just now"},
+		{before: time.Second * 59, expected: "just now"},
+		{before: time.Minute, expected: "1 minute ago"},
+		{before: time.Minute * 59, expected: "59 minutes ago"},
+		{before: time.Hour, expected: "1 hour ago"},
+		{before: time.Hour * 23, expected: "23 hours ago"},
+		{before: time.Hour * 24, expected: "1 day ago"},
+		{before: time.Hour * 24 * 29, expected: "29 days ago"},
+		{before: time.Hour * 24 * 30, expected: "1 month ago"},
+		{before: time.Hour * 24 * 364, expected: "12 months ago"},
+		{before: time.Hour * 24 * 365, expected: "1 year ago"},
+		{before: time.Hour * 24 * 365 * 3, expected: "3 years ago"},
+	}
+
+	for _, tt := range tests {
+		if got := timeAgo(now.Add(-tt.before), now); got != tt.expected {
+			t.Errorf("timeAgo %s before = %q, expected %q", tt.before, got, tt.expected)
+		}
+	}
+}
+
+func TestViewerLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		// cookie is the Cookie header sent, if any
+		cookie   string
+		expected string
+	}{
+		{name: "no cookie", expected: "UTC"},
+		{name: "empty cookie", cookie: "tz=", expected: "UTC"},
+		{name: "time zone", cookie: "tz=Asia/Tokyo", expected: "Asia/Tokyo"},
+		{name: "not a time zone", cookie: "tz=Mars/Olympus_Mons", expected: "UTC"},
+		{name: "path outside the time zone database", cookie: "tz=../../etc/passwd", expected: "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.cookie != "" {
+				r.Header.Set("Cookie", tt.cookie)
+			}
+			if got := viewerLocation(r).String(); got != tt.expected {
+				t.Errorf("expected location %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}